@@ -15,9 +15,18 @@
 package oanda
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
 )
 
+// ErrAccountNotInEnvironment is returned by VerifyAccount() if an account is not accessible with
+// the credentials and environment of the client.
+var ErrAccountNotInEnvironment = errors.New("account does not belong to the environment of the client")
+
 // Account represents an Oanda account.
 type Account struct {
 	AccountId       int      `json:"accountId"`
@@ -26,7 +35,7 @@ type Account struct {
 	UnrealizedPl    float64  `json:"unrealizedPl"`
 	RealizedPl      float64  `json:"realizedPl"`
 	MarginUsed      float64  `json:"marginUsed"`
-	MarginAvailable float64  `json:"marginAvailable"`
+	MarginAvailable float64  `json:"marginAvail"`
 	OpenTrades      int      `json:"openTrades"`
 	OpenOrders      int      `json:"openOrders"`
 	Currency        string   `json:"accountCurrency"`
@@ -40,7 +49,8 @@ func (a *Account) String() string {
 		a.Currency)
 }
 
-// Accounts returns a list with all the know accounts.
+// Accounts returns the accounts that are accessible with the credentials of the client.  In the
+// sandbox environment that is the account that was created for the client.
 func (c *Client) Accounts() ([]Account, error) {
 	v := struct {
 		ApiError
@@ -65,3 +75,193 @@ func (c *Client) Account(accountId int) (*Account, error) {
 	}
 	return &acc.Account, nil
 }
+
+// VerifyAccount verifies that the account with the specified accountId is accessible with the
+// credentials and environment of the client, e.g. to guard against using an fxtrade account id
+// with an fxpractice token.  ErrAccountNotInEnvironment is returned if it is not.
+func (c *Client) VerifyAccount(accountId int) error {
+	accs, err := c.Accounts()
+	if err != nil {
+		return err
+	}
+	for _, acc := range accs {
+		if acc.AccountId == accountId {
+			return nil
+		}
+	}
+	return ErrAccountNotInEnvironment
+}
+
+// Bounds of the margin rate that can be set with Client method SetAccountMarginRate().
+const (
+	MinMarginRate = 0.01
+	MaxMarginRate = 1.0
+)
+
+// SetAccountMarginRate changes the margin rate of the account with the specified accountId, e.g.
+// 0.05 for a leverage of 20:1, and returns the margin rate that the Oanda servers applied.
+// ErrNoAccount is returned for account id 0.
+func (c *Client) SetAccountMarginRate(accountId int, marginRate float64) (float64, error) {
+	if accountId <= 0 {
+		return 0, ErrNoAccount
+	}
+	if !(marginRate >= MinMarginRate && marginRate <= MaxMarginRate) {
+		return 0, fmt.Errorf("marginRate %v is not between %v and %v", marginRate,
+			MinMarginRate, MaxMarginRate)
+	}
+	data := url.Values{"marginRate": {strconv.FormatFloat(marginRate, 'f', -1, 64)}}
+	acc := struct {
+		ApiError
+		Account
+	}{}
+	urlStr := fmt.Sprintf("/v1/accounts/%d", accountId)
+	if err := requestAndDecode(c, "PATCH", urlStr, data, &acc); err != nil {
+		return 0, err
+	}
+	return acc.MarginRate, nil
+}
+
+// AccountSummary holds the net asset value and margin health of an account.
+type AccountSummary struct {
+	AccountId       int
+	Currency        string
+	Balance         float64
+	UnrealizedPl    float64
+	MarginUsed      float64
+	MarginAvailable float64
+	OpenTrades      int
+	OpenPositions   int
+
+	// NAV is the net asset value of the account, i.e. its Balance plus UnrealizedPl.
+	NAV float64
+}
+
+// String implements the fmt.Stringer interface.
+func (as *AccountSummary) String() string {
+	return fmt.Sprintf("AccountSummary{AccountId: %d, NAV: %f, MarginUsed: %f}", as.AccountId,
+		as.NAV, as.MarginUsed)
+}
+
+// MarginCloseoutPercent returns the fraction of the margin closeout level that is reached.  Oanda
+// closes out all positions when the NAV falls to half of the margin used, i.e. when
+// MarginCloseoutPercent reaches 1.  An account without margin used returns 0.
+func (as *AccountSummary) MarginCloseoutPercent() float64 {
+	if as.MarginUsed == 0 {
+		return 0
+	}
+	if as.NAV <= 0 {
+		return math.Inf(1)
+	}
+	return as.MarginUsed / 2 / as.NAV
+}
+
+// AccountSummary returns the NAV and margin health of the account with the specified accountId.
+// The count of open positions requires a second request for the positions of the account.
+// ErrNoAccount is returned for account id 0.
+func (c *Client) AccountSummary(accountId int) (*AccountSummary, error) {
+	if accountId <= 0 {
+		return nil, ErrNoAccount
+	}
+	acc, err := c.Account(accountId)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := c.WithAccount(accountId).Positions()
+	if err != nil {
+		return nil, err
+	}
+	return newAccountSummary(acc, len(positions)), nil
+}
+
+func newAccountSummary(acc *Account, openPositions int) *AccountSummary {
+	return &AccountSummary{
+		AccountId:       acc.AccountId,
+		Currency:        acc.Currency,
+		Balance:         acc.Balance,
+		UnrealizedPl:    acc.UnrealizedPl,
+		MarginUsed:      acc.MarginUsed,
+		MarginAvailable: acc.MarginAvailable,
+		OpenTrades:      acc.OpenTrades,
+		OpenPositions:   openPositions,
+		NAV:             acc.Balance + acc.UnrealizedPl,
+	}
+}
+
+// RiskSummary holds the exposure and margin health of an account.  See Client method OpenRisk().
+type RiskSummary struct {
+	AccountSummary
+
+	// Instruments holds the exposure of each open position, ordered by instrument.
+	Instruments []InstrumentRisk
+
+	// Exposure is the sum of the exposures of the open positions in the account currency.
+	Exposure float64
+}
+
+// InstrumentRisk holds the exposure of the open position in an instrument.
+type InstrumentRisk struct {
+	Instrument string
+
+	// NetUnits is the size of the position; it is negative for a short position.
+	NetUnits int
+
+	// Exposure is the notional value of the position at the current midpoint price, converted
+	// into the account currency.
+	Exposure float64
+}
+
+// CloseoutDistance returns the amount by which the NAV can fall before Oanda closes out all
+// positions, see AccountSummary.MarginCloseoutPercent().
+func (rs *RiskSummary) CloseoutDistance() float64 {
+	return rs.NAV - rs.MarginUsed/2
+}
+
+// OpenRisk returns the exposure of the open positions of account accountId together with its
+// margin health.  Positions are valued at current prices and converted into the account currency
+// as by ConvertToHomeCurrency().
+func (c *Client) OpenRisk(accountId int) (*RiskSummary, error) {
+	acc, err := c.Account(accountId)
+	if err != nil {
+		return nil, err
+	}
+	ac := c.WithAccount(accountId)
+	positions, err := ac.Positions()
+	if err != nil {
+		return nil, err
+	}
+	rs := RiskSummary{AccountSummary: *newAccountSummary(acc, len(positions))}
+	if len(positions) == 0 {
+		return &rs, nil
+	}
+
+	c.rates.setHome(accountId, acc.Currency)
+	instrs := make([]string, len(positions))
+	for i, p := range positions {
+		instrs[i] = p.Instrument
+	}
+	prices, err := ac.PollPrices(instrs[0], instrs[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range positions {
+		tick, ok := prices[p.Instrument]
+		if !ok {
+			return nil, fmt.Errorf("no price for %s", p.Instrument)
+		}
+		_, quote := splitInstrument(p.Instrument)
+		exposure, err := c.ConvertToHomeCurrency(accountId, float64(p.Units)*tick.Mid(), quote)
+		if err != nil {
+			return nil, err
+		}
+		units := p.Units
+		if p.Side == string(Sell) {
+			units = -units
+		}
+		rs.Instruments = append(rs.Instruments, InstrumentRisk{p.Instrument, units, exposure})
+		rs.Exposure += exposure
+	}
+	sort.Slice(rs.Instruments, func(i, j int) bool {
+		return rs.Instruments[i].Instrument < rs.Instruments[j].Instrument
+	})
+	return &rs, nil
+}
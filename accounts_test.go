@@ -15,6 +15,8 @@
 package oanda_test
 
 import (
+	"math"
+	"net/http"
 	"testing"
 
 	"github.com/santegoeds/oanda"
@@ -51,3 +53,150 @@ func (ts *TestAccountSuite) TestAccountApi(c *check.C) {
 	c.Assert(acc.Name, check.Equals, "Primary")
 	c.Assert(acc.Currency, check.Equals, "USD")
 }
+
+func (ts *TestLocalSuite) TestAccounts(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Assert(req.URL.Path, check.Equals, "/v1/accounts")
+		return newResponse(http.StatusOK, `{"accounts": [
+			{"accountId": 8954947, "accountName": "Primary", "accountCurrency": "USD",
+			 "marginRate": 0.05},
+			{"accountId": 8954950, "accountName": "SweetHome", "accountCurrency": "CAD",
+			 "marginRate": 0.02}]}`), nil
+	})
+	accs, err := ts.c.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(accs, check.HasLen, 2)
+	c.Assert(accs[1].AccountId, check.Equals, 8954950)
+	c.Assert(accs[1].Name, check.Equals, "SweetHome")
+	c.Assert(accs[1].Currency, check.Equals, "CAD")
+	c.Assert(accs[1].MarginRate, check.Equals, 0.02)
+}
+
+func (ts *TestLocalSuite) TestAccount(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/v1/accounts/8954947" {
+			return newResponse(http.StatusNotFound, `{"code": 1, "message":
+				"Invalid value specified for 'accountId'"}`), nil
+		}
+		return newResponse(http.StatusOK, `{"accountId": 8954947, "accountName": "Primary",
+			"balance": 100000, "unrealizedPl": 1.5, "realizedPl": -2.25, "marginUsed": 50,
+			"marginAvail": 99950, "openTrades": 1, "openOrders": 2, "marginRate": 0.05,
+			"accountCurrency": "USD"}`), nil
+	})
+	acc, err := ts.c.Account(8954947)
+	c.Assert(err, check.IsNil)
+	c.Assert(acc.Balance, check.Equals, 100000.0)
+	c.Assert(acc.UnrealizedPl, check.Equals, 1.5)
+	c.Assert(acc.RealizedPl, check.Equals, -2.25)
+	c.Assert(acc.MarginUsed, check.Equals, 50.0)
+	c.Assert(acc.MarginAvailable, check.Equals, 99950.0)
+	c.Assert(acc.OpenTrades, check.Equals, 1)
+	c.Assert(acc.OpenOrders, check.Equals, 2)
+	c.Assert(acc.MarginRate, check.Equals, 0.05)
+
+	_, err = ts.c.Account(1)
+	apiErr, ok := err.(*oanda.ApiError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(apiErr.Code, check.Equals, 1)
+}
+
+func (ts *TestLocalSuite) TestVerifyAccount(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Assert(req.URL.Path, check.Equals, "/v1/accounts")
+		return newResponse(http.StatusOK, `{"accounts": [{"accountId": 8954947,
+			"accountName": "Primary", "accountCurrency": "USD", "marginRate": 0.05}]}`), nil
+	})
+	c.Assert(ts.c.VerifyAccount(8954947), check.IsNil)
+	c.Assert(ts.c.VerifyAccount(1), check.Equals, oanda.ErrAccountNotInEnvironment)
+}
+
+func (ts *TestLocalSuite) TestSetAccountMarginRate(c *check.C) {
+	n := Counter{}
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		n.Inc()
+		c.Assert(req.Method, check.Equals, "PATCH")
+		c.Assert(req.URL.Path, check.Equals, "/v1/accounts/8954947")
+		c.Assert(req.ParseForm(), check.IsNil)
+		c.Assert(req.PostForm.Get("marginRate"), check.Equals, "0.02")
+		return newResponse(http.StatusOK, `{"accountId": 8954947, "accountName": "Primary",
+			"accountCurrency": "USD", "marginRate": 0.02}`), nil
+	})
+	rate, err := ts.c.SetAccountMarginRate(8954947, 0.02)
+	c.Assert(err, check.IsNil)
+	c.Assert(rate, check.Equals, 0.02)
+
+	for _, rate := range []float64{0, 0.001, 1.5, -0.05, math.NaN()} {
+		_, err = ts.c.SetAccountMarginRate(8954947, rate)
+		c.Assert(err, check.ErrorMatches, "marginRate .* is not between 0.01 and 1")
+	}
+	_, err = ts.c.SetAccountMarginRate(0, 0.02)
+	c.Assert(err, check.Equals, oanda.ErrNoAccount)
+	c.Assert(n.Val(), check.Equals, 1)
+}
+
+func (ts *TestLocalSuite) TestAccountSummary(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/v1/accounts/8954947":
+			return newResponse(http.StatusOK, `{"accountId": 8954947, "balance": 1000,
+				"unrealizedPl": -200, "marginUsed": 400, "marginAvail": 400, "openTrades": 2,
+				"accountCurrency": "USD"}`), nil
+		case "/v1/accounts/8954947/positions":
+			return newResponse(http.StatusOK, `{"positions": [{"side": "buy",
+				"instrument": "EUR_USD", "units": 100, "avgPrice": 1.3}]}`), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "Not found"}`), nil
+	})
+	summary, err := ts.c.AccountSummary(8954947)
+	c.Assert(err, check.IsNil)
+	c.Assert(summary.NAV, check.Equals, 800.0)
+
+	_, err = ts.c.AccountSummary(0)
+	c.Assert(err, check.Equals, oanda.ErrNoAccount)
+	c.Assert(summary.MarginUsed, check.Equals, 400.0)
+	c.Assert(summary.MarginAvailable, check.Equals, 400.0)
+	c.Assert(summary.OpenTrades, check.Equals, 2)
+	c.Assert(summary.OpenPositions, check.Equals, 1)
+	c.Assert(summary.MarginCloseoutPercent(), check.Equals, 0.25)
+
+	c.Assert((&oanda.AccountSummary{NAV: 100}).MarginCloseoutPercent(), check.Equals, 0.0)
+}
+
+func (ts *TestLocalSuite) TestOpenRisk(c *check.C) {
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/v1/accounts/5":
+			return newResponse(http.StatusOK, `{"accountId": 5, "balance": 1100,
+				"unrealizedPl": -100, "marginUsed": 100, "accountCurrency": "USD"}`), nil
+		case "/v1/accounts/5/positions":
+			return newResponse(http.StatusOK, `{"positions": [
+				{"side": "sell", "instrument": "USD_JPY", "units": 2000, "avgPrice": 101},
+				{"side": "buy", "instrument": "EUR_USD", "units": 1000, "avgPrice": 1.3}]}`), nil
+		case "/v1/instruments":
+			return newResponse(http.StatusOK, `{"instruments": [
+				{"instrument": "EUR_USD", "pip": "0.0001"},
+				{"instrument": "USD_JPY", "pip": "0.01"}]}`), nil
+		case "/v1/prices":
+			return newResponse(http.StatusOK, `{"prices": [
+				{"instrument": "EUR_USD", "bid": 1.2499, "ask": 1.2501},
+				{"instrument": "USD_JPY", "bid": 99.99, "ask": 100.01}]}`), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "Not found"}`), nil
+	}))
+
+	rs, err := client.OpenRisk(5)
+	c.Assert(err, check.IsNil)
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	c.Assert(rs.Instruments, check.HasLen, 2)
+	c.Assert(rs.Instruments[0].Instrument, check.Equals, "EUR_USD")
+	c.Assert(rs.Instruments[0].NetUnits, check.Equals, 1000)
+	c.Assert(near(rs.Instruments[0].Exposure, 1250), check.Equals, true)
+	c.Assert(rs.Instruments[1].Instrument, check.Equals, "USD_JPY")
+	c.Assert(rs.Instruments[1].NetUnits, check.Equals, -2000)
+	c.Assert(near(rs.Instruments[1].Exposure, 2000), check.Equals, true)
+	c.Assert(near(rs.Exposure, 3250), check.Equals, true)
+	c.Assert(rs.NAV, check.Equals, 1000.0)
+	c.Assert(rs.OpenPositions, check.Equals, 2)
+	c.Assert(rs.CloseoutDistance(), check.Equals, 950.0)
+	c.Assert(rs.MarginCloseoutPercent(), check.Equals, 0.05)
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// PositionBook and OrderBook
+
+// BookBucket holds the percentages of the long and short positions or orders of a PositionBook
+// or OrderBook whose price is within the bucket width from Price.
+type BookBucket struct {
+	Price             float64 `json:"price,string"`
+	LongCountPercent  float64 `json:"longCountPercent,string"`
+	ShortCountPercent float64 `json:"shortCountPercent,string"`
+}
+
+// PositionBook holds the percentages of the open long and short positions of Oanda clients in
+// price buckets around Price.
+type PositionBook struct {
+	Instrument string `json:"instrument"`
+
+	// Time is the time of the snapshot.  Oanda takes snapshots every 20 minutes, so Time may be
+	// earlier than the time that was requested.
+	Time Time `json:"time"`
+
+	// Price is the price of the instrument at Time.
+	Price float64 `json:"price,string"`
+
+	// BucketWidth is the price range that each bucket covers.
+	BucketWidth float64      `json:"bucketWidth,string"`
+	Buckets     []BookBucket `json:"buckets"`
+}
+
+// OrderBook holds the percentages of the pending long and short orders of Oanda clients in
+// price buckets around Price.  The fields are as for PositionBook.
+type OrderBook PositionBook
+
+// PositionBook returns the position book of instrument at time at, or the most recent position
+// book if at is the zero time.  The position book is served by the v20 REST API, which is not
+// available in the sandbox environment.
+func (c *Client) PositionBook(instrument string, at time.Time) (*PositionBook, error) {
+	v := struct {
+		ApiError
+		PositionBook PositionBook `json:"positionBook"`
+	}{}
+	if err := c.getBook("positionBook", instrument, at, &v); err != nil {
+		return nil, err
+	}
+	return &v.PositionBook, nil
+}
+
+// OrderBook returns the order book of instrument at time at, or the most recent order book if at
+// is the zero time.  See PositionBook().
+func (c *Client) OrderBook(instrument string, at time.Time) (*OrderBook, error) {
+	v := struct {
+		ApiError
+		OrderBook OrderBook `json:"orderBook"`
+	}{}
+	if err := c.getBook("orderBook", instrument, at, &v); err != nil {
+		return nil, err
+	}
+	return &v.OrderBook, nil
+}
+
+func (c *Client) getBook(book, instrument string, at time.Time, vp returnCodeChecker) error {
+	urlStr := fmt.Sprintf("/v3/instruments/%s/%s", strings.ToUpper(instrument), book)
+	if !at.IsZero() {
+		q := url.Values{}
+		optionalArgs(q).SetTime("time", at)
+		urlStr += "?" + q.Encode()
+	}
+	return getAndDecode(c, urlStr, vp)
+}
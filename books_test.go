@@ -0,0 +1,78 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+func (ts *TestLocalSuite) TestPositionBook(c *check.C) {
+	var query []string
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/v3/instruments/EUR_USD/positionBook")
+		query = append(query, req.URL.Query().Get("time"))
+		return newResponse(http.StatusOK, `{"positionBook": {"instrument": "EUR_USD",
+			"time": "2016-11-02T20:00:00Z", "price": "1.11024", "bucketWidth": "0.00050",
+			"buckets": [
+				{"price": "1.10950", "longCountPercent": "0.2218", "shortCountPercent": "0.1339"},
+				{"price": "1.11000", "longCountPercent": "0.3481", "shortCountPercent": "0.4017"}
+			]}}`), nil
+	})
+
+	book, err := ts.c.PositionBook("eur_usd", time.Time{})
+	c.Assert(err, check.IsNil)
+	c.Assert(book.Instrument, check.Equals, "EUR_USD")
+	c.Assert(book.Price, check.Equals, 1.11024)
+	c.Assert(book.BucketWidth, check.Equals, 0.0005)
+	c.Assert(book.Buckets, check.DeepEquals, []oanda.BookBucket{
+		{Price: 1.1095, LongCountPercent: 0.2218, ShortCountPercent: 0.1339},
+		{Price: 1.11, LongCountPercent: 0.3481, ShortCountPercent: 0.4017},
+	})
+
+	// The time of the snapshot is returned rather than the requested time.
+	at := time.Date(2016, 11, 2, 20, 13, 0, 0, time.UTC)
+	book, err = ts.c.PositionBook("eur_usd", at)
+	c.Assert(err, check.IsNil)
+	c.Assert(book.Time.Equal(time.Date(2016, 11, 2, 20, 0, 0, 0, time.UTC)), check.Equals, true)
+	c.Assert(query, check.DeepEquals, []string{"", "2016-11-02T20:13:00Z"})
+}
+
+func (ts *TestLocalSuite) TestOrderBook(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/v3/instruments/USD_JPY/orderBook")
+		c.Check(req.URL.Query().Get("time"), check.Equals, "2016-11-02T20:13:00Z")
+		return newResponse(http.StatusOK, `{"orderBook": {"instrument": "USD_JPY",
+			"time": "2016-11-02T20:00:00Z", "price": "103.310", "bucketWidth": "0.050",
+			"buckets": [
+				{"price": "103.300", "longCountPercent": "0.1234", "shortCountPercent": "0.0456"}
+			]}}`), nil
+	})
+
+	at := time.Date(2016, 11, 2, 20, 13, 0, 0, time.UTC)
+	book, err := ts.c.OrderBook("usd_jpy", at)
+	c.Assert(err, check.IsNil)
+	c.Assert(book.Instrument, check.Equals, "USD_JPY")
+	c.Assert(book.Time.Equal(time.Date(2016, 11, 2, 20, 0, 0, 0, time.UTC)), check.Equals, true)
+	c.Assert(book.Price, check.Equals, 103.31)
+	c.Assert(book.BucketWidth, check.Equals, 0.05)
+	c.Assert(book.Buckets, check.DeepEquals, []oanda.BookBucket{
+		{Price: 103.3, LongCountPercent: 0.1234, ShortCountPercent: 0.0456},
+	})
+}
@@ -15,22 +15,42 @@
 package oanda
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-var debug = false
+// A Logger receives diagnostic messages of a Client.  *log.Logger implements Logger.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// Version is the version of the package that is reported in the User-Agent header.
+const Version = "0.1"
+
+// defaultTimeout is the default time limit of REST requests.  See WithTimeout.
+const defaultTimeout = 30 * time.Second
 
 var (
-	defaultDateFormat  = DateFormat("RFC3339")
+	defaultDateFormat  = DateFormatRFC3339
 	defaultContentType = ContentType("application/x-www-form-urlencoded")
+	defaultUserAgent   = UserAgent("oanda-go/" + Version)
 	defaultTransport   = &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		Dial: (&net.Dialer{
@@ -48,8 +68,10 @@ var (
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 // RequestModifiers
 
-// A requestModifier updates an http.Request before it is passed to an http.Client for execution.
-type requestModifier interface {
+// A RequestModifier updates an http.Request before it is passed to an http.Client for execution.
+// Types that implement the interface are DateFormat, ContentType, UserAgent, Host,
+// EnvironmentConfig, Environment and the authenticators.  See Client method With().
+type RequestModifier interface {
 	modify(*http.Request)
 }
 
@@ -68,6 +90,13 @@ func (a UsernameAuthenticator) modify(req *http.Request) {
 	u.RawQuery = q.Encode()
 }
 
+// UserAgent sets the User-Agent header of requests.
+type UserAgent string
+
+func (ua UserAgent) modify(req *http.Request) {
+	req.Header.Set("User-Agent", string(ua))
+}
+
 type Environment string
 
 func (e Environment) modify(req *http.Request) {
@@ -83,12 +112,63 @@ func (e Environment) modify(req *http.Request) {
 	}
 }
 
+// Host sets the host, and optionally the scheme, of requests instead of the host that is derived
+// from the Environment, e.g. "localhost:8080" or "http://localhost:8080".  See NewClient.
+type Host string
+
+func (h Host) modify(req *http.Request) {
+	s := string(h)
+	if i := strings.Index(s, "://"); i >= 0 {
+		req.URL.Scheme, s = s[:i], s[i+3:]
+	}
+	req.URL.Host = s
+}
+
+// EnvironmentConfig sets the scheme and host of requests, e.g. of a gateway that terminates TLS,
+// regardless of the Environment.  Empty fields keep the value of the Environment.  See
+// WithEnvironmentConfig.
+type EnvironmentConfig struct {
+	Scheme string
+	Host   string
+}
+
+func (ec EnvironmentConfig) validate() error {
+	switch ec.Scheme {
+	case "", "http", "https":
+		return nil
+	}
+	return fmt.Errorf("unsupported scheme %q", ec.Scheme)
+}
+
+func (ec EnvironmentConfig) modify(req *http.Request) {
+	if ec.Scheme != "" {
+		req.URL.Scheme = ec.Scheme
+	}
+	if ec.Host != "" {
+		req.URL.Host = ec.Host
+	}
+}
+
+// DateFormat is the format of the timestamps in responses.  See WithDateFormat.
 type DateFormat string
 
+// The date formats of the Oanda servers.
+const (
+	DateFormatRFC3339 DateFormat = "RFC3339"
+	DateFormatUNIX    DateFormat = "UNIX"
+)
+
 func (d DateFormat) modify(req *http.Request) {
 	req.Header.Set("X-Accept-Datetime-Format", string(d))
 }
 
+// modifierFunc is a request modifier of Client method Use.
+type modifierFunc func(*http.Request)
+
+func (f modifierFunc) modify(req *http.Request) {
+	f(req)
+}
+
 type ContentType string
 
 func (c ContentType) modify(req *http.Request) {
@@ -101,74 +181,866 @@ func (c ContentType) modify(req *http.Request) {
 // Client
 
 type Client struct {
-	reqMods   []requestModifier
-	accountId int
+	// mtx guards reqMods, accountId and sandbox.
+	mtx            sync.RWMutex
+	reqMods        []RequestModifier
+	accountId      int
+	methodOverride bool
+	monotonicTicks bool
+	streamDedup    bool
+	responseHook   ResponseHookFunc
+	requestHook    RequestHookFunc
+	timeout        time.Duration
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMethods   map[string]bool
+	limiter        *limiter
+	compression    bool
+	maxRspBytes    int64
+	instrumentTTL  time.Duration
+	idemOrders     bool
+	clientTrace    ClientTraceFunc
+	logger         Logger
+	rates          *rateCache
+	sandbox        *sandboxAccount
+
+	streamReconnects       int
+	streamBackoff          time.Duration
+	streamHeartbeatTimeout time.Duration
+	streamBuffer           int
+	streamDrop             DropPolicy
+	streamDropHook         StreamDropHookFunc
+
+	cancelMtx sync.Mutex
+	cancels   map[*http.Request]*requestCanceler
+
+	// workers tracks the streams and poll loops that are stopped by Shutdown.
+	workers *workerGroup
+
+	// transport is the transport that the package created for the Client, whose idle
+	// connections are closed by Close.
+	transport *http.Transport
+
 	*http.Client
 }
 
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// ClientOptions
+
+// A ClientOption configures optional behaviour of a Client. ClientOptions are passed to the
+// functions that create a new Client.
+type ClientOption func(*Client) error
+
+// WithMethodOverride returns a ClientOption that sends PATCH requests as POST requests with an
+// X-HTTP-Method-Override header for the benefit of proxies that do not handle PATCH requests.
+//
+// The override applies to every PATCH request, i.e. to Client methods ModifyOrder(),
+// ModifyTrade() and SetAccountMarginRate().
+func WithMethodOverride() ClientOption {
+	return func(c *Client) error {
+		c.methodOverride = true
+		return nil
+	}
+}
+
+// WithMonotonicTimestamps returns a ClientOption that causes PriceServers to drop ticks with a
+// timestamp that is not strictly newer than that of the last tick delivered for the same
+// instrument, e.g. ticks that are delivered out of order after a reconnect.  Dropped ticks are
+// passed to PriceServer.OutOfOrderFunc.
+func WithMonotonicTimestamps() ClientOption {
+	return func(c *Client) error {
+		c.monotonicTicks = true
+		return nil
+	}
+}
+
+// WithStreamDedup returns a ClientOption that causes PriceServers and PollPricesStream to drop a
+// tick with the same instrument and timestamp as the previous tick for that instrument, e.g. a
+// tick that is replayed after a reconnect.
+func WithStreamDedup() ClientOption {
+	return func(c *Client) error {
+		c.streamDedup = true
+		return nil
+	}
+}
+
+// A ClientTraceFunc returns the trace of request req, or nil to not trace the request.  See
+// WithClientTrace.
+type ClientTraceFunc func(req *http.Request) *httptrace.ClientTrace
+
+// WithClientTrace returns a ClientOption that attaches the httptrace.ClientTrace that fn returns
+// to every request, including those of streams and retries, e.g. to measure the time spent on
+// DNS lookups, connecting, TLS handshakes and waiting for the first byte of the response.
+func WithClientTrace(fn ClientTraceFunc) ClientOption {
+	return func(c *Client) error {
+		c.clientTrace = fn
+		return nil
+	}
+}
+
+// WithIdempotentOrders returns a ClientOption that allows requests that create orders or trades
+// to be retried if POST requests are retried, see WithRetryMethods.  A retried request that did
+// reach the server creates the order twice: the v1 API does not deduplicate orders, also not by
+// Tag, so the option acknowledges that risk.
+func WithIdempotentOrders() ClientOption {
+	return func(c *Client) error {
+		c.idemOrders = true
+		return nil
+	}
+}
+
+// WithTimeout returns a ClientOption that limits the time that a REST request, such as a request
+// for accounts, orders or prices, may take including reading the response.  The default is 30
+// seconds and a timeout of zero disables the limit.  A request that exceeds the timeout fails
+// with a *NetworkError of which Timeout() returns true.
+//
+// The timeout is applied with a context deadline per request rather than with the Timeout of the
+// embedded http.Client, which would also terminate streams.  Streaming requests, i.e. those of
+// PriceServer and EventServer, are never subject to the timeout.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout < 0 {
+			return errors.New("timeout must not be negative")
+		}
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// WithRetry returns a ClientOption that retries failed REST requests up to maxRetries times.
+// Requests are retried after connection errors and responses with status 429 (Too Many
+// Requests) or 5xx.  The delay before a retry is taken from the Retry-After header of the
+// response if present, and otherwise doubles with every attempt starting at baseDelay, up to
+// five minutes, with random jitter.  When all retries fail the error of the last attempt is returned.
+//
+// Only GET requests are retried unless other methods are enabled with WithRetryMethods.
+func WithRetry(maxRetries int, baseDelay time.Duration) ClientOption {
+	return func(c *Client) error {
+		if maxRetries < 0 || baseDelay < 0 {
+			return errors.New("maxRetries and baseDelay must not be negative")
+		}
+		c.maxRetries, c.retryBaseDelay = maxRetries, baseDelay
+		return nil
+	}
+}
+
+// WithRetryMethods returns a ClientOption that sets the HTTP methods of the requests that are
+// retried with WithRetry.  Requests that create orders or trades are never retried unless
+// WithIdempotentOrders is also given.
+func WithRetryMethods(methods ...string) ClientOption {
+	return func(c *Client) error {
+		c.retryMethods = make(map[string]bool)
+		for _, method := range methods {
+			c.retryMethods[strings.ToUpper(method)] = true
+		}
+		return nil
+	}
+}
+
+// WithRateLimit returns a ClientOption that limits the REST requests of the Client, including
+// those of PricePollers, to rps requests per second with bursts of up to burst requests.  The
+// limit is shared by all goroutines that use the Client.
+func WithRateLimit(rps int, burst int) ClientOption {
+	return func(c *Client) error {
+		if rps <= 0 || burst <= 0 {
+			return errors.New("rps and burst must be positive")
+		}
+		c.limiter = newLimiter(rps, burst)
+		return nil
+	}
+}
+
+// WithStreamReconnect returns a ClientOption that reopens the streams of PollPricesStream and
+// EventsStream after the connection ends or fails.  The delay before a reconnect doubles with
+// every consecutive attempt starting at backoff, with random jitter.  Every attempt is reported
+// with a ReconnectError on the error channel of the stream; after maxAttempts consecutive
+// attempts fail the stream ends with a terminal error.
+func WithStreamReconnect(maxAttempts int, backoff time.Duration) ClientOption {
+	return func(c *Client) error {
+		if maxAttempts < 0 || backoff < 0 {
+			return errors.New("maxAttempts and backoff must not be negative")
+		}
+		c.streamReconnects, c.streamBackoff = maxAttempts, backoff
+		return nil
+	}
+}
+
+// WithStreamHeartbeatTimeout returns a ClientOption that considers a stream dead if it receives no
+// data, including heartbeats, within timeout.  A dead stream is reopened as configured with
+// WithStreamReconnect; otherwise it ends with a *HeartbeatTimeoutError.  The default timeout is
+// 10 seconds.
+func WithStreamHeartbeatTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.New("timeout must be positive")
+		}
+		c.streamHeartbeatTimeout = timeout
+		return nil
+	}
+}
+
+// WithStreamBuffer returns a ClientOption that sets the number of ticks or events that the
+// channels of PollPricesStream and EventsStream buffer for a slow receiver.  The default is 5.
+func WithStreamBuffer(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("n must not be negative")
+		}
+		c.streamBuffer = n
+		return nil
+	}
+}
+
+// WithStreamDropPolicy returns a ClientOption that determines what the streams of
+// PollPricesStream and EventsStream do when the buffer of their channel is full, see DropPolicy.
+// Dropped messages are reported to the hook of WithStreamDropHook.
+func WithStreamDropPolicy(policy DropPolicy) ClientOption {
+	return func(c *Client) error {
+		switch policy {
+		case Block, DropOldest, DropNewest:
+		default:
+			return fmt.Errorf("unknown drop policy %d", policy)
+		}
+		c.streamDrop = policy
+		return nil
+	}
+}
+
+// A StreamDropHookFunc is invoked with the path of a stream, e.g. "/v1/prices", and the number
+// of messages that the stream has dropped so far.  See WithStreamDropHook.
+type StreamDropHookFunc func(path string, dropped int)
+
+// WithStreamDropHook returns a ClientOption that invokes hook every time that a stream drops a
+// message because of its DropPolicy, e.g. to detect a receiver that falls behind.  The hook is
+// invoked from the goroutine that reads the stream and must not block.
+func WithStreamDropHook(hook StreamDropHookFunc) ClientOption {
+	return func(c *Client) error {
+		c.streamDropHook = hook
+		return nil
+	}
+}
+
+// WithCompression returns a ClientOption that requests gzip compressed responses for REST
+// requests, which considerably reduces the size of e.g. candles and transaction histories.
+// Streams are not compressed.
+func WithCompression() ClientOption {
+	return func(c *Client) error {
+		c.compression = true
+		return nil
+	}
+}
+
+// WithMaxResponseBytes returns a ClientOption that limits the size of the (decompressed) body of
+// REST responses to n bytes.  Larger responses fail with a *ResponseTooLargeError.  Streams are
+// not limited.  By default the size is unlimited.
+func WithMaxResponseBytes(n int64) ClientOption {
+	return func(c *Client) error {
+		if n <= 0 {
+			return errors.New("maximum response size must be positive")
+		}
+		c.maxRspBytes = n
+		return nil
+	}
+}
+
+// WithInstrumentCacheTTL returns a ClientOption that sets the duration for which the instrument
+// information of InstrumentInfo, PipValue and PipsToPrice is reused.  The default is an hour.
+func WithInstrumentCacheTTL(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return errors.New("ttl must be positive")
+		}
+		c.instrumentTTL = ttl
+		return nil
+	}
+}
+
+// WithLogger returns a ClientOption that logs the method, URL, status code and duration of every
+// REST request to logger.  Nothing is logged by default.
+func WithLogger(logger Logger) ClientOption {
+	return func(c *Client) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithUserAgent returns a ClientOption that appends app, e.g. the name and version of an
+// application, to the default User-Agent header of requests.
+func WithUserAgent(app string) ClientOption {
+	return func(c *Client) error {
+		c.reqMods = append(c.reqMods, UserAgent(string(defaultUserAgent)+" "+app))
+		return nil
+	}
+}
+
+// WithTransportTuning returns a ClientOption that replaces the default transport of the Client
+// with a copy that keeps up to maxIdleConnsPerHost idle connections per host and opens at most
+// maxConnsPerHost connections per host, or any number if maxConnsPerHost is 0.  The default
+// transport keeps no idle connections because the number of connections to the stream servers
+// is restricted, so a Client that mostly sends REST requests benefits from reusing connections,
+// whereas streams are best served by a separate Client with the default transport.
+func WithTransportTuning(maxIdleConnsPerHost, maxConnsPerHost int) ClientOption {
+	return func(c *Client) error {
+		if maxConnsPerHost < 0 {
+			return fmt.Errorf("invalid maximum number of connections %d", maxConnsPerHost)
+		}
+		t := c.clonedTransport()
+		t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		t.MaxConnsPerHost = maxConnsPerHost
+		return nil
+	}
+}
+
+// WithTLSConfig returns a ClientOption that configures TLS connections with cfg, e.g. to trust
+// the certificate authority of a corporate proxy.
+func WithTLSConfig(cfg *tls.Config) ClientOption {
+	return func(c *Client) error {
+		c.clonedTransport().TLSClientConfig = cfg
+		return nil
+	}
+}
+
+// WithProxy returns a ClientOption that sends all requests through proxy rather than the proxy
+// of the environment variables HTTP_PROXY and HTTPS_PROXY.
+func WithProxy(proxy *url.URL) ClientOption {
+	return func(c *Client) error {
+		if proxy == nil {
+			return errors.New("No proxy")
+		}
+		c.clonedTransport().Proxy = http.ProxyURL(proxy)
+		return nil
+	}
+}
+
+// WithoutProxy returns a ClientOption that connects to the Oanda servers directly, regardless of
+// the environment variables HTTP_PROXY and HTTPS_PROXY.
+func WithoutProxy() ClientOption {
+	return func(c *Client) error {
+		c.clonedTransport().Proxy = nil
+		return nil
+	}
+}
+
+// clonedTransport returns the transport of c, which is first replaced by a copy if it is the
+// default transport that is shared by all Clients.
+func (c *Client) clonedTransport() *http.Transport {
+	if c.transport == defaultTransport {
+		c.transport = defaultTransport.Clone()
+		if c.Transport == http.RoundTripper(defaultTransport) {
+			c.Transport = c.transport
+		}
+	}
+	return c.transport
+}
+
+// RoundTripFunc is an http.RoundTripper that is implemented by a function, e.g. to stub the
+// responses of the Oanda servers in tests.  See WithRoundTripper.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip implements the http.RoundTripper interface.
+func (fn RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// WithRoundTripper returns a ClientOption that executes requests with rt rather than with the
+// default transport.  ClientOptions that configure the transport, such as WithProxy, do not apply
+// to rt.
+func WithRoundTripper(rt http.RoundTripper) ClientOption {
+	return func(c *Client) error {
+		if rt == nil {
+			return errors.New("No RoundTripper")
+		}
+		c.Transport = rt
+		return nil
+	}
+}
+
+// WithDateFormat returns a ClientOption that requests timestamps in format f, either
+// DateFormatRFC3339 (the default) or DateFormatUNIX; other formats are rejected.  Fields of type
+// Time decode both formats, which cannot be confused, and the expiry of orders is sent in
+// format f.
+func WithDateFormat(f DateFormat) ClientOption {
+	return func(c *Client) error {
+		f, err := parseDateFormat(f)
+		if err != nil {
+			return err
+		}
+		c.reqMods = append(c.reqMods, f)
+		return nil
+	}
+}
+
+// parseDateFormat returns the DateFormat constant that matches f regardless of case.
+func parseDateFormat(f DateFormat) (DateFormat, error) {
+	switch {
+	case strings.EqualFold(string(f), string(DateFormatRFC3339)):
+		return DateFormatRFC3339, nil
+	case strings.EqualFold(string(f), string(DateFormatUNIX)):
+		return DateFormatUNIX, nil
+	}
+	return "", fmt.Errorf("unknown date format %q", f)
+}
+
+// WithEnvironmentConfig sets the scheme and host of all requests of the Client, including those
+// of streams, to those of cfg.  Unlike the host argument of NewClient it also overrides the scheme
+// of the sandbox environment.  Authentication and the other headers are unaffected.
+func WithEnvironmentConfig(cfg EnvironmentConfig) ClientOption {
+	return func(c *Client) error {
+		if err := cfg.validate(); err != nil {
+			return err
+		}
+		c.reqMods = append(c.reqMods, cfg)
+		return nil
+	}
+}
+
+// A ResponseHookFunc is invoked with the path and status code of a response and the value into
+// which the response was decoded.  See WithResponseHook.
+type ResponseHookFunc func(path string, status int, decoded interface{})
+
+// WithResponseHook returns a ClientOption that invokes hook after every response that was
+// successfully decoded, e.g. to record the responses of the Oanda servers. The decoded value
+// that is passed to hook is a separate copy of the value that is returned to the caller.
+func WithResponseHook(hook ResponseHookFunc) ClientOption {
+	return func(c *Client) error {
+		c.responseHook = hook
+		return nil
+	}
+}
+
+// RequestInfo describes a completed request.  See WithRequestHook.
+type RequestInfo struct {
+	Method string
+	Path   string
+
+	// StatusCode is 0 if no response was received.
+	StatusCode int
+
+	// Duration includes the time that is spent on retries.
+	Duration time.Duration
+	Err      error
+}
+
+// A RequestHookFunc is invoked once for every request.  See WithRequestHook.
+type RequestHookFunc func(info RequestInfo)
+
+// WithRequestHook returns a ClientOption that invokes hook once after every request, including
+// requests that failed and polls that returned status 304 (Not Modified), e.g. to collect
+// metrics.  Retries are reported as a single request.
+func WithRequestHook(hook RequestHookFunc) ClientOption {
+	return func(c *Client) error {
+		c.requestHook = hook
+		return nil
+	}
+}
+
+// callRequestHook invokes the request hook, if any, for a request that started at start.
+func (c *Client) callRequestHook(method, path string, start time.Time, rsp *http.Response,
+	err error) {
+
+	if c.requestHook == nil {
+		return
+	}
+	info := RequestInfo{
+		Method:   method,
+		Path:     path,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if rsp != nil {
+		info.StatusCode = rsp.StatusCode
+	}
+	c.requestHook(info)
+}
+
+// NewClient returns a client instance that connects to environment env at host, e.g. a proxy or
+// a server with recorded responses.  If host is empty the host of env is used, as by the other
+// constructors.  String token is the personal access token, if env requires one.  Streams are
+// served by the same host unless host is empty.
+func NewClient(env Environment, host string, token string, opts ...ClientOption) (*Client, error) {
+	if env == "" {
+		return nil, errors.New("No environment")
+	}
+	reqMods := []RequestModifier{env}
+	if host != "" {
+		reqMods = append(reqMods, Host(host))
+	}
+	if token != "" {
+		reqMods = append(reqMods, TokenAuthenticator(token))
+	}
+	return newClient(opts, reqMods...)
+}
+
+// testHost is the host of the Clients that are returned by NewTestClient.
+const testHost = "http://api-test.oanda.invalid"
+
+// NewTestClient returns a client instance for tests that executes all requests with rt, e.g. a
+// RoundTripFunc that returns recorded responses.  The Client sends requests, including those of
+// streams, to a fixed host with a dummy access token.  NewTestClient panics if an option fails.
+func NewTestClient(rt http.RoundTripper, opts ...ClientOption) *Client {
+	opts = append([]ClientOption{WithRoundTripper(rt)}, opts...)
+	c, err := NewClient(Environment("fxpractice"), testHost, "test-token", opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
 // NewFxPracticeClient returns a client instance that connects to Oanda's fxpractice environment. String
 // token should be set to the generated personal access token.
 //
 // See http://developer.oanda.com/docs/v1/auth/ for further information.
-func NewFxPracticeClient(token string) (*Client, error) {
+func NewFxPracticeClient(token string, opts ...ClientOption) (*Client, error) {
 	if token == "" {
 		return nil, errors.New("No FxPractice access token")
 	}
-	return newClient(Environment("fxpractice"), TokenAuthenticator(token)), nil
+	return newClient(opts, Environment("fxpractice"), TokenAuthenticator(token))
 }
 
 // NewFxTradeClient returns a client instance that connects to Oanda's fxtrade environment. String token
 // should be set to the generated personal access token.
 //
 // See http://developer.oanda.com/docs/v1/auth/ for further information.
-func NewFxTradeClient(token string) (*Client, error) {
+func NewFxTradeClient(token string, opts ...ClientOption) (*Client, error) {
 	if token == "" {
 		return nil, errors.New("No FxTrade access token")
 	}
-	return newClient(Environment("fxtrade"), TokenAuthenticator(token)), nil
+	return newClient(opts, Environment("fxtrade"), TokenAuthenticator(token))
 }
 
 // NewSandboxClient returns a client instance that connects to Oanda's fxsandbox environment. Creating a
 // client will create a user in the sandbox environment with wich all further calls with be authenticated.
 //
 // See http://developer.oanda.com/docs/v1/auth/ for further information.
-func NewSandboxClient() (*Client, error) {
-	c := newClient(Environment("sandbox"))
-	if userName, err := initSandboxAccount(c); err != nil {
+func NewSandboxClient(opts ...ClientOption) (*Client, error) {
+	return NewSandboxClientContext(context.Background(), opts...)
+}
+
+// NewSandboxClientContext is like NewSandboxClient but stops creating the sandbox user when ctx is
+// done, including while it waits to retry, in which case the error of ctx is returned.
+func NewSandboxClientContext(ctx context.Context, opts ...ClientOption) (*Client, error) {
+	c, err := newClient(opts, Environment("sandbox"))
+	if err != nil {
+		return nil, err
+	}
+	if err = initSandboxAccount(ctx, c); err != nil {
 		return nil, err
-	} else {
-		c.reqMods = append(c.reqMods, UsernameAuthenticator(userName))
 	}
 	return c, nil
 }
 
+// SandboxCredentials returns the credentials and the account id of the user that was created for
+// a client that was returned by NewSandboxClient.  ok is false for other clients.
+func (c *Client) SandboxCredentials() (username, password string, accountId int, ok bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if c.sandbox == nil {
+		return "", "", 0, false
+	}
+	return c.sandbox.Username, c.sandbox.Password, c.sandbox.AccountId, true
+}
+
 // SelectAccount configures the account for which subsequent trades and orders are.  Use AccountId 0 to
-// disable account selection.
-func (c *Client) SelectAccount(accountId int) {
+// disable account selection.  An error is returned for negative account ids.
+func (c *Client) SelectAccount(accountId int) error {
+	if accountId < 0 {
+		return fmt.Errorf("invalid account id %d", accountId)
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	c.accountId = accountId
+	return nil
+}
+
+// WithAccount returns a copy of c for which account accountId is selected, e.g. to trade several
+// accounts with one Client from different goroutines.  The copy shares the http.Client, and with
+// that its connections, as well as the options, rate limit and caches of c.  Requests of the copy
+// are cancelled with CancelRequest of the copy.  No account is selected for negative account ids.
+func (c *Client) WithAccount(accountId int) *Client {
+	if accountId < 0 {
+		accountId = 0
+	}
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return &Client{
+		reqMods:                append([]RequestModifier(nil), c.reqMods...),
+		accountId:              accountId,
+		methodOverride:         c.methodOverride,
+		monotonicTicks:         c.monotonicTicks,
+		streamDedup:            c.streamDedup,
+		responseHook:           c.responseHook,
+		requestHook:            c.requestHook,
+		timeout:                c.timeout,
+		maxRetries:             c.maxRetries,
+		retryBaseDelay:         c.retryBaseDelay,
+		retryMethods:           c.retryMethods,
+		limiter:                c.limiter,
+		compression:            c.compression,
+		maxRspBytes:            c.maxRspBytes,
+		instrumentTTL:          c.instrumentTTL,
+		idemOrders:             c.idemOrders,
+		clientTrace:            c.clientTrace,
+		logger:                 c.logger,
+		rates:                  c.rates,
+		sandbox:                c.sandbox,
+		streamReconnects:       c.streamReconnects,
+		streamBackoff:          c.streamBackoff,
+		streamHeartbeatTimeout: c.streamHeartbeatTimeout,
+		streamBuffer:           c.streamBuffer,
+		streamDrop:             c.streamDrop,
+		streamDropHook:         c.streamDropHook,
+		cancels:                make(map[*http.Request]*requestCanceler),
+		workers:                c.workers,
+		transport:              c.transport,
+		Client:                 c.Client,
+	}
+}
+
+// With returns a copy of c, like WithAccount, that applies mods to its requests after the
+// modifiers of c, e.g. c.With(DateFormatUNIX) to request timestamps in UNIX format from a single
+// endpoint.  c itself is unaffected.  As with the ClientOptions, nil modifiers, unknown date
+// formats and unsupported schemes are rejected with an error.
+func (c *Client) With(mods ...RequestModifier) (*Client, error) {
+	valid := make([]RequestModifier, len(mods))
+	for i, rm := range mods {
+		switch m := rm.(type) {
+		case nil:
+			return nil, errors.New("nil RequestModifier")
+		case DateFormat:
+			f, err := parseDateFormat(m)
+			if err != nil {
+				return nil, err
+			}
+			rm = f
+		case EnvironmentConfig:
+			if err := m.validate(); err != nil {
+				return nil, err
+			}
+		}
+		valid[i] = rm
+	}
+	wc := c.WithAccount(c.SelectedAccount())
+	wc.reqMods = append(wc.reqMods, valid...)
+	return wc, nil
+}
+
+// dateFormat returns the format of the timestamps that c requests.
+func (c *Client) dateFormat() DateFormat {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	df := defaultDateFormat
+	for _, rm := range c.reqMods {
+		if f, ok := rm.(DateFormat); ok {
+			df = f
+		}
+	}
+	return df
+}
+
+// SelectedAccount returns the account that was selected with SelectAccount, or 0 if no account
+// is selected.
+func (c *Client) SelectedAccount() int {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.accountId
 }
 
 // NewRequest creates a new http request.
 func (c *Client) NewRequest(method, urlStr string, body io.Reader) (*http.Request, error) {
-	req, err := http.NewRequest(method, urlStr, body)
+	return c.NewRequestWithContext(context.Background(), method, urlStr, body)
+}
+
+// NewRequestWithContext creates a new http request that is aborted when ctx is done.
+func (c *Client) NewRequestWithContext(ctx context.Context, method, urlStr string,
+	body io.Reader) (*http.Request, error) {
+
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, body)
 	if err != nil {
 		return nil, err
 	}
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
 	for _, reqMod := range c.reqMods {
 		reqMod.modify(req)
 	}
 	return req, nil
 }
 
-// CancelRequest aborts an in-progress http request.
-func (c *Client) CancelRequest(req *http.Request) {
-	type canceler interface {
-		CancelRequest(*http.Request)
+// Use adds fn to the modifiers that update each request of c, e.g. to set a tracing header.  The
+// modifiers of Use run in the order in which they were added, after the built-in modifiers that
+// set the host, authentication, date format and content type.  fn is also used by the Clients
+// that WithAccount returns after the call to Use, but not by those that it returned before.
+func (c *Client) Use(fn func(*http.Request)) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.reqMods = append(c.reqMods, modifierFunc(fn))
+}
+
+// EndpointURL returns the absolute URL of the endpoint at path, e.g. "/v1/accounts", with query
+// parameters query.  The scheme and host are those to which the Client sends requests, i.e. of
+// its Environment or of the host that was passed to NewClient.  Credentials are not included.
+func (c *Client) EndpointURL(path string, query url.Values) string {
+	u, err := url.Parse(path)
+	if err != nil {
+		u = &url.URL{Path: path}
+	}
+	req := http.Request{URL: u, Header: make(http.Header)}
+	c.mtx.RLock()
+	for _, reqMod := range c.reqMods {
+		switch reqMod.(type) {
+		case Environment, Host, EnvironmentConfig:
+			reqMod.modify(&req)
+		}
 	}
-	tr, ok := c.Transport.(canceler)
+	c.mtx.RUnlock()
+	if len(query) > 0 {
+		q := req.URL.Query()
+		for k, vs := range query {
+			q[k] = append(q[k], vs...)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+	return req.URL.String()
+}
+
+// Do sends an http request and returns the response.  The request can be aborted with
+// CancelRequest until the body of the response is closed.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	if c.clientTrace != nil {
+		if trace := c.clientTrace(req); trace != nil {
+			ctx = httptrace.WithClientTrace(ctx, trace)
+		}
+	}
+	rc := &requestCanceler{cancel}
+	c.cancelMtx.Lock()
+	c.cancels[req] = rc
+	c.cancelMtx.Unlock()
+
+	release := func() {
+		c.cancelMtx.Lock()
+		if c.cancels[req] == rc {
+			delete(c.cancels, req)
+		}
+		c.cancelMtx.Unlock()
+		cancel()
+	}
+	rsp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	rsp.Body = &cancelReadCloser{rsp.Body, release}
+	return rsp, nil
+}
+
+// CancelRequest aborts an in-progress http request that was sent with Do.  CancelRequest
+// cancels the context of the request and works with any http.RoundTripper.
+func (c *Client) CancelRequest(req *http.Request) {
+	c.cancelMtx.Lock()
+	rc, ok := c.cancels[req]
+	c.cancelMtx.Unlock()
 	if ok {
-		tr.CancelRequest(req)
+		rc.cancel()
+	}
+}
+
+// Close cancels the requests of c that are in progress, including streams.  If c uses a
+// transport of the package, such as the default transport or the one of WithTransportTuning, its
+// idle connections are closed as well; the connections of a Transport or http.Client that was
+// assigned by the caller remain the responsibility of the caller.
+func (c *Client) Close() {
+	c.cancelMtx.Lock()
+	for _, rc := range c.cancels {
+		rc.cancel()
+	}
+	c.cancelMtx.Unlock()
+	if c.Client != nil && c.transport != nil && c.Transport == http.RoundTripper(c.transport) {
+		c.transport.CloseIdleConnections()
+	}
+}
+
+// Shutdown stops the streams, stream servers and poll loops that were started through c or a
+// Client of WithAccount and waits until their goroutines have ended and closed their channels, or
+// until ctx is done, in which case the error of ctx is returned.  Streams and poll loops that are
+// started after Shutdown end immediately; other requests are not affected.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.workers.shutdown(ctx)
+}
+
+// requestCanceler holds the function that cancels the context of an in-progress request.
+type requestCanceler struct {
+	cancel context.CancelFunc
+}
+
+// requestContext returns the context for a REST request, which is derived from ctx and expires
+// after the timeout of the client.
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// NetworkError is returned if a REST request fails before a response is received from the Oanda
+// servers, e.g. because of a DNS failure, a refused connection or a timeout.  Errors that are
+// reported by the Oanda servers are returned as *ApiError instead.
+type NetworkError struct {
+	Err *url.Error
+}
+
+func (ne *NetworkError) Error() string {
+	return ne.Err.Error()
+}
+
+// Unwrap returns the underlying *url.Error.
+func (ne *NetworkError) Unwrap() error {
+	return ne.Err
+}
+
+// Timeout returns true if the request timed out.
+func (ne *NetworkError) Timeout() bool {
+	return ne.Err.Timeout()
+}
+
+// Temporary returns true if the error is temporary.
+func (ne *NetworkError) Temporary() bool {
+	return ne.Err.Temporary()
+}
+
+// requestError returns the *NetworkError for error err of a REST request.  If the context of req
+// is done the error wraps the error of the context, so that Timeout() returns true if the
+// deadline of the context passed.
+func requestError(req *http.Request, err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return networkError(req.Method, req.URL.String(), err)
 	}
+	return &NetworkError{urlErr}
+}
+
+// networkError returns a *NetworkError for error err of a method request of urlStr.
+func networkError(method, urlStr string, err error) *NetworkError {
+	return &NetworkError{&url.Error{
+		Op:  method[:1] + strings.ToLower(method[1:]),
+		URL: urlStr,
+		Err: err,
+	}}
+}
+
+// cancelReadCloser cancels the context of a request when the response body is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (rc *cancelReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.cancel()
+	return err
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -178,54 +1050,482 @@ func (c *Client) CancelRequest(req *http.Request) {
 type PollRequest struct {
 	c   *Client
 	req *http.Request
+
+	mtx          sync.RWMutex
+	etag         string
+	lastModified string
+	backoff      *pollBackoff
+
+	// cache holds the body of the last successful response to the request with URL cacheURL.
+	cache    []byte
+	cacheURL string
+}
+
+// CachedResponseHeader is set to "true" in responses of PollRequest.Poll() with status 304 (Not
+// Modified) of which the body is the cached body of the last successful response.
+const CachedResponseHeader = "X-Oanda-Cached-Response"
+
+// backoffThreshold is the number of consecutive 304 (Not Modified) responses after which the
+// interval of PollLoop is increased.
+const backoffThreshold = 3
+
+// pollBackoff holds the adaptive interval strategy of PollLoop.
+type pollBackoff struct {
+	min, max time.Duration
+	factor   float64
 }
 
-// Poll repeats the http request with which PollRequest was created.
+// WithBackoff makes PollLoop adapt its interval to the rate at which data changes.  After every
+// three consecutive 304 (Not Modified) responses the interval is multiplied by factor, up to max,
+// and it is reset to the interval of PollLoop as soon as data changed.  The interval is never
+// shorter than min.  WithBackoff does not affect Poll and returns pr.
+func (pr *PollRequest) WithBackoff(min, max time.Duration, factor float64) *PollRequest {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+	pr.backoff = &pollBackoff{min: min, max: max, factor: factor}
+	return pr
+}
+
+// next returns the interval after a poll with n consecutive 304 responses.
+func (b *pollBackoff) next(interval, base time.Duration, n int) time.Duration {
+	if n == 0 {
+		interval = base
+	} else if n%backoffThreshold == 0 {
+		interval = time.Duration(float64(interval) * b.factor)
+	}
+	if interval > b.max {
+		interval = b.max
+	}
+	if interval < b.min {
+		interval = b.min
+	}
+	return interval
+}
+
+// Poll repeats the http request with which PollRequest was created.  If the server responds
+// with status 304 (Not Modified) the body of the response is that of the last successful
+// response and header CachedResponseHeader is set.  The poll is cancelled when the context of the
+// request is done.
 func (pr *PollRequest) Poll() (*http.Response, error) {
-	rsp, err := pr.c.Do(pr.req)
+	return pr.PollContext(pr.req.Context())
+}
+
+// PollContext is like Poll but the request is cancelled when ctx is done, in which case the
+// error of ctx is returned.
+func (pr *PollRequest) PollContext(ctx context.Context) (*http.Response, error) {
+	rsp, err := pr.poll(ctx)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return rsp, err
+}
+
+// PollResult is the result of PollRequest method PollResult().
+type PollResult struct {
+	// Response is the response of the poll.  If NotModified is true its body is the cached body
+	// of the last successful response, see Poll().
+	Response *http.Response
+
+	// NotModified is true if the data did not change since the last poll, i.e. the server
+	// responded with status 304 (Not Modified).
+	NotModified bool
+
+	// ETag is the entity tag of the data, or "" if the server sent none.
+	ETag string
+}
+
+// PollResult is like Poll but also reports whether the poll returned fresh data.
+func (pr *PollRequest) PollResult() (*PollResult, error) {
+	rsp, err := pr.Poll()
 	if err != nil {
 		return nil, err
 	}
-	etag := rsp.Header.Get("ETag")
-	if etag != "" {
+	pr.mtx.RLock()
+	defer pr.mtx.RUnlock()
+	return &PollResult{
+		Response:    rsp,
+		NotModified: rsp.StatusCode == http.StatusNotModified,
+		ETag:        pr.etag,
+	}, nil
+}
+
+func (pr *PollRequest) poll(ctx context.Context) (rsp *http.Response, err error) {
+	start := time.Now()
+	defer func() {
+		pr.c.callRequestHook(pr.req.Method, pr.req.URL.Path, start, rsp, err)
+	}()
+
+	if pr.c.limiter != nil {
+		if err := pr.c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := pr.c.requestContext(ctx)
+	defer cancel()
+
+	pr.mtx.Lock()
+	if u := pr.req.URL.String(); u != pr.cacheURL {
+		// The cached body and the validators belong to a different request.
+		pr.cacheURL, pr.cache = u, nil
+		pr.etag, pr.lastModified = "", ""
+		pr.req.Header.Del("If-None-Match")
+		pr.req.Header.Del("If-Modified-Since")
+	}
+	// Concurrent polls update the validators of pr.req, so each poll sends its own copy.
+	req := pr.req.Clone(ctx)
+	pr.mtx.Unlock()
+	if pr.c.compression {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	rsp, err = pr.c.Do(req)
+	if err != nil {
+		return nil, requestError(req, err)
+	}
+	defer rsp.Body.Close()
+	if err = decompressBody(rsp); err != nil {
+		return nil, err
+	}
+	body, err := pr.c.readBody(rsp)
+	if err != nil {
+		if _, ok := err.(*ResponseTooLargeError); ok {
+			return nil, err
+		}
+		return nil, requestError(req, err)
+	}
+
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+	switch {
+	case rsp.StatusCode == http.StatusNotModified && pr.cache != nil:
+		body = pr.cache
+		rsp.Header.Set(CachedResponseHeader, "true")
+	case rsp.StatusCode == http.StatusOK:
+		pr.cache = body
+	}
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	rsp.ContentLength = int64(len(body))
+
+	if etag := rsp.Header.Get("ETag"); etag != "" {
+		pr.etag = etag
 		pr.req.Header.Set("If-None-Match", etag)
 	}
+	if lastModified := rsp.Header.Get("Last-Modified"); lastModified != "" {
+		pr.lastModified = lastModified
+	}
+	// The ETag is the stronger validator; If-Modified-Since is only sent without one.
+	if pr.etag == "" && pr.lastModified != "" {
+		pr.req.Header.Set("If-Modified-Since", pr.lastModified)
+	} else {
+		pr.req.Header.Del("If-Modified-Since")
+	}
 	return rsp, nil
 }
 
-func newClient(reqMod ...requestModifier) *Client {
+// PollLoop polls every interval, starting immediately, until ctx is done.  Responses with status
+// 304 (Not Modified) are skipped so that only responses with changed data are sent on the
+// response channel; the receiver must close the body of each response.  Errors are sent on the
+// error channel and do not end the loop.  Both channels are closed when ctx is done; the error of
+// ctx is not sent.
+func (pr *PollRequest) PollLoop(ctx context.Context, interval time.Duration) (<-chan *http.Response,
+	<-chan error) {
+
+	rspC := make(chan *http.Response)
+	errC := make(chan error)
+	ctx, done := pr.c.workers.track(ctx)
+	go func() {
+		defer done()
+		defer close(rspC)
+		defer close(errC)
+		t := time.NewTimer(0)
+		defer t.Stop()
+		d, notModified := interval, 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			rsp, err := pr.poll(ctx)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				// The poll was cancelled, which is not an error of the loop.
+				return
+			case err != nil:
+				select {
+				case errC <- err:
+				case <-ctx.Done():
+					return
+				}
+			case rsp.StatusCode == http.StatusNotModified:
+				rsp.Body.Close()
+				notModified++
+			default:
+				notModified = 0
+				select {
+				case rspC <- rsp:
+				case <-ctx.Done():
+					rsp.Body.Close()
+					return
+				}
+			}
+			pr.mtx.RLock()
+			if pr.backoff != nil {
+				d = pr.backoff.next(d, interval, notModified)
+			}
+			pr.mtx.RUnlock()
+			t.Reset(d)
+		}
+	}()
+	return rspC, errC
+}
+
+// LastETag returns the most recent ETag that was received from the server.  The ETag is sent
+// in the If-None-Match header of subsequent polls.
+func (pr *PollRequest) LastETag() string {
+	pr.mtx.RLock()
+	defer pr.mtx.RUnlock()
+	return pr.etag
+}
+
+// LastModified returns the most recent Last-Modified header that was received from the server.
+// The value is sent in the If-Modified-Since header of subsequent polls unless the server also
+// sent an ETag.
+func (pr *PollRequest) LastModified() string {
+	pr.mtx.RLock()
+	defer pr.mtx.RUnlock()
+	return pr.lastModified
+}
+
+func newClient(opts []ClientOption, reqMod ...RequestModifier) (*Client, error) {
 	c := Client{
-		reqMods: []requestModifier{
+		reqMods: []RequestModifier{
 			defaultDateFormat,
 			defaultContentType,
+			defaultUserAgent,
 		},
 		Client: &http.Client{
 			Transport: defaultTransport,
 		},
+		transport:     defaultTransport,
+		timeout:       defaultTimeout,
+		streamBuffer:  defaultBufferSize,
+		instrumentTTL: defaultInstrumentCacheTTL,
+		retryMethods:  map[string]bool{"GET": true},
+		rates:         newRateCache(),
+		cancels:       make(map[*http.Request]*requestCanceler),
+		workers:       newWorkerGroup(),
 	}
 	c.reqMods = append(c.reqMods, reqMod...)
-	return &c
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
 }
 
-// initSandboxAccount creates a new test account in the sandbox environment and adds a
-// requestModifier for authentication to the client.
-func initSandboxAccount(c *Client) (string, error) {
+// sandboxAttempts is the maximum number of attempts to create a sandbox account.  The delay
+// between attempts starts at sandboxRetryDelay and doubles after every failed attempt.
+const sandboxAttempts = 5
+
+var sandboxRetryDelay = 500 * time.Millisecond
+
+// sandboxAccount holds the credentials of a user in the sandbox environment.
+type sandboxAccount struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	AccountId int    `json:"accountId"`
+}
+
+// initSandboxAccount creates a new test account in the sandbox environment, authenticates
+// subsequent requests of c as its user and selects the account.  Account creation is retried
+// because the sandbox is frequently unavailable.
+func initSandboxAccount(ctx context.Context, c *Client) error {
+	d := sandboxRetryDelay
+	for attempt := 1; ; attempt++ {
+		acc, err := createSandboxAccount(ctx, c)
+		if err == nil {
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+			c.sandbox = acc
+			c.reqMods = append(c.reqMods, UsernameAuthenticator(acc.Username))
+			c.accountId = acc.AccountId
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt == sandboxAttempts {
+			return fmt.Errorf("failed to create sandbox account after %d attempts: %s",
+				attempt, err)
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		d *= 2
+	}
+}
+
+func createSandboxAccount(ctx context.Context, c *Client) (*sandboxAccount, error) {
 	v := struct {
 		ApiError
-		Username  string `json:"username"`
-		Password  string `json:"password"`
-		AccountId int    `json:"accountId"`
+		sandboxAccount
 	}{}
-	if err := requestAndDecode(c, "POST", "/v1/accounts", nil, &v); err != nil {
-		return "", err
+	if err := requestAndDecodeContext(ctx, c, "POST", "/v1/accounts", nil, &v); err != nil {
+		return nil, err
 	}
-	return v.Username, nil
+	return &v.sandboxAccount, nil
 }
 
 type returnCodeChecker interface {
 	checkReturnCode() error
 }
 
+// Sentinel errors for ApiErrors with common error codes.  Use errors.Is to test an error
+// against them; ApiError.Code remains available for codes that are not mapped.
+//
+// See http://developer.oanda.com/docs/v1/troubleshooting/ for further information.
+var (
+	ErrInvalidArgument   = errors.New("invalid or malformed argument")
+	ErrMissingArgument   = errors.New("missing required argument")
+	ErrUnauthorized      = errors.New("insufficient authorization to perform request")
+	ErrMarketHalted      = errors.New("market halted")
+	ErrInvalidInstrument = errors.New("invalid instrument")
+	ErrRateLimited       = errors.New("rate limit exceeded")
+)
+
+// apiErrorCodes maps the error codes of the Oanda servers to sentinel errors.
+var apiErrorCodes = map[int]error{
+	1:  ErrInvalidArgument,
+	2:  ErrMissingArgument,
+	4:  ErrUnauthorized,
+	24: ErrMarketHalted,
+	46: ErrInvalidInstrument,
+	68: ErrRateLimited,
+}
+
+// maxErrorBody is the maximum number of bytes of a response body that is kept in an HTTPError.
+const maxErrorBody = 1024
+
+// HTTPError is returned for responses with a status other than 2xx that do not hold an ApiError,
+// such as the HTML error pages of proxies.
+type HTTPError struct {
+	StatusCode int
+
+	// Body holds up to the first 1024 bytes of the response body.
+	Body []byte
+}
+
+func newHTTPError(statusCode int, body []byte) *HTTPError {
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+	return &HTTPError{StatusCode: statusCode, Body: body}
+}
+
+func (he *HTTPError) Error() string {
+	return fmt.Sprintf("HTTPError{StatusCode: %d, Body: %s}", he.StatusCode, he.Body)
+}
+
+// RateLimitError is returned for responses with status 429 (Too Many Requests) that remain after
+// any retries.  errors.Is(err, ErrRateLimited) holds for a RateLimitError.
+type RateLimitError struct {
+	// RetryAfter is the delay of the Retry-After header of the response, or 0 if the response
+	// did not have one.
+	RetryAfter time.Duration
+
+	// Err is the *ApiError or *HTTPError of the response.
+	Err error
+}
+
+// rateLimitError wraps err in a *RateLimitError if rsp has status 429.
+func rateLimitError(rsp *http.Response, err error) error {
+	if rsp.StatusCode != http.StatusTooManyRequests {
+		return err
+	}
+	d, _ := retryAfter(rsp.Header)
+	return &RateLimitError{RetryAfter: d, Err: err}
+}
+
+func (re *RateLimitError) Error() string {
+	return fmt.Sprintf("RateLimitError{RetryAfter: %s, Err: %s}", re.RetryAfter, re.Err)
+}
+
+// Is reports whether target is ErrRateLimited.
+func (re *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Unwrap returns the *ApiError or *HTTPError of the response.
+func (re *RateLimitError) Unwrap() error {
+	return re.Err
+}
+
+// maxDecodeErrorBody is the maximum number of bytes of a response body that is kept in a
+// DecodeError.
+const maxDecodeErrorBody = 8 * 1024
+
+// DecodeError is returned when the body of a response cannot be decoded.  The error of the json
+// package can be retrieved with errors.As.
+type DecodeError struct {
+	Err        error
+	StatusCode int
+
+	// Body holds up to the first 8KB of the response body.
+	Body []byte
+}
+
+func newDecodeError(err error, statusCode int, body []byte) *DecodeError {
+	if len(body) > maxDecodeErrorBody {
+		body = body[:maxDecodeErrorBody]
+	}
+	return &DecodeError{Err: err, StatusCode: statusCode, Body: body}
+}
+
+func (de *DecodeError) Error() string {
+	return fmt.Sprintf("DecodeError{StatusCode: %d, Err: %s, Body: %s}", de.StatusCode, de.Err,
+		de.Body)
+}
+
+// Unwrap returns the error of the json package.
+func (de *DecodeError) Unwrap() error {
+	return de.Err
+}
+
+// ResponseMeta holds the status and headers of a response.  See Client method DoDecode().
+type ResponseMeta struct {
+	StatusCode int
+	Headers    http.Header
+
+	// RequestId identifies the request in support requests to Oanda.  It is empty if the server
+	// did not send a request id.
+	RequestId string
+}
+
+func newResponseMeta(rsp *http.Response) *ResponseMeta {
+	meta := ResponseMeta{StatusCode: rsp.StatusCode, Headers: rsp.Header}
+	for _, k := range []string{"RequestID", "X-Request-Id"} {
+		if id := rsp.Header.Get(k); id != "" {
+			meta.RequestId = id
+			break
+		}
+	}
+	return &meta
+}
+
+// RateLimitRemaining returns the value of header X-RateLimit-Remaining.  Ok is false if the
+// server did not report the remaining number of requests.
+func (rm *ResponseMeta) RateLimitRemaining() (n int, ok bool) {
+	n, err := strconv.Atoi(rm.Headers.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
 // ApiError holds error details as returned by the Oanda servers.
 type ApiError struct {
 	Code     int    `json:"code"`
@@ -238,6 +1538,13 @@ func (ae *ApiError) Error() string {
 		ae.Code, ae.Message, ae.MoreInfo)
 }
 
+// Is reports whether target is the sentinel error for the code of the ApiError, so that e.g.
+// errors.Is(err, ErrRateLimited) holds for an ApiError with the rate limit violation code.
+func (ae *ApiError) Is(target error) bool {
+	sentinel, ok := apiErrorCodes[ae.Code]
+	return ok && sentinel == target
+}
+
 func (ae *ApiError) checkReturnCode() error {
 	if ae.Code != 0 {
 		return ae
@@ -246,30 +1553,391 @@ func (ae *ApiError) checkReturnCode() error {
 }
 
 func getAndDecode(c *Client, urlStr string, vp returnCodeChecker) error {
-	return requestAndDecode(c, "GET", urlStr, nil, vp)
+	return getAndDecodeContext(context.Background(), c, urlStr, vp)
+}
+
+func getAndDecodeContext(ctx context.Context, c *Client, urlStr string,
+	vp returnCodeChecker) error {
+
+	return requestAndDecodeContext(ctx, c, "GET", urlStr, nil, vp)
 }
 
 func requestAndDecode(c *Client, method, urlStr string, data url.Values, vp returnCodeChecker) error {
+	return requestAndDecodeContext(context.Background(), c, method, urlStr, data, vp)
+}
+
+// requestAndDecodeContext sends a request and decodes the response into vp.  If ctx is done
+// before the response is read the error of ctx is returned.  Failed requests are retried as
+// configured with WithRetry.
+func requestAndDecodeContext(ctx context.Context, c *Client, method, urlStr string,
+	data url.Values, vp returnCodeChecker) error {
+
+	_, err := requestAndDecodeWithMeta(ctx, c, method, urlStr, data, vp, nil)
+	return err
+}
+
+// requestAndDecodeRaw is like requestAndDecodeContext but also stores the body of a successful
+// response in raw.
+func requestAndDecodeRaw(ctx context.Context, c *Client, method, urlStr string,
+	data url.Values, vp returnCodeChecker, raw *json.RawMessage) error {
+
+	_, err := requestAndDecodeWithMeta(ctx, c, method, urlStr, data, vp, raw)
+	return err
+}
+
+// requestAndDecodeWithMeta is like requestAndDecodeContext but also returns the ResponseMeta of
+// the last response.  The ResponseMeta is nil if no response was received.  If raw is not nil
+// the body of a successful response is stored in raw.
+func requestAndDecodeWithMeta(ctx context.Context, c *Client, method, urlStr string,
+	data url.Values, vp returnCodeChecker, raw *json.RawMessage) (meta *ResponseMeta, err error) {
+
+	var rsp *http.Response
+	start := time.Now()
+	defer func() {
+		path := urlStr
+		if u, perr := url.Parse(urlStr); perr == nil {
+			path = u.Path
+		}
+		c.callRequestHook(method, path, start, rsp, err)
+	}()
+
+	req, rsp, body, err := c.send(ctx, method, urlStr, data)
+	for attempt := 1; attempt <= c.maxRetries && c.retryable(ctx, method, urlStr, rsp, err); attempt++ {
+		select {
+		case <-time.After(c.retryDelay(attempt, rsp)):
+		case <-ctx.Done():
+			u := urlStr
+			if req != nil {
+				u = req.URL.String()
+			}
+			return nil, networkError(method, u, ctx.Err())
+		}
+		req, rsp, body, err = c.send(ctx, method, urlStr, data)
+	}
+	if rsp != nil {
+		meta = newResponseMeta(rsp)
+	}
+	if err != nil {
+		return meta, err
+	}
+	if err = decodeResponse(rsp, body, vp); err != nil {
+		return meta, err
+	}
+	if raw != nil {
+		*raw = json.RawMessage(body)
+	}
+	if c.responseHook != nil {
+		// The hook receives its own copy so that it cannot modify the caller's result.
+		decoded := reflect.New(reflect.TypeOf(vp).Elem()).Interface()
+		if err = json.Unmarshal(body, decoded); err != nil {
+			return meta, err
+		}
+		c.responseHook(req.URL.Path, rsp.StatusCode, decoded)
+	}
+	return meta, nil
+}
+
+// decodeResponse decodes body, the body of rsp, into vp after checking it for an ApiError and
+// the status code of rsp.
+func decodeResponse(rsp *http.Response, body []byte, vp returnCodeChecker) error {
+	// The error is decoded separately because the type that vp points to may implement
+	// json.Unmarshaler, which hides the fields of an embedded ApiError.
+	apiErr := ApiError{}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != 0 {
+		return rateLimitError(rsp, &apiErr)
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return rateLimitError(rsp, newHTTPError(rsp.StatusCode, body))
+	}
+	if err := json.Unmarshal(body, vp); err != nil {
+		return newDecodeError(err, rsp.StatusCode, body)
+	}
+	return vp.checkReturnCode()
+}
+
+// DoChecked sends req, e.g. a request of NewRequest, and decodes the response into v as the
+// Client methods do: an error response is returned as *ApiError or *HTTPError and the body of
+// the response is always closed.  v is a pointer to a struct that embeds ApiError, such as
+//
+//	v := struct {
+//		oanda.ApiError
+//		Instruments []oanda.InstrumentInfo `json:"instruments"`
+//	}{}
+//
+// req is subject to the rate limit, timeout, logger and request hook of the Client.  Unlike
+// other requests of the Client, req is not retried and the response hook is not invoked.
+func (c *Client) DoChecked(req *http.Request, v returnCodeChecker) (err error) {
+	var rsp *http.Response
+	start := time.Now()
+	defer func() {
+		c.callRequestHook(req.Method, req.URL.Path, start, rsp, err)
+	}()
+
+	var body []byte
+	if rsp, body, err = c.sendRequest(req.Context(), req); err != nil {
+		return err
+	}
+	return decodeResponse(rsp, body, v)
+}
+
+// DoDecode sends a request for urlStr, e.g. "/v1/accounts", with form values data and decodes
+// the response into the value that v points to.  Unlike the other Client methods DoDecode
+// returns the ResponseMeta of the response, also alongside errors such as *ApiError, so that
+// e.g. the request id and the remaining rate limit are available.
+func (c *Client) DoDecode(ctx context.Context, method, urlStr string, data url.Values,
+	v interface{}) (*ResponseMeta, error) {
+
+	var raw json.RawMessage
+	meta, err := requestAndDecodeWithMeta(ctx, c, method, urlStr, data, &ApiError{}, &raw)
+	if err != nil {
+		return meta, err
+	}
+	if err = json.Unmarshal(raw, v); err != nil {
+		return meta, newDecodeError(err, meta.StatusCode, raw)
+	}
+	return meta, nil
+}
+
+// GetRaw sends a GET request for urlStr, e.g. "/v1/accounts", and returns the body of the
+// response without decoding it.
+func (c *Client) GetRaw(urlStr string) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := requestAndDecodeRaw(context.Background(), c, "GET", urlStr, nil, &ApiError{}, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// ServerTime returns the time of the Oanda servers as reported by the Date header of a request for
+// the accounts of the user.  The Date header has a resolution of one second.
+func (c *Client) ServerTime() (time.Time, error) {
+	t, _, err := c.serverTime()
+	return t, err
+}
+
+// ClockSkew returns the difference between the time of the Oanda servers and the local time.  A
+// positive skew means that the local clock runs behind.  The server time is compared with the
+// local time halfway through the request to compensate for the round-trip time; the result is
+// accurate to about a second.
+func (c *Client) ClockSkew() (time.Duration, error) {
+	t, local, err := c.serverTime()
+	if err != nil {
+		return 0, err
+	}
+	return t.Sub(local), nil
+}
+
+// serverTime returns the server time and the local time halfway through the request.
+func (c *Client) serverTime() (server, local time.Time, err error) {
+	start := time.Now()
+	_, rsp, _, err := c.send(context.Background(), "GET", "/v1/accounts", nil)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	local = start.Add(time.Since(start) / 2)
+	// Any response, also an error response, carries the time of the server.
+	date := rsp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, time.Time{}, errors.New("response has no Date header")
+	}
+	if server, err = http.ParseTime(date); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return server, local, nil
+}
+
+// send sends a single request and returns the response and its body.  The body of the request
+// is rebuilt from data so that the request can be repeated.
+func (c *Client) send(ctx context.Context, method, urlStr string, data url.Values) (*http.Request,
+	*http.Response, []byte, error) {
+
 	var rdr io.Reader
 	if len(data) > 0 {
 		rdr = strings.NewReader(data.Encode())
 	}
-	req, err := c.NewRequest(method, urlStr, rdr)
+	override := c.methodOverride && method == "PATCH"
+	if override {
+		method = "POST"
+	}
+	req, err := c.NewRequestWithContext(ctx, method, urlStr, rdr)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
+	}
+	if override {
+		req.Header.Set("X-HTTP-Method-Override", "PATCH")
+	}
+	rsp, body, err := c.sendRequest(ctx, req)
+	return req, rsp, body, err
+}
+
+// sendRequest sends req once, subject to the rate limit and timeout of the Client, and returns
+// the response and its decompressed body.
+func (c *Client) sendRequest(ctx context.Context, req *http.Request) (*http.Response, []byte,
+	error) {
+
+	if c.compression {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, nil, err
+		}
 	}
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+	start := time.Now()
 	rsp, err := c.Do(req)
 	if err != nil {
-		return err
+		err = requestError(req, err)
+		if c.logger != nil {
+			c.logger.Printf("%s %s failed after %s: %s", req.Method, req.URL, time.Since(start), err)
+		}
+		return nil, nil, err
 	}
 	defer rsp.Body.Close()
+	if c.logger != nil {
+		c.logger.Printf("%s %s %d %s", req.Method, req.URL, rsp.StatusCode, time.Since(start))
+	}
 
-	dec := json.NewDecoder(rsp.Body)
-	if err = dec.Decode(vp); err != nil {
-		return err
+	if err = decompressBody(rsp); err != nil {
+		return nil, nil, err
+	}
+	body, err := c.readBody(rsp)
+	if err != nil {
+		if _, ok := err.(*ResponseTooLargeError); ok {
+			return rsp, nil, err
+		}
+		return nil, nil, requestError(req, err)
+	}
+	return rsp, body, nil
+}
+
+// ResponseTooLargeError is returned if the body of a response exceeds the limit of
+// WithMaxResponseBytes.
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (re *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds %d bytes", re.Limit)
+}
+
+// readBody reads the body of rsp up to the limit of WithMaxResponseBytes.
+func (c *Client) readBody(rsp *http.Response) ([]byte, error) {
+	if c.maxRspBytes == 0 {
+		return ioutil.ReadAll(rsp.Body)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(rsp.Body, c.maxRspBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > c.maxRspBytes {
+		return nil, &ResponseTooLargeError{c.maxRspBytes}
 	}
-	if err = vp.checkReturnCode(); err != nil {
+	return body, nil
+}
+
+// decompressBody replaces the body of a gzip encoded response with a reader of the decompressed
+// body.
+func decompressBody(rsp *http.Response) error {
+	if rsp.Header.Get("Content-Encoding") != "gzip" {
+		return nil
+	}
+	zr, err := gzip.NewReader(rsp.Body)
+	if err != nil {
 		return err
 	}
+	rsp.Body = &gzipReadCloser{zr, rsp.Body}
+	rsp.Header.Del("Content-Encoding")
+	rsp.ContentLength = -1
 	return nil
 }
+
+// gzipReadCloser closes both the gzip reader and the underlying body.
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (rc *gzipReadCloser) Close() error {
+	rc.Reader.Close()
+	return rc.body.Close()
+}
+
+// retryable returns true if a request with the specified method that resulted in rsp or err
+// should be retried.  Requests are retried after connection errors and responses with status
+// 429 (Too Many Requests) or 5xx.
+func (c *Client) retryable(ctx context.Context, method, urlStr string, rsp *http.Response,
+	err error) bool {
+
+	if !c.retryMethods[method] || ctx.Err() != nil {
+		return false
+	}
+	if method == "POST" && !c.idemOrders && createsOrder(urlStr) {
+		return false
+	}
+	if _, ok := err.(*ResponseTooLargeError); ok {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode >= 500
+}
+
+// createsOrder returns true if a POST request for urlStr creates an order or a trade.
+func createsOrder(urlStr string) bool {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(u.Path, "/v1/accounts/") && strings.HasSuffix(u.Path, "/orders")
+}
+
+// retryDelay returns the delay before retry attempt n.  The delay is taken from the Retry-After
+// header of rsp, if any, and otherwise grows exponentially with random jitter.
+func (c *Client) retryDelay(n int, rsp *http.Response) time.Duration {
+	if rsp != nil {
+		if d, ok := retryAfter(rsp.Header); ok {
+			return d
+		}
+	}
+	return backoffDelay(c.retryBaseDelay, n)
+}
+
+// retryAfter returns the delay of the Retry-After header in h, which holds either a number of
+// seconds or an HTTP date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoffDelay returns a random delay between half and all of base doubled n-1 times, but at
+// most maxDelay.
+func backoffDelay(base time.Duration, n int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base
+	for i := 1; i < n && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,1119 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+// TestLocalSuite contains tests that do not require access to the Oanda servers.
+type TestLocalSuite struct {
+	c *oanda.Client
+}
+
+var _ = check.Suite(&TestLocalSuite{})
+
+func (ts *TestLocalSuite) SetUpTest(c *check.C) {
+	client, err := oanda.NewFxPracticeClient("token")
+	c.Assert(err, check.IsNil)
+	ts.c = client
+}
+
+// roundTripFunc is an http.RoundTripper that returns canned responses.
+type roundTripFunc = oanda.RoundTripFunc
+
+// newResponse returns an http.Response with the specified status code and body.
+func newResponse(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode:    statusCode,
+		Status:        http.StatusText(statusCode),
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+func (ts *TestLocalSuite) TestMethodOverride(c *check.C) {
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithMethodOverride())
+	c.Assert(err, check.IsNil)
+
+	var req *http.Request
+	client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		req = r
+		return newResponse(http.StatusOK, `{"id": 1, "units": 2}`), nil
+	})
+
+	o, err := client.ModifyOrder(1, oanda.Units(2))
+	c.Assert(err, check.IsNil)
+	c.Assert(o.Units, check.Equals, 2)
+	c.Assert(req.Method, check.Equals, "POST")
+	c.Assert(req.Header.Get("X-HTTP-Method-Override"), check.Equals, "PATCH")
+
+	_, err = client.SetAccountMarginRate(1, 0.05)
+	c.Assert(err, check.IsNil)
+	c.Assert(req.Method, check.Equals, "POST")
+	c.Assert(req.Header.Get("X-HTTP-Method-Override"), check.Equals, "PATCH")
+
+	ts.c.Transport = client.Transport
+	_, err = ts.c.ModifyOrder(1, oanda.Units(2))
+	c.Assert(err, check.IsNil)
+	c.Assert(req.Method, check.Equals, "PATCH")
+	c.Assert(req.Header.Get("X-HTTP-Method-Override"), check.Equals, "")
+}
+
+func (ts *TestLocalSuite) TestNewClientHost(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Check(req.Header.Get("Authorization"), check.Equals, "Bearer token")
+		switch req.URL.Path {
+		case "/v1/accounts":
+			fmt.Fprint(w, `{"accounts": [{"accountId": 1}]}`)
+		case "/v1/prices":
+			fmt.Fprint(w, `{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:05Z","bid":1.3706,"ask":1.3707}}`)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := oanda.NewClient(oanda.Environment("fxpractice"), srv.URL, "token")
+	c.Assert(err, check.IsNil)
+	accs, err := client.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(accs, check.HasLen, 1)
+
+	// Streams are served by the same host.
+	tickC, errC := client.PollPricesStream(context.Background(), []string{"EUR_USD"})
+	tick := <-tickC
+	c.Assert(tick.Instrument, check.Equals, "EUR_USD")
+	_, ok := <-errC
+	c.Assert(ok, check.Equals, false)
+
+	_, err = oanda.NewClient("", srv.URL, "token")
+	c.Assert(err, check.NotNil)
+}
+
+func (ts *TestLocalSuite) TestWithEnvironmentConfig(c *check.C) {
+	var reqs []*http.Request
+	rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		reqs = append(reqs, req)
+		return newResponse(http.StatusOK, `{"accounts": []}`), nil
+	})
+	cfg := oanda.EnvironmentConfig{Scheme: "http", Host: "gateway.internal:8080"}
+	client, err := oanda.NewFxTradeClient("token", oanda.WithRoundTripper(rt),
+		oanda.WithDateFormat(oanda.DateFormatUNIX), oanda.WithEnvironmentConfig(cfg))
+	c.Assert(err, check.IsNil)
+	_, err = client.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(reqs, check.HasLen, 1)
+	c.Assert(reqs[0].URL.String(), check.Equals, "http://gateway.internal:8080/v1/accounts")
+	c.Assert(reqs[0].Header.Get("Authorization"), check.Equals, "Bearer token")
+	c.Assert(reqs[0].Header.Get("X-Accept-Datetime-Format"), check.Equals, "UNIX")
+
+	// Only the scheme is overridden.
+	client, err = oanda.NewFxPracticeClient("token",
+		oanda.WithEnvironmentConfig(oanda.EnvironmentConfig{Scheme: "http"}))
+	c.Assert(err, check.IsNil)
+	c.Assert(client.EndpointURL("/v1/accounts", nil), check.Equals,
+		"http://api-fxpractice.oanda.com/v1/accounts")
+
+	_, err = oanda.NewFxPracticeClient("token",
+		oanda.WithEnvironmentConfig(oanda.EnvironmentConfig{Scheme: "ftp"}))
+	c.Assert(err, check.ErrorMatches, `unsupported scheme "ftp"`)
+}
+
+func (ts *TestLocalSuite) TestSelectAccount(c *check.C) {
+	c.Assert(ts.c.SelectAccount(-1), check.ErrorMatches, "invalid account id -1")
+	c.Assert(ts.c.SelectedAccount(), check.Equals, 0)
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(accountId int) {
+			defer wg.Done()
+			c.Check(ts.c.SelectAccount(accountId), check.IsNil)
+			_, err := ts.c.NewRequest("GET", "/v1/accounts", nil)
+			c.Check(err, check.IsNil)
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(ts.c.SelectedAccount(), check.Not(check.Equals), 0)
+	c.Assert(ts.c.SelectAccount(0), check.IsNil)
+}
+
+func (ts *TestLocalSuite) TestWithAccount(c *check.C) {
+	paths := make(chan string, 2)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paths <- req.URL.Path
+		return newResponse(http.StatusOK, `{"positions": []}`), nil
+	})
+	ts.c.SelectAccount(1)
+	other := ts.c.WithAccount(2)
+	c.Assert(other.Client, check.Equals, ts.c.Client)
+	c.Assert(other.SelectedAccount(), check.Equals, 2)
+	c.Assert(ts.c.SelectedAccount(), check.Equals, 1)
+
+	_, err := ts.c.Positions()
+	c.Assert(err, check.IsNil)
+	_, err = other.Positions()
+	c.Assert(err, check.IsNil)
+	c.Assert(<-paths, check.Equals, "/v1/accounts/1/positions")
+	c.Assert(<-paths, check.Equals, "/v1/accounts/2/positions")
+}
+
+func (ts *TestLocalSuite) TestWith(c *check.C) {
+	var req *http.Request
+	ts.c.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		req = r
+		return newResponse(http.StatusOK, `{"positions": []}`), nil
+	})
+	ts.c.SelectAccount(1)
+	other, err := ts.c.With(oanda.DateFormat("unix"), oanda.UserAgent("custom"))
+	c.Assert(err, check.IsNil)
+	c.Assert(other.Client, check.Equals, ts.c.Client)
+	c.Assert(other.SelectedAccount(), check.Equals, 1)
+
+	_, err = other.Positions()
+	c.Assert(err, check.IsNil)
+	c.Assert(req.URL.Path, check.Equals, "/v1/accounts/1/positions")
+	c.Assert(req.Header.Get("X-Accept-Datetime-Format"), check.Equals, "UNIX")
+	c.Assert(req.Header.Get("User-Agent"), check.Equals, "custom")
+	c.Assert(req.Header.Get("Authorization"), check.Equals, "Bearer token")
+
+	_, err = ts.c.Positions()
+	c.Assert(err, check.IsNil)
+	c.Assert(req.Header.Get("X-Accept-Datetime-Format"), check.Equals, "RFC3339")
+	c.Assert(req.Header.Get("User-Agent"), check.Not(check.Equals), "custom")
+
+	_, err = ts.c.With(oanda.DateFormat("RFC1123"))
+	c.Assert(err, check.ErrorMatches, `unknown date format "RFC1123"`)
+	_, err = ts.c.With(oanda.EnvironmentConfig{Scheme: "ftp"})
+	c.Assert(err, check.ErrorMatches, `unsupported scheme "ftp"`)
+	_, err = ts.c.With(oanda.UserAgent("custom"), nil)
+	c.Assert(err, check.ErrorMatches, "nil RequestModifier")
+}
+
+func (ts *TestLocalSuite) TestInitSandboxAccountRetry(c *check.C) {
+	defer func(d time.Duration) { *oanda.SandboxRetryDelay = d }(*oanda.SandboxRetryDelay)
+	*oanda.SandboxRetryDelay = time.Millisecond
+
+	attempts := Counter{}
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts.Inc()
+		if attempts.Val() < 3 {
+			return newResponse(http.StatusServiceUnavailable, "<html>Unavailable</html>"), nil
+		}
+		return newResponse(http.StatusOK, `{"username": "user", "password": "pwd", "accountId": 1}`), nil
+	})
+	_, _, _, ok := ts.c.SandboxCredentials()
+	c.Assert(ok, check.Equals, false)
+	c.Assert(ts.c.SelectedAccount(), check.Equals, 0)
+	err := oanda.InitSandboxAccount(context.Background(), ts.c)
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts.Val(), check.Equals, 3)
+	userName, password, accountId, ok := ts.c.SandboxCredentials()
+	c.Assert(ok, check.Equals, true)
+	c.Assert(userName, check.Equals, "user")
+	c.Assert(password, check.Equals, "pwd")
+	c.Assert(accountId, check.Equals, 1)
+	c.Assert(ts.c.SelectedAccount(), check.Equals, 1)
+
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/v1/accounts/1/orders")
+		c.Check(req.URL.Query().Get("username"), check.Equals, "user")
+		return newResponse(http.StatusOK, `{"orders": []}`), nil
+	})
+	_, err = ts.c.Orders()
+	c.Assert(err, check.IsNil)
+
+	failures := Counter{}
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		failures.Inc()
+		return nil, errors.New("connection refused")
+	})
+	err = oanda.InitSandboxAccount(context.Background(), ts.c)
+	c.Assert(err, check.ErrorMatches, "failed to create sandbox account after 5 attempts: .*")
+	c.Assert(failures.Val(), check.Equals, 5)
+
+	// The wait before a retry ends when the context is done.
+	*oanda.SandboxRetryDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = oanda.InitSandboxAccount(ctx, ts.c)
+	c.Assert(err, check.Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < time.Second, check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestResponseHook(c *check.C) {
+	var paths []string
+	var hooked *oanda.Account
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithResponseHook(
+		func(path string, status int, decoded interface{}) {
+			c.Check(status, check.Equals, http.StatusOK)
+			paths = append(paths, path)
+			v := reflect.ValueOf(decoded).Elem().FieldByName("Account")
+			if v.IsValid() {
+				acc := v.Interface().(oanda.Account)
+				hooked = &acc
+				// Modifying the decoded value does not affect the result of the call.
+				v.FieldByName("Currency").SetString("EUR")
+			}
+		}))
+	c.Assert(err, check.IsNil)
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusOK, `{"accountId": 1, "accountCurrency": "USD"}`), nil
+	})
+
+	acc, err := client.Account(1)
+	c.Assert(err, check.IsNil)
+	c.Assert(acc.Currency, check.Equals, "USD")
+	c.Assert(paths, check.DeepEquals, []string{"/v1/accounts/1"})
+	c.Assert(hooked, check.NotNil)
+	c.Assert(hooked.AccountId, check.Equals, 1)
+}
+
+func (ts *TestLocalSuite) TestRequestHook(c *check.C) {
+	var infos []oanda.RequestInfo
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithRequestHook(
+		func(info oanda.RequestInfo) {
+			infos = append(infos, info)
+		}))
+	c.Assert(err, check.IsNil)
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch {
+		case req.URL.Path == "/v1/accounts/1":
+			return newResponse(http.StatusOK, `{"accountId": 1}`), nil
+		case req.Header.Get("If-None-Match") == `"abc"`:
+			return newResponse(http.StatusNotModified, ""), nil
+		case req.URL.Path == "/v1/prices":
+			rsp := newResponse(http.StatusOK, `{"prices": []}`)
+			rsp.Header.Set("ETag", `"abc"`)
+			return rsp, nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 2, "message": "not found"}`), nil
+	})
+
+	_, err = client.Account(1)
+	c.Assert(err, check.IsNil)
+	_, err = client.Account(2)
+	c.Assert(err, check.NotNil)
+	pp, err := client.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+	for i := 0; i < 2; i++ {
+		_, err = pp.Poll()
+		c.Assert(err, check.IsNil)
+	}
+
+	c.Assert(infos, check.HasLen, 4)
+	c.Assert(infos[0].Method, check.Equals, "GET")
+	c.Assert(infos[0].Path, check.Equals, "/v1/accounts/1")
+	c.Assert(infos[0].StatusCode, check.Equals, http.StatusOK)
+	c.Assert(infos[0].Err, check.IsNil)
+	c.Assert(infos[1].StatusCode, check.Equals, http.StatusNotFound)
+	c.Assert(infos[1].Err, check.NotNil)
+	c.Assert(infos[2].Path, check.Equals, "/v1/prices")
+	c.Assert(infos[2].StatusCode, check.Equals, http.StatusOK)
+	c.Assert(infos[3].StatusCode, check.Equals, http.StatusNotModified)
+	c.Assert(infos[3].Err, check.IsNil)
+}
+
+func (ts *TestLocalSuite) TestDoDecode(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rsp := newResponse(http.StatusOK, `{"accounts": [{"accountId": 1}]}`)
+		if req.URL.Path != "/v1/accounts" {
+			rsp = newResponse(http.StatusNotFound, `{"code": 2, "message": "not found"}`)
+		}
+		rsp.Header.Set("RequestID", "42")
+		rsp.Header.Set("X-RateLimit-Remaining", "7")
+		return rsp, nil
+	})
+
+	v := struct {
+		Accounts []struct {
+			AccountId int `json:"accountId"`
+		} `json:"accounts"`
+	}{}
+	meta, err := ts.c.DoDecode(context.Background(), "GET", "/v1/accounts", nil, &v)
+	c.Assert(err, check.IsNil)
+	c.Assert(v.Accounts, check.HasLen, 1)
+	c.Assert(v.Accounts[0].AccountId, check.Equals, 1)
+	c.Assert(meta.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(meta.RequestId, check.Equals, "42")
+	n, ok := meta.RateLimitRemaining()
+	c.Assert(ok, check.Equals, true)
+	c.Assert(n, check.Equals, 7)
+
+	// The ResponseMeta is also returned with errors.
+	meta, err = ts.c.DoDecode(context.Background(), "GET", "/v1/missing", nil, &v)
+	c.Assert(err, check.FitsTypeOf, &oanda.ApiError{})
+	c.Assert(meta, check.NotNil)
+	c.Assert(meta.StatusCode, check.Equals, http.StatusNotFound)
+	c.Assert(meta.RequestId, check.Equals, "42")
+}
+
+func (ts *TestLocalSuite) TestDoChecked(c *check.C) {
+	closed := Counter{}
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rsp := newResponse(http.StatusOK, `{"accounts": [{"accountId": 1}]}`)
+		if req.URL.Path != "/v1/accounts" {
+			rsp = newResponse(http.StatusNotFound, `{"code": 2, "message": "not found"}`)
+		}
+		rsp.Body = closeCounter{rsp.Body, &closed}
+		return rsp, nil
+	})
+
+	v := struct {
+		oanda.ApiError
+		Accounts []struct {
+			AccountId int `json:"accountId"`
+		} `json:"accounts"`
+	}{}
+	req, err := ts.c.NewRequest("GET", "/v1/accounts", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(ts.c.DoChecked(req, &v), check.IsNil)
+	c.Assert(v.Accounts, check.HasLen, 1)
+	c.Assert(v.Accounts[0].AccountId, check.Equals, 1)
+
+	req, err = ts.c.NewRequest("GET", "/v1/missing", nil)
+	c.Assert(err, check.IsNil)
+	err = ts.c.DoChecked(req, &v)
+	c.Assert(err, check.FitsTypeOf, &oanda.ApiError{})
+	c.Assert(err, check.ErrorMatches, ".*not found.*")
+	c.Assert(closed.Val(), check.Equals, 2)
+
+	// The request hook and the timeout of the Client apply.
+	var infos []oanda.RequestInfo
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}), oanda.WithTimeout(10*time.Millisecond), oanda.WithRequestHook(func(info oanda.RequestInfo) {
+		infos = append(infos, info)
+	}))
+	req, err = client.NewRequest("GET", "/v1/accounts", nil)
+	c.Assert(err, check.IsNil)
+	err = client.DoChecked(req, &v)
+	_, ok := err.(*oanda.NetworkError)
+	c.Assert(ok, check.Equals, true, check.Commentf("%v", err))
+	c.Assert(infos, check.HasLen, 1)
+	c.Assert(infos[0].Path, check.Equals, "/v1/accounts")
+	c.Assert(infos[0].Err, check.Equals, err)
+}
+
+// closeCounter counts the calls of Close.
+type closeCounter struct {
+	io.ReadCloser
+	n *Counter
+}
+
+func (cc closeCounter) Close() error {
+	cc.n.Inc()
+	return cc.ReadCloser.Close()
+}
+
+func (ts *TestLocalSuite) TestUse(c *check.C) {
+	var headers http.Header
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		headers = req.Header
+		return newResponse(http.StatusOK, `{"accounts": []}`), nil
+	}))
+	var auth []string
+	client.Use(func(req *http.Request) {
+		// The built-in modifiers have run.
+		auth = append(auth, req.Header.Get("Authorization"))
+		req.Header.Set("X-Trace-Id", "1")
+	})
+	before := client.WithAccount(1)
+	client.Use(func(req *http.Request) {
+		req.Header.Set("X-Trace-Id", req.Header.Get("X-Trace-Id")+",2")
+	})
+
+	_, err := client.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(headers.Get("X-Trace-Id"), check.Equals, "1,2")
+	c.Assert(auth, check.DeepEquals, []string{"Bearer test-token"})
+
+	_, err = before.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(headers.Get("X-Trace-Id"), check.Equals, "1")
+	_, err = client.WithAccount(1).Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(headers.Get("X-Trace-Id"), check.Equals, "1,2")
+}
+
+func (ts *TestLocalSuite) TestTransportTuning(c *check.C) {
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithTransportTuning(8, 16))
+	c.Assert(err, check.IsNil)
+	t, ok := client.Transport.(*http.Transport)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(t.MaxIdleConnsPerHost, check.Equals, 8)
+	c.Assert(t.MaxConnsPerHost, check.Equals, 16)
+	c.Assert(t.Proxy, check.NotNil)
+	c.Assert(t.TLSHandshakeTimeout, check.Equals, 10*time.Second)
+	client.Close()
+
+	// The default transport keeps idle connections disabled.
+	def, err := oanda.NewFxPracticeClient("token")
+	c.Assert(err, check.IsNil)
+	c.Assert(def.Transport, check.Not(check.Equals), client.Transport)
+	c.Assert(def.Transport.(*http.Transport).MaxIdleConnsPerHost, check.Equals, -1)
+
+	_, err = oanda.NewFxPracticeClient("token", oanda.WithTransportTuning(8, -1))
+	c.Assert(err, check.NotNil)
+}
+
+func (ts *TestLocalSuite) TestTLSConfig(c *check.C) {
+	cfg := &tls.Config{ServerName: "proxy.example.com"}
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithTLSConfig(cfg),
+		oanda.WithTransportTuning(8, 0))
+	c.Assert(err, check.IsNil)
+	t := client.Transport.(*http.Transport)
+	c.Assert(t.TLSClientConfig, check.Equals, cfg)
+	c.Assert(t.MaxIdleConnsPerHost, check.Equals, 8)
+	c.Assert(t.TLSHandshakeTimeout, check.Equals, 10*time.Second)
+
+	def, err := oanda.NewFxPracticeClient("token")
+	c.Assert(err, check.IsNil)
+	c.Assert(def.Transport.(*http.Transport).TLSClientConfig, check.IsNil)
+}
+
+func (ts *TestLocalSuite) TestProxy(c *check.C) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Requests to a proxy hold the absolute URL of the target.
+		proxied = append(proxied, req.URL.String())
+		fmt.Fprint(w, `{"accounts": [{"accountId": 1}]}`)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	c.Assert(err, check.IsNil)
+
+	client, err := oanda.NewClient(oanda.Environment("fxpractice"), "http://api-fxpractice.oanda.com",
+		"token", oanda.WithProxy(proxyURL))
+	c.Assert(err, check.IsNil)
+	accs, err := client.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(accs, check.HasLen, 1)
+	c.Assert(proxied, check.DeepEquals, []string{"http://api-fxpractice.oanda.com/v1/accounts"})
+
+	client, err = oanda.NewFxPracticeClient("token", oanda.WithoutProxy())
+	c.Assert(err, check.IsNil)
+	c.Assert(client.Transport.(*http.Transport).Proxy, check.IsNil)
+
+	_, err = oanda.NewFxPracticeClient("token", oanda.WithProxy(nil))
+	c.Assert(err, check.NotNil)
+}
+
+func (ts *TestLocalSuite) TestNewTestClient(c *check.C) {
+	var hosts []string
+	rt := oanda.RoundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.Header.Get("Authorization"), check.Equals, "Bearer test-token")
+		hosts = append(hosts, req.URL.Host)
+		return newResponse(http.StatusOK, `{"accounts": [{"accountId": 1}]}`), nil
+	})
+	proxyURL, err := url.Parse("http://proxy.invalid")
+	c.Assert(err, check.IsNil)
+	client := oanda.NewTestClient(rt, oanda.WithProxy(proxyURL))
+	accs, err := client.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(accs, check.HasLen, 1)
+	c.Assert(hosts, check.DeepEquals, []string{"api-test.oanda.invalid"})
+
+	defer func() {
+		c.Assert(recover(), check.ErrorMatches, "No RoundTripper")
+	}()
+	oanda.NewTestClient(nil)
+}
+
+func (ts *TestLocalSuite) TestGetRaw(c *check.C) {
+	const body = `{"accountId": 1, "unmodelled": {"a": [1, 2]}}`
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/v1/accounts/1" {
+			return newResponse(http.StatusNotFound, `{"code": 2, "message": "not found"}`), nil
+		}
+		return newResponse(http.StatusOK, body), nil
+	})
+
+	raw, err := ts.c.GetRaw("/v1/accounts/1")
+	c.Assert(err, check.IsNil)
+	c.Assert(string(raw), check.Equals, body)
+	_, err = ts.c.GetRaw("/v1/accounts/2")
+	c.Assert(err, check.FitsTypeOf, &oanda.ApiError{})
+
+	// The body is retained alongside the decoded value.
+	v := struct {
+		oanda.ApiError
+		AccountId int `json:"accountId"`
+	}{}
+	raw = nil
+	err = oanda.RequestAndDecodeRaw(context.Background(), ts.c, "GET", "/v1/accounts/1", nil, &v,
+		&raw)
+	c.Assert(err, check.IsNil)
+	c.Assert(v.AccountId, check.Equals, 1)
+	c.Assert(string(raw), check.Equals, body)
+}
+
+func (ts *TestLocalSuite) TestTimeout(c *check.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	go func() {
+		// Accept connections but never respond.
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithTimeout(50*time.Millisecond))
+	c.Assert(err, check.IsNil)
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+		req.URL.Host = l.Addr().String()
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	start := time.Now()
+	_, err = client.Account(1)
+	var netErr *oanda.NetworkError
+	c.Assert(errors.As(err, &netErr), check.Equals, true)
+	c.Assert(netErr.Timeout(), check.Equals, true)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), check.Equals, true)
+	c.Assert(time.Since(start) < time.Second, check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestRequestWithContext(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := ts.c.NewRequestWithContext(ctx, "GET", "/v1/accounts", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(req.Header.Get("Authorization"), check.Equals, "Bearer token")
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = ts.c.Do(req)
+	c.Assert(errors.Is(err, context.Canceled), check.Equals, true)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = oanda.GetAndDecodeContext(ctx, ts.c, "/v1/accounts", &oanda.ApiError{})
+	c.Assert(errors.Is(err, context.DeadlineExceeded), check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestUserAgent(c *check.C) {
+	req, err := ts.c.NewRequest("GET", "/v1/accounts", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(req.Header.Get("User-Agent"), check.Equals, "oanda-go/"+oanda.Version)
+
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithUserAgent("myapp/1.0"))
+	c.Assert(err, check.IsNil)
+	req, err = client.NewRequest("GET", "/v1/accounts", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(req.Header.Get("User-Agent"), check.Equals, "oanda-go/"+oanda.Version+" myapp/1.0")
+}
+
+func (ts *TestLocalSuite) TestApiErrorSentinels(c *check.C) {
+	sentinels := map[int]error{
+		1:  oanda.ErrInvalidArgument,
+		2:  oanda.ErrMissingArgument,
+		4:  oanda.ErrUnauthorized,
+		24: oanda.ErrMarketHalted,
+		46: oanda.ErrInvalidInstrument,
+		68: oanda.ErrRateLimited,
+	}
+	for code, sentinel := range sentinels {
+		var err error = &oanda.ApiError{Code: code}
+		c.Check(errors.Is(err, sentinel), check.Equals, true, check.Commentf("code %d", code))
+		c.Check(errors.Is(err, oanda.ErrRateLimited), check.Equals, code == 68)
+	}
+
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusTooManyRequests,
+			`{"code": 68, "message": "Rate limit violation of allowed requests"}`), nil
+	})
+	_, err := ts.c.Account(1)
+	c.Assert(errors.Is(err, oanda.ErrRateLimited), check.Equals, true)
+	apiErr := &oanda.ApiError{}
+	c.Assert(errors.As(err, &apiErr), check.Equals, true)
+	c.Assert(apiErr.Code, check.Equals, 68)
+
+	var unmapped error = &oanda.ApiError{Code: 999}
+	for _, sentinel := range sentinels {
+		c.Check(errors.Is(unmapped, sentinel), check.Equals, false)
+	}
+}
+
+func (ts *TestLocalSuite) TestRateLimitError(c *check.C) {
+	retryAt := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	for retryAfter, expected := range map[string]time.Duration{
+		"":      0,
+		"3":     3 * time.Second,
+		retryAt: time.Hour,
+	} {
+		ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			rsp := newResponse(http.StatusTooManyRequests, "Too Many Requests")
+			if retryAfter != "" {
+				rsp.Header.Set("Retry-After", retryAfter)
+			}
+			return rsp, nil
+		})
+		_, err := ts.c.Account(1)
+		c.Assert(errors.Is(err, oanda.ErrRateLimited), check.Equals, true)
+		rlErr := &oanda.RateLimitError{}
+		c.Assert(errors.As(err, &rlErr), check.Equals, true)
+		c.Assert(rlErr.RetryAfter <= expected, check.Equals, true)
+		c.Assert(rlErr.RetryAfter > expected-time.Minute || expected == 0, check.Equals, true)
+		httpErr := &oanda.HTTPError{}
+		c.Assert(errors.As(err, &httpErr), check.Equals, true)
+		c.Assert(httpErr.StatusCode, check.Equals, http.StatusTooManyRequests)
+	}
+}
+
+func (ts *TestLocalSuite) TestBackoffDelay(c *check.C) {
+	base := 100 * time.Millisecond
+	for _, t := range []struct {
+		n        int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{50, oanda.MaxDelay / 2, oanda.MaxDelay},
+		{64, oanda.MaxDelay / 2, oanda.MaxDelay},
+		{1000, oanda.MaxDelay / 2, oanda.MaxDelay},
+	} {
+		d := oanda.BackoffDelay(base, t.n)
+		c.Check(d >= t.min && d <= t.max, check.Equals, true, check.Commentf("n=%d: %s", t.n, d))
+	}
+	c.Assert(oanda.BackoffDelay(0, 10), check.Equals, time.Duration(0))
+}
+
+func (ts *TestLocalSuite) TestRetry(c *check.C) {
+	var bodies []string
+	failures := 2
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			data, _ := ioutil.ReadAll(req.Body)
+			bodies = append(bodies, string(data))
+		} else {
+			bodies = append(bodies, "")
+		}
+		if len(bodies) <= failures {
+			rsp := newResponse(http.StatusServiceUnavailable, "<html>Unavailable</html>")
+			rsp.Header.Set("Retry-After", "0")
+			return rsp, nil
+		}
+		return newResponse(http.StatusOK, `{"accountId": 1, "accountCurrency": "USD"}`), nil
+	})
+
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithRetry(3, time.Millisecond))
+	c.Assert(err, check.IsNil)
+	client.Transport = transport
+	acc, err := client.Account(1)
+	c.Assert(err, check.IsNil)
+	c.Assert(acc.Currency, check.Equals, "USD")
+	c.Assert(bodies, check.HasLen, 3)
+
+	// POST requests are not retried by default.
+	bodies = nil
+	_, err = client.NewTrade(oanda.Buy, 1, "EUR_USD")
+	c.Assert(err, check.NotNil)
+	c.Assert(bodies, check.HasLen, 1)
+
+	// Requests that create trades are not retried without WithIdempotentOrders.
+	client, err = oanda.NewFxPracticeClient("token", oanda.WithRetry(3, time.Millisecond),
+		oanda.WithRetryMethods("GET", "POST"))
+	c.Assert(err, check.IsNil)
+	client.Transport = transport
+	bodies = nil
+	_, err = client.NewTrade(oanda.Buy, 1, "EUR_USD")
+	c.Assert(err, check.NotNil)
+	c.Assert(bodies, check.HasLen, 1)
+
+	// The body of retried POST requests is sent again.
+	client, err = oanda.NewFxPracticeClient("token", oanda.WithRetry(3, time.Millisecond),
+		oanda.WithRetryMethods("GET", "POST"), oanda.WithIdempotentOrders())
+	c.Assert(err, check.IsNil)
+	client.Transport = transport
+	bodies = nil
+	_, err = client.NewTrade(oanda.Buy, 1, "EUR_USD")
+	c.Assert(err, check.IsNil)
+	c.Assert(bodies, check.HasLen, 3)
+	c.Assert(bodies[0], check.Not(check.Equals), "")
+	c.Assert(bodies[1], check.Equals, bodies[0])
+	c.Assert(bodies[2], check.Equals, bodies[0])
+
+	// The last error is returned when all retries fail.
+	bodies, failures = nil, 10
+	_, err = client.Account(1)
+	c.Assert(err, check.FitsTypeOf, &oanda.HTTPError{})
+	c.Assert(bodies, check.HasLen, 4)
+
+	// A context that is done while waiting to retry ends the request with a NetworkError.
+	client, err = oanda.NewFxPracticeClient("token", oanda.WithRetry(3, time.Hour))
+	c.Assert(err, check.IsNil)
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusServiceUnavailable, "<html>Unavailable</html>"), nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = oanda.GetAndDecodeContext(ctx, client, "/v1/accounts/1", &oanda.ApiError{})
+	c.Assert(err, check.FitsTypeOf, &oanda.NetworkError{})
+	c.Assert(errors.Is(err, context.DeadlineExceeded), check.Equals, true)
+	c.Assert(err.(*oanda.NetworkError).Timeout(), check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestRateLimit(c *check.C) {
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithRateLimit(100, 2))
+	c.Assert(err, check.IsNil)
+	requests := Counter{}
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Inc()
+		if req.URL.Path == "/v1/prices" {
+			return newResponse(http.StatusOK, `{"prices": []}`), nil
+		}
+		return newResponse(http.StatusOK, `{"accountId": 1}`), nil
+	})
+
+	// Two requests are sent immediately and the remaining eight at 100 per second, shared
+	// between order and price calls.
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := client.Account(1)
+			c.Check(err, check.IsNil)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := client.PollPrices("EUR_USD")
+			c.Check(err, check.IsNil)
+		}()
+	}
+	wg.Wait()
+	c.Assert(requests.Val(), check.Equals, 10)
+	c.Assert(time.Since(start) >= 70*time.Millisecond, check.Equals, true)
+
+	_, err = oanda.NewFxPracticeClient("token", oanda.WithRateLimit(0, 1))
+	c.Assert(err, check.NotNil)
+}
+
+func (ts *TestLocalSuite) TestHTTPError(c *check.C) {
+	status, body := http.StatusBadGateway, "<html>Bad Gateway</html>"
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(status, body), nil
+	})
+	_, err := ts.c.Account(1)
+	c.Assert(err, check.DeepEquals, &oanda.HTTPError{
+		StatusCode: http.StatusBadGateway,
+		Body:       []byte("<html>Bad Gateway</html>"),
+	})
+
+	body = strings.Repeat("x", 4096)
+	_, err = ts.c.Account(1)
+	c.Assert(err.(*oanda.HTTPError).Body, check.HasLen, 1024)
+
+	// Errors reported by Oanda are returned as ApiError regardless of the status.
+	status, body = http.StatusBadRequest, `{"code": 1, "message": "Invalid value"}`
+	_, err = ts.c.Account(1)
+	c.Assert(err, check.FitsTypeOf, &oanda.ApiError{})
+
+	status, body = http.StatusOK, `{"accountId": 1}`
+	acc, err := ts.c.Account(1)
+	c.Assert(err, check.IsNil)
+	c.Assert(acc.AccountId, check.Equals, 1)
+}
+
+func (ts *TestLocalSuite) TestDecodeError(c *check.C) {
+	body := `{"accountId": 1`
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusOK, body), nil
+	})
+	_, err := ts.c.Account(1)
+	decodeErr, ok := err.(*oanda.DecodeError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(decodeErr.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(string(decodeErr.Body), check.Equals, body)
+
+	body = `{"accountId": "1"}`
+	_, err = ts.c.Account(1)
+	var typeErr *json.UnmarshalTypeError
+	c.Assert(errors.As(err, &typeErr), check.Equals, true)
+
+	body = "<" + strings.Repeat("x", 10000)
+	_, err = ts.c.Account(1)
+	var syntaxErr *json.SyntaxError
+	c.Assert(errors.As(err, &syntaxErr), check.Equals, true)
+	c.Assert(err.(*oanda.DecodeError).Body, check.HasLen, 8*1024)
+}
+
+func (ts *TestLocalSuite) TestCompression(c *check.C) {
+	gzipResponse := func(body string) *http.Response {
+		buf := bytes.Buffer{}
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(body))
+		zw.Close()
+		rsp := newResponse(http.StatusOK, buf.String())
+		rsp.Header.Set("Content-Encoding", "gzip")
+		return rsp
+	}
+	var encodings []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		encodings = append(encodings, req.Header.Get("Accept-Encoding"))
+		if req.URL.Path == "/v1/prices" {
+			return gzipResponse(`{"prices": [{"instrument": "EUR_USD", "bid": 1.3706}]}`), nil
+		}
+		return gzipResponse(`{"accountId": 1, "accountCurrency": "USD"}`), nil
+	})
+
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithCompression())
+	c.Assert(err, check.IsNil)
+	client.Transport = transport
+	acc, err := client.Account(1)
+	c.Assert(err, check.IsNil)
+	c.Assert(acc.Currency, check.Equals, "USD")
+	prices, err := client.PollPrices("EUR_USD")
+	c.Assert(err, check.IsNil)
+	c.Assert(prices["EUR_USD"].Bid, check.Equals, 1.3706)
+	c.Assert(encodings, check.DeepEquals, []string{"gzip", "gzip"})
+
+	encodings = nil
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		encodings = append(encodings, req.Header.Get("Accept-Encoding"))
+		return newResponse(http.StatusOK, `{"accountId": 1}`), nil
+	})
+	_, err = ts.c.Account(1)
+	c.Assert(err, check.IsNil)
+	c.Assert(encodings, check.DeepEquals, []string{""})
+}
+
+func (ts *TestLocalSuite) TestCancelRequest(c *check.C) {
+	started := make(chan struct{})
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		close(started)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	req, err := ts.c.NewRequest("GET", "/v1/prices", nil)
+	c.Assert(err, check.IsNil)
+	go func() {
+		<-started
+		ts.c.CancelRequest(req)
+	}()
+	_, err = ts.c.Do(req)
+	c.Assert(errors.Is(err, context.Canceled), check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestClose(c *check.C) {
+	started := make(chan struct{})
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		close(started)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	go func() {
+		<-started
+		ts.c.Close()
+	}()
+	_, err := ts.c.Accounts()
+	c.Assert(errors.Is(err, context.Canceled), check.Equals, true)
+
+	client, err := oanda.NewFxPracticeClient("token")
+	c.Assert(err, check.IsNil)
+	client.Close()
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (ts *TestLocalSuite) TestLogger(c *check.C) {
+	logger := testLogger{}
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithLogger(&logger))
+	c.Assert(err, check.IsNil)
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/v1/accounts/2" {
+			return nil, errors.New("connection refused")
+		}
+		return newResponse(http.StatusOK, `{"accountId": 1}`), nil
+	})
+	_, err = client.Account(1)
+	c.Assert(err, check.IsNil)
+	_, err = client.Account(2)
+	c.Assert(err, check.NotNil)
+
+	c.Assert(logger.lines, check.HasLen, 2)
+	c.Assert(logger.lines[0], check.Matches,
+		`GET https://api-fxpractice.oanda.com/v1/accounts/1 200 .*s`)
+	c.Assert(logger.lines[1], check.Matches,
+		`GET https://api-fxpractice.oanda.com/v1/accounts/2 failed after .*s: .*connection refused`)
+}
+
+func (ts *TestLocalSuite) TestClockSkew(c *check.C) {
+	server := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Assert(req.URL.Path, check.Equals, "/v1/accounts")
+		rsp := newResponse(401, `{"code": 4, "message": "Insufficient authorization"}`)
+		rsp.Header.Set("Date", server.Format(http.TimeFormat))
+		return rsp, nil
+	}))
+
+	t, err := client.ServerTime()
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Equal(server), check.Equals, true)
+
+	skew, err := client.ClockSkew()
+	c.Assert(err, check.IsNil)
+	c.Assert(skew > time.Hour-2*time.Second && skew <= time.Hour, check.Equals, true,
+		check.Commentf("%s", skew))
+
+	client = oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(200, "{}"), nil
+	}))
+	_, err = client.ClockSkew()
+	c.Assert(err, check.ErrorMatches, "response has no Date header")
+}
+
+func (ts *TestLocalSuite) TestNetworkError(c *check.C) {
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}))
+	_, err := client.Accounts()
+	var netErr *oanda.NetworkError
+	c.Assert(errors.As(err, &netErr), check.Equals, true)
+	c.Assert(netErr.Timeout(), check.Equals, false)
+	c.Assert(err, check.ErrorMatches, `Get ".*/v1/accounts": connection refused`)
+	var apiErr *oanda.ApiError
+	c.Assert(errors.As(err, &apiErr), check.Equals, false)
+
+	client = oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(400, `{"code": 1, "message": "Invalid argument"}`), nil
+	}))
+	_, err = client.Accounts()
+	c.Assert(errors.As(err, &apiErr), check.Equals, true)
+	c.Assert(apiErr.Code, check.Equals, 1)
+	c.Assert(errors.As(err, &netErr), check.Equals, false)
+}
+
+func (ts *TestLocalSuite) TestMaxResponseBytes(c *check.C) {
+	const body = `{"accounts": [{"accountId": 1, "accountName": "Primary"}]}`
+	requests := Counter{}
+	rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Inc()
+		return newResponse(200, body), nil
+	})
+
+	client := oanda.NewTestClient(rt, oanda.WithMaxResponseBytes(int64(len(body))),
+		oanda.WithRetry(2, time.Millisecond))
+	accounts, err := client.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(accounts, check.HasLen, 1)
+
+	client = oanda.NewTestClient(rt, oanda.WithMaxResponseBytes(int64(len(body)-1)),
+		oanda.WithRetry(2, time.Millisecond))
+	_, err = client.Accounts()
+	c.Assert(err, check.FitsTypeOf, &oanda.ResponseTooLargeError{})
+	c.Assert(err, check.ErrorMatches, "response body exceeds 57 bytes")
+	// Too large responses are not retried.
+	c.Assert(requests.Val(), check.Equals, 2)
+}
+
+func (ts *TestLocalSuite) TestEndpointURL(c *check.C) {
+	client, err := oanda.NewFxTradeClient("token")
+	c.Assert(err, check.IsNil)
+	c.Assert(client.EndpointURL("/v1/accounts", nil), check.Equals,
+		"https://api-fxtrade.oanda.com/v1/accounts")
+	c.Assert(client.EndpointURL("/v1/candles?instrument=EUR_USD", url.Values{"count": {"2"}}),
+		check.Equals, "https://api-fxtrade.oanda.com/v1/candles?count=2&instrument=EUR_USD")
+
+	client, err = oanda.NewClient("fxpractice", "localhost:8080", "token")
+	c.Assert(err, check.IsNil)
+	c.Assert(client.EndpointURL("/v1/prices", url.Values{"instruments": {"EUR_USD,USD_JPY"}}),
+		check.Equals, "https://localhost:8080/v1/prices?instruments=EUR_USD%2CUSD_JPY")
+
+	client = oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("unexpected request")
+	}))
+	c.Assert(client.EndpointURL("/v1/accounts", nil), check.Equals,
+		"http://api-test.oanda.invalid/v1/accounts")
+}
+
+func (ts *TestLocalSuite) TestClientTrace(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/accounts":
+			io.WriteString(w, `{"accounts": []}`)
+		case "/v1/prices":
+			io.WriteString(w, capturedPriceStream)
+		}
+	}))
+	defer srv.Close()
+
+	mtx := sync.Mutex{}
+	conns := make(map[string]int)
+	trace := func(req *http.Request) *httptrace.ClientTrace {
+		return &httptrace.ClientTrace{
+			GotConn: func(httptrace.GotConnInfo) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				conns[req.URL.Path]++
+			},
+		}
+	}
+	client, err := oanda.NewClient("fxpractice", srv.URL, "token", oanda.WithClientTrace(trace))
+	c.Assert(err, check.IsNil)
+	client.SelectAccount(1)
+
+	_, err = client.Accounts()
+	c.Assert(err, check.IsNil)
+	tickC, errC := client.PollPricesStream(context.Background(), []string{"EUR_USD"})
+	for range tickC {
+	}
+	c.Assert(<-errC, check.IsNil)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	c.Assert(conns, check.DeepEquals, map[string]int{"/v1/accounts": 1, "/v1/prices": 1})
+}
@@ -0,0 +1,303 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateCacheTTL is the duration for which a conversion rate is reused.
+const rateCacheTTL = time.Minute
+
+// crossCurrency is the currency through which currencies without a direct pair are converted.
+const crossCurrency = "USD"
+
+type cachedRate struct {
+	rate float64
+	time time.Time
+}
+
+// rateCache caches the information that is needed to convert amounts into the account currency.
+type rateCache struct {
+	mtx   sync.Mutex
+	homes map[int]string
+	rates map[string]cachedRate
+
+	// instruments holds the instrument information of each account.
+	instruments map[int]cachedInstruments
+}
+
+type cachedInstruments struct {
+	infos map[string]InstrumentInfo
+	time  time.Time
+}
+
+func newRateCache() *rateCache {
+	return &rateCache{
+		homes:       make(map[int]string),
+		rates:       make(map[string]cachedRate),
+		instruments: make(map[int]cachedInstruments),
+	}
+}
+
+// setHome caches currency as the currency of account accountId.
+func (rc *rateCache) setHome(accountId int, currency string) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	rc.homes[accountId] = currency
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// PipValue
+
+// PipValue returns the value in account currency of a one pip move of a position of the
+// specified number of units in instrument.  The value is exact if the instrument is quoted in
+// the account currency; otherwise it is converted at the midpoint price, which is a float64, and
+// rounded to the nearest Decimal.
+//
+// Conversion rates are cached for a minute.
+func (c *Client) PipValue(instrument string, units int) (Decimal, error) {
+	instrument = strings.ToUpper(instrument)
+	home, instruments, err := c.conversionInfo()
+	if err != nil {
+		return Decimal{}, err
+	}
+	info, ok := instruments[instrument]
+	if !ok {
+		return Decimal{}, fmt.Errorf("unknown instrument %s", instrument)
+	}
+	_, quote := splitInstrument(instrument)
+	rate, err := c.cachedRate(quote, home, instruments)
+	if err != nil {
+		return Decimal{}, err
+	}
+	value, err := NewDecimal(info.Pip).mulInt(units)
+	if err != nil || rate == 1 {
+		return value, err
+	}
+	return NewDecimal(value.Float64() * rate), nil
+}
+
+// conversionInfo returns the currency of the selected account and the available instruments.
+func (c *Client) conversionInfo() (string, map[string]InstrumentInfo, error) {
+	accountId := c.SelectedAccount()
+	rc := c.rates
+	rc.mtx.Lock()
+	home := rc.homes[accountId]
+	rc.mtx.Unlock()
+
+	if home == "" {
+		acc, err := c.Account(accountId)
+		if err != nil {
+			return "", nil, err
+		}
+		home = acc.Currency
+		rc.setHome(accountId, home)
+	}
+	instruments, err := c.cachedInstruments()
+	if err != nil {
+		return "", nil, err
+	}
+	return home, instruments, nil
+}
+
+// cachedRate returns the rate with which an amount in currency cur is converted to currency
+// home.  Rates are polled at most once every rateCacheTTL.  Currencies without a pair of their
+// own are converted through USD.
+func (c *Client) cachedRate(cur, home string, instruments map[string]InstrumentInfo) (float64,
+	error) {
+
+	if cur == home {
+		return 1, nil
+	}
+	key := cur + "_" + home
+	rc := c.rates
+	rc.mtx.Lock()
+	cr, ok := rc.rates[key]
+	rc.mtx.Unlock()
+	if ok && time.Since(cr.time) < rateCacheTTL {
+		return cr.rate, nil
+	}
+
+	instr, _, err := conversionInstrument(cur, home, instruments)
+	if err != nil {
+		if cur == crossCurrency || home == crossCurrency {
+			return 0, err
+		}
+		_, _, toErr := conversionInstrument(cur, crossCurrency, instruments)
+		_, _, fromErr := conversionInstrument(crossCurrency, home, instruments)
+		if toErr != nil || fromErr != nil {
+			return 0, fmt.Errorf("no instrument to convert %s to %s", cur, home)
+		}
+		toCross, err := c.cachedRate(cur, crossCurrency, instruments)
+		if err != nil {
+			return 0, fmt.Errorf("failed to convert %s to %s: %w", cur, home, err)
+		}
+		fromCross, err := c.cachedRate(crossCurrency, home, instruments)
+		if err != nil {
+			return 0, fmt.Errorf("failed to convert %s to %s: %w", cur, home, err)
+		}
+		return toCross * fromCross, nil
+	}
+	prices, err := c.PollPrices(instr)
+	if err != nil {
+		return 0, err
+	}
+	rate, err := conversionRate(cur, home, instruments, prices)
+	if err != nil {
+		return 0, err
+	}
+
+	rc.mtx.Lock()
+	rc.rates[key] = cachedRate{rate, time.Now()}
+	rc.mtx.Unlock()
+	return rate, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// ConvertToHomeCurrency
+
+// ConvertToHomeCurrency converts amount in currency fromCurrency, e.g. the profit or loss of a
+// trade in the quote currency of its instrument, into the currency of account accountId at the
+// current midpoint price.  Currencies without a pair with the account currency are converted
+// through USD.
+//
+// The account currency is cached and conversion rates are cached for a minute.
+func (c *Client) ConvertToHomeCurrency(accountId int, amount float64, fromCurrency string) (float64,
+	error) {
+
+	ac := c.WithAccount(accountId)
+	home, instruments, err := ac.conversionInfo()
+	if err != nil {
+		return 0, err
+	}
+	rate, err := ac.cachedRate(strings.ToUpper(fromCurrency), home, instruments)
+	if err != nil {
+		return 0, err
+	}
+	return amount * rate, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Pips
+
+// PipsToPrice converts a distance of pips pips in instrument, e.g. of a trailing stop, into a
+// price distance.  The pip size is taken from the instrument cache, see WithInstrumentCacheTTL.
+func (c *Client) PipsToPrice(instrument string, pips float64) (float64, error) {
+	pip, err := c.cachedPip(instrument)
+	if err != nil {
+		return 0, err
+	}
+	return pips * pip, nil
+}
+
+// PriceToPips converts the price distance priceDistance in instrument into pips.  The pip size
+// is taken from the instrument cache, see WithInstrumentCacheTTL.
+func (c *Client) PriceToPips(instrument string, priceDistance float64) (float64, error) {
+	pip, err := c.cachedPip(instrument)
+	if err != nil {
+		return 0, err
+	}
+	return priceDistance / pip, nil
+}
+
+// cachedPip returns the pip size of instrument.
+func (c *Client) cachedPip(instrument string) (float64, error) {
+	instrument = strings.ToUpper(instrument)
+	if err := validateInstrument(instrument); err != nil {
+		return 0, err
+	}
+	instruments, err := c.cachedInstruments()
+	if err != nil {
+		return 0, err
+	}
+	info, ok := instruments[instrument]
+	if !ok || info.Pip == 0 {
+		return 0, fmt.Errorf("unknown instrument %s", instrument)
+	}
+	return info.Pip, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Conversion
+
+// instrumentRe matches instrument names such as EUR_USD and CFDs such as DE30_EUR.
+var instrumentRe = regexp.MustCompile(`^[A-Z0-9]+_[A-Z]{3}$`)
+
+// ValidInstrument returns true if s is a well-formed instrument name, i.e. an upper case base
+// and quote separated by an underscore such as EUR_USD or DE30_EUR.  ValidInstrument does not
+// check that Oanda offers the instrument.
+func ValidInstrument(s string) bool {
+	return instrumentRe.MatchString(s)
+}
+
+// validateInstrument returns an error that matches ErrInvalidInstrument if s is not a
+// well-formed instrument name.
+func validateInstrument(s string) error {
+	if !ValidInstrument(s) {
+		return fmt.Errorf("%w %q", ErrInvalidInstrument, s)
+	}
+	return nil
+}
+
+// splitInstrument returns the base and quote currency of an instrument.
+func splitInstrument(instrument string) (base, quote string) {
+	parts := strings.SplitN(strings.ToUpper(instrument), "_", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// conversionInstrument returns the instrument that quotes currency cur against currency home.
+// Invert is true if home is the base currency of the instrument.
+func conversionInstrument(cur, home string, instruments map[string]InstrumentInfo) (instr string,
+	invert bool, err error) {
+
+	if _, ok := instruments[cur+"_"+home]; ok {
+		return cur + "_" + home, false, nil
+	}
+	if _, ok := instruments[home+"_"+cur]; ok {
+		return home + "_" + cur, true, nil
+	}
+	return "", false, fmt.Errorf("no instrument to convert %s to %s", cur, home)
+}
+
+// conversionRate returns the rate with which an amount in currency cur is converted to currency
+// home.
+func conversionRate(cur, home string, instruments map[string]InstrumentInfo,
+	prices Prices) (float64, error) {
+
+	if cur == home {
+		return 1, nil
+	}
+	instr, invert, err := conversionInstrument(cur, home, instruments)
+	if err != nil {
+		return 0, err
+	}
+	tick, ok := prices[instr]
+	if !ok {
+		return 0, fmt.Errorf("no price for %s", instr)
+	}
+	mid := (tick.Bid + tick.Ask) / 2
+	if invert {
+		return 1 / mid, nil
+	}
+	return mid, nil
+}
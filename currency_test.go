@@ -0,0 +1,186 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+func (ts *TestLocalSuite) TestPipValue(c *check.C) {
+	ts.c.SelectAccount(1)
+	prices := map[string]string{
+		"GBP_USD": `{"instrument": "GBP_USD", "bid": 1.6, "ask": 1.6002}`,
+		"USD_JPY": `{"instrument": "USD_JPY", "bid": 103.3, "ask": 103.32}`,
+	}
+	polls := Counter{}
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/v1/accounts/1":
+			return newResponse(http.StatusOK, `{"accountId": 0, "accountCurrency": "USD"}`), nil
+		case "/v1/instruments":
+			return newResponse(http.StatusOK, `{"instruments": [
+				{"instrument": "EUR_USD", "pip": "0.0001"},
+				{"instrument": "EUR_GBP", "pip": "0.0001"},
+				{"instrument": "GBP_USD", "pip": "0.0001"},
+				{"instrument": "USD_JPY", "pip": "0.01"},
+				{"instrument": "EUR_JPY", "pip": "0.01"}]}`), nil
+		case "/v1/prices":
+			polls.Inc()
+			instr := req.URL.Query().Get("instruments")
+			return newResponse(http.StatusOK, fmt.Sprintf(`{"prices": [%s]}`, prices[instr])), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "not found"}`), nil
+	})
+
+	near := func(a oanda.Decimal, b float64) bool { return math.Abs(a.Float64()-b) < 1e-9 }
+
+	// Same currency, which is exact.
+	v, err := ts.c.PipValue("eur_usd", 10000)
+	c.Assert(err, check.IsNil)
+	c.Assert(v, check.Equals, oanda.NewDecimal(1))
+	v, err = ts.c.PipValue("eur_usd", 3)
+	c.Assert(err, check.IsNil)
+	c.Assert(v.String(), check.Equals, "0.0003")
+	c.Assert(polls.Val(), check.Equals, 0)
+
+	// Direct conversion from GBP to USD.
+	v, err = ts.c.PipValue("EUR_GBP", 10000)
+	c.Assert(err, check.IsNil)
+	c.Assert(near(v, 1.6001), check.Equals, true)
+
+	// Inverse conversion from JPY to USD.
+	v, err = ts.c.PipValue("USD_JPY", 10000)
+	c.Assert(err, check.IsNil)
+	c.Assert(near(v, 100/103.31), check.Equals, true)
+
+	// Cross with the same quote currency reuses the cached rate.
+	v, err = ts.c.PipValue("EUR_JPY", 10000)
+	c.Assert(err, check.IsNil)
+	c.Assert(near(v, 100/103.31), check.Equals, true)
+	c.Assert(polls.Val(), check.Equals, 2)
+
+	_, err = ts.c.PipValue("XAU_XAG", 1)
+	c.Assert(err, check.NotNil)
+}
+
+func (ts *TestLocalSuite) TestConvertToHomeCurrency(c *check.C) {
+	prices := map[string]string{
+		"EUR_GBP": `{"instrument": "EUR_GBP", "bid": 0.8, "ask": 0.8002}`,
+		"EUR_USD": `{"instrument": "EUR_USD", "bid": 1.25, "ask": 1.2502}`,
+		"USD_JPY": `{"instrument": "USD_JPY", "bid": 100, "ask": 100.02}`,
+	}
+	accounts := Counter{}
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/v1/accounts/7":
+			accounts.Inc()
+			return newResponse(http.StatusOK, `{"accountId": 7, "accountCurrency": "EUR"}`), nil
+		case "/v1/instruments":
+			return newResponse(http.StatusOK, `{"instruments": [
+				{"instrument": "EUR_GBP", "pip": "0.0001"},
+				{"instrument": "EUR_USD", "pip": "0.0001"},
+				{"instrument": "USD_JPY", "pip": "0.01"},
+				{"instrument": "USD_CAD", "pip": "0.0001"}]}`), nil
+		case "/v1/prices":
+			instr := req.URL.Query().Get("instruments")
+			if instr == "USD_CAD" {
+				return newResponse(http.StatusInternalServerError,
+					`{"code": 2, "message": "unavailable"}`), nil
+			}
+			return newResponse(http.StatusOK, fmt.Sprintf(`{"prices": [%s]}`, prices[instr])), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "not found"}`), nil
+	}))
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	v, err := client.ConvertToHomeCurrency(7, 100, "EUR")
+	c.Assert(err, check.IsNil)
+	c.Assert(near(v, 100), check.Equals, true)
+
+	// Inverse conversion from GBP to EUR.
+	v, err = client.ConvertToHomeCurrency(7, 80.01, "gbp")
+	c.Assert(err, check.IsNil)
+	c.Assert(near(v, 100), check.Equals, true)
+
+	// There is no EUR_JPY pair, so JPY is converted through USD.
+	v, err = client.ConvertToHomeCurrency(7, 10001*1.2501, "JPY")
+	c.Assert(err, check.IsNil)
+	c.Assert(near(v, 100), check.Equals, true)
+
+	// The account currency is cached.
+	c.Assert(accounts.Val(), check.Equals, 1)
+
+	_, err = client.ConvertToHomeCurrency(7, 1, "CHF")
+	c.Assert(err, check.ErrorMatches, "no instrument to convert CHF to EUR")
+
+	// Errors other than a missing instrument are returned when converting through USD.
+	_, err = client.ConvertToHomeCurrency(7, 1, "CAD")
+	c.Assert(err, check.ErrorMatches, "failed to convert CAD to EUR: .*unavailable.*")
+	var apiErr *oanda.ApiError
+	c.Assert(errors.As(err, &apiErr), check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestValidInstrument(c *check.C) {
+	for _, instr := range []string{"EUR_USD", "DE30_EUR", "SPX500_USD", "XAU_XAG"} {
+		c.Check(oanda.ValidInstrument(instr), check.Equals, true, check.Commentf("%s", instr))
+	}
+	for _, instr := range []string{"", "EURUSD", "eur_usd", "EUR_USD_JPY", "EUR-USD", "_USD"} {
+		c.Check(oanda.ValidInstrument(instr), check.Equals, false, check.Commentf("%s", instr))
+	}
+
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Fatalf("unexpected request for %s", req.URL)
+		return nil, nil
+	}))
+	_, err := client.PollMidpointCandles("EURUSD", oanda.H1)
+	c.Assert(errors.Is(err, oanda.ErrInvalidInstrument), check.Equals, true)
+	c.Assert(err, check.ErrorMatches, `invalid instrument "EURUSD"`)
+	_, err = client.PollPrices("EUR_USD", "EURGBP")
+	c.Assert(errors.Is(err, oanda.ErrInvalidInstrument), check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestPipsToPrice(c *check.C) {
+	requests := Counter{}
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Inc()
+		c.Assert(req.URL.Path, check.Equals, "/v1/instruments")
+		return newResponse(http.StatusOK, `{"instruments": [
+			{"instrument": "EUR_USD", "pip": "0.0001"},
+			{"instrument": "USD_JPY", "pip": "0.01"}]}`), nil
+	}))
+	client.SelectAccount(1)
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	d, err := client.PipsToPrice("usd_jpy", 15)
+	c.Assert(err, check.IsNil)
+	c.Assert(near(d, 0.15), check.Equals, true)
+	pips, err := client.PriceToPips("EUR_USD", 0.0005)
+	c.Assert(err, check.IsNil)
+	c.Assert(near(pips, 5), check.Equals, true)
+	// The pip sizes are cached.
+	c.Assert(requests.Val(), check.Equals, 1)
+
+	_, err = client.PipsToPrice("XAU_XAG", 1)
+	c.Assert(err, check.ErrorMatches, "unknown instrument XAU_XAG")
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxDecimalExp limits the exponent of numbers that are parsed into a Decimal.
+const maxDecimalExp = 1000
+
+// Decimal is an exact decimal number, e.g. a price as it was sent by the Oanda servers.  Decimals
+// are normalized so that two Decimals are equal (==) if their values are equal, regardless of
+// trailing zeros, except that a Decimal that is decoded from JSON keeps its original
+// representation, e.g. "1.37060", so that it is encoded unchanged; use Cmp to compare those.
+// The zero value is 0.
+type Decimal struct {
+	// The value of the Decimal is coef * 10^-scale.
+	coef  int64
+	scale int
+
+	// raw is the JSON number that the Decimal was decoded from if it differs from String().
+	raw string
+}
+
+// NewDecimal returns the Decimal with the shortest representation that converts back to f.
+func NewDecimal(f float64) Decimal {
+	d, err := ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+	if err != nil {
+		// f is NaN, infinite or does not fit in a Decimal.
+		return Decimal{}
+	}
+	return d
+}
+
+// ParseDecimal parses a decimal number such as "1.37060" or "-2.5e-3".
+func ParseDecimal(s string) (Decimal, error) {
+	invalid := fmt.Errorf("invalid decimal %q", s)
+
+	mant, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil || e > maxDecimalExp || e < -maxDecimalExp {
+			return Decimal{}, invalid
+		}
+		mant, exp = s[:i], e
+	}
+	intPart, frac := mant, ""
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		intPart, frac = mant[:i], mant[i+1:]
+	}
+	if strings.Trim(frac, "0123456789") != "" {
+		return Decimal{}, invalid
+	}
+	coef, err := strconv.ParseInt(intPart+frac, 10, 64)
+	if err != nil {
+		return Decimal{}, invalid
+	}
+
+	d := Decimal{coef: coef, scale: len(frac) - exp}
+	for ; d.scale < 0; d.scale++ {
+		if d.coef > math.MaxInt64/10 || d.coef < math.MinInt64/10 {
+			return Decimal{}, invalid
+		}
+		d.coef *= 10
+	}
+	for d.scale > 0 && d.coef%10 == 0 {
+		d.coef /= 10
+		d.scale--
+	}
+	return d, nil
+}
+
+// Float64 returns the float64 that is nearest to d.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// IsZero returns true if the value of d is 0.
+func (d Decimal) IsZero() bool {
+	return d.coef == 0
+}
+
+// Cmp returns -1, 0 or +1 if d is less than, equal to or greater than e.
+func (d Decimal) Cmp(e Decimal) int {
+	return d.rat().Cmp(e.rat())
+}
+
+// mulInt returns d * n, or an error if the product does not fit in a Decimal.
+func (d Decimal) mulInt(n int) (Decimal, error) {
+	r := d.rat()
+	r.Mul(r, big.NewRat(int64(n), 1))
+	return ParseDecimal(r.FloatString(d.scale))
+}
+
+func (d Decimal) rat() *big.Rat {
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.scale)), nil)
+	return new(big.Rat).SetFrac(big.NewInt(d.coef), denom)
+}
+
+// String implements the fmt.Stringer interface.
+func (d Decimal) String() string {
+	s := strconv.FormatInt(d.coef, 10)
+	if d.scale == 0 {
+		return s
+	}
+	sign := ""
+	if d.coef < 0 {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= d.scale {
+		s = strings.Repeat("0", d.scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-d.scale] + "." + s[len(s)-d.scale:]
+}
+
+// MarshalJSON implements the json.Marshaler interface.  A Decimal that was decoded from JSON is
+// encoded in its original representation.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d.raw != "" {
+		return []byte(d.raw), nil
+	}
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.  Both numbers and strings are
+// accepted; the original representation is kept for MarshalJSON.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	if s != v.String() {
+		v.raw = s
+	}
+	*d = v
+	return nil
+}
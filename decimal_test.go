@@ -0,0 +1,69 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"encoding/json"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+func (ts *TestLocalSuite) TestDecimal(c *check.C) {
+	for s, expected := range map[string]string{
+		"1.37060":  "1.3706",
+		"103.3":    "103.3",
+		"-0.00012": "-0.00012",
+		"2.5e-3":   "0.0025",
+		"12E2":     "1200",
+		"0.000":    "0",
+	} {
+		d, err := oanda.ParseDecimal(s)
+		c.Assert(err, check.IsNil)
+		c.Assert(d.String(), check.Equals, expected)
+	}
+	for _, s := range []string{"", "-", "1.2.3", "1.-2", "abc", "1e", "99999999999999999999"} {
+		_, err := oanda.ParseDecimal(s)
+		c.Assert(err, check.ErrorMatches, "invalid decimal .*")
+	}
+
+	// Decimals with the same value compare equal regardless of their representation.
+	v := struct {
+		Price  oanda.Decimal `json:"price"`
+		Limit  oanda.Decimal `json:"limit"`
+		Bucket oanda.Decimal `json:"bucket"`
+	}{}
+	err := json.Unmarshal([]byte(`{"price": 1.37060, "limit": 1.3706, "bucket": "1.37061"}`), &v)
+	c.Assert(err, check.IsNil)
+	c.Assert(v.Price.Cmp(v.Limit), check.Equals, 0)
+	c.Assert(v.Limit, check.Equals, oanda.NewDecimal(1.3706))
+	c.Assert(v.Price.String(), check.Equals, "1.3706")
+	c.Assert(v.Price.Cmp(v.Bucket), check.Equals, -1)
+	c.Assert(v.Bucket.Cmp(v.Price), check.Equals, 1)
+	c.Assert(v.Price.Float64(), check.Equals, 1.3706)
+
+	data, err := json.Marshal(&v)
+	c.Assert(err, check.IsNil)
+	// The original representation is kept.
+	c.Assert(string(data), check.Equals, `{"price":1.37060,"limit":1.3706,"bucket":1.37061}`)
+
+	var zero oanda.Decimal
+	c.Assert(json.Unmarshal([]byte(`0.000`), &zero), check.IsNil)
+	c.Assert(zero.IsZero(), check.Equals, true)
+	data, err = json.Marshal(zero)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "0.000")
+}
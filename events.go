@@ -15,8 +15,10 @@
 package oanda
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
@@ -40,10 +42,10 @@ func (td *evtTradeDetail) Pl() float64       { return td.content.Pl }
 func (td *evtTradeDetail) Interest() float64 { return td.content.Interest }
 
 type evtHeaderContent struct {
-	TranId    int       `json:"id"`
-	AccountId int       `json:"accountId"`
-	Time      time.Time `json:"time"`
-	Type      string    `json:"type"`
+	TranId    int    `json:"id"`
+	AccountId int    `json:"accountId"`
+	Time      Time   `json:"time"`
+	Type      string `json:"type"`
 }
 
 type evtHeader struct {
@@ -55,7 +57,7 @@ type evtBody struct {
 	Side                     string              `json:"side"`
 	Units                    int                 `json:"units"`
 	Price                    float64             `json:"price"`
-	Expiry                   time.Time           `json:"expiry"`
+	Expiry                   Time                `json:"expiry"`
 	Reason                   string              `json:"reason"`
 	LowerBound               float64             `json:"lowerBound"`
 	UpperBound               float64             `json:"upperBound"`
@@ -83,7 +85,7 @@ type Event interface {
 
 func (t *evtHeader) TranId() int     { return t.content.TranId }
 func (t *evtHeader) AccountId() int  { return t.content.AccountId }
-func (t *evtHeader) Time() time.Time { return t.content.Time }
+func (t *evtHeader) Time() time.Time { return t.content.Time.Time }
 func (t *evtHeader) Type() string    { return t.content.Type }
 
 // UnmarshalJSON implements the json.Unmarshaler interface.
@@ -159,7 +161,7 @@ func (t *OrderCreateEvent) Instrument() string       { return t.body.Instrument
 func (t *OrderCreateEvent) Side() string             { return t.body.Side }
 func (t *OrderCreateEvent) Units() int               { return t.body.Units }
 func (t *OrderCreateEvent) Price() float64           { return t.body.Price }
-func (t *OrderCreateEvent) Expiry() time.Time        { return t.body.Expiry }
+func (t *OrderCreateEvent) Expiry() time.Time        { return t.body.Expiry.Time }
 func (t *OrderCreateEvent) Reason() string           { return t.body.Reason }
 func (t *OrderCreateEvent) LowerBound() float64      { return t.body.LowerBound }
 func (t *OrderCreateEvent) UpperBound() float64      { return t.body.UpperBound }
@@ -318,6 +320,42 @@ func (t *FeeEvent) Amount() float64         { return t.body.Amount }
 func (t *FeeEvent) AccountBalance() float64 { return t.body.AccountBalance }
 func (t *FeeEvent) Reason() string          { return t.body.Reason }
 
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// MARGIN_CALL_ENTER, MARGIN_CALL_EXIT, MARGIN_CLOSEOUT
+
+// MarginCallEvent represents an event of type MARGIN_CALL_ENTER or MARGIN_CALL_EXIT.  Client
+// method MarginCallHistory() also returns events of type MARGIN_CLOSEOUT as a MarginCallEvent, in
+// which case the methods that describe the liquidated trade are set.
+type MarginCallEvent struct {
+	evtHeader
+	body *evtBody
+}
+
+func (t *MarginCallEvent) Enter() bool             { return t.Type() == "MARGIN_CALL_ENTER" }
+func (t *MarginCallEvent) Exit() bool              { return t.Type() == "MARGIN_CALL_EXIT" }
+func (t *MarginCallEvent) Closeout() bool          { return t.Type() == "MARGIN_CLOSEOUT" }
+func (t *MarginCallEvent) TradeId() int            { return t.body.TradeId }
+func (t *MarginCallEvent) Instrument() string      { return t.body.Instrument }
+func (t *MarginCallEvent) Units() int              { return t.body.Units }
+func (t *MarginCallEvent) Side() string            { return t.body.Side }
+func (t *MarginCallEvent) Price() float64          { return t.body.Price }
+func (t *MarginCallEvent) Pl() float64             { return t.body.Pl }
+func (t *MarginCallEvent) Interest() float64       { return t.body.Interest }
+func (t *MarginCallEvent) AccountBalance() float64 { return t.body.AccountBalance }
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Unknown event types
+
+// UnknownEvent represents an event of a type that the package does not know.  It preserves the
+// event as it was sent by the Oanda servers.
+type UnknownEvent struct {
+	evtHeader
+	raw json.RawMessage
+}
+
+// RawMessage returns the JSON encoding of the event.
+func (t *UnknownEvent) RawMessage() json.RawMessage { return t.raw }
+
 type (
 	MinId int
 )
@@ -353,31 +391,13 @@ func (ids Ids) applyEventsArg(v url.Values) {
 // See http://developer.oanda.com/docs/v1/transactions/#get-transaction-history for further
 // information.
 func (c *Client) PollEvents(args ...EventsArg) ([]Event, error) {
-	urlStr := fmt.Sprintf("/v1/accounts/%d/transactions", c.accountId)
-	u, err := url.Parse(urlStr)
+	trans, err := c.Transactions(args...)
 	if err != nil {
 		return nil, err
 	}
-	data := u.Query()
-	for _, arg := range args {
-		arg.applyEventsArg(data)
-	}
-	u.RawQuery = data.Encode()
-	urlStr = u.String()
-
-	s := struct {
-		ApiError
-		Events []struct {
-			*evtHeaderContent
-			*evtBody
-		} `json:"transactions"`
-	}{}
-	if err = getAndDecode(c, urlStr, &s); err != nil {
-		return nil, err
-	}
 	events := []Event{}
-	for _, rawEvent := range s.Events {
-		evt, err := asEvent(rawEvent.evtHeaderContent, rawEvent.evtBody)
+	for i := range trans {
+		evt, err := trans[i].AsEvent()
 		if err != nil {
 			return nil, err
 		}
@@ -393,19 +413,54 @@ func (c *Client) PollEvent(tranId int) (Event, error) {
 		evtHeaderContent
 		evtBody
 	}{}
-	urlStr := fmt.Sprintf("/v1/accounts/%d/transactions/%d", c.accountId, tranId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/transactions/%d", c.SelectedAccount(), tranId)
 	if err := getAndDecode(c, urlStr, &evtData); err != nil {
 		return nil, err
 	}
 	return asEvent(&evtData.evtHeaderContent, &evtData.evtBody)
 }
 
+// unmarshalEvent decodes the header and body of an event.
+func unmarshalEvent(data []byte) (*evtHeaderContent, *evtBody, error) {
+	v := struct {
+		*evtHeaderContent
+		*evtBody
+	}{&evtHeaderContent{}, &evtBody{}}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, nil, err
+	}
+	return v.evtHeaderContent, v.evtBody, nil
+}
+
+// ParseEvent decodes a transaction, e.g. the RawMessage of a Transaction, into the typed event of
+// its type, such as *OrderCreateEvent for LIMIT_ORDER_CREATE, *TradeCloseEvent for
+// STOP_LOSS_FILLED or *TransferFundsEvent for TRANSFER_FUNDS.  Transactions of types that the
+// package does not know are returned as *UnknownEvent.
+func ParseEvent(raw json.RawMessage) (Event, error) {
+	header, body, err := unmarshalEvent(raw)
+	if err != nil {
+		return nil, err
+	}
+	evt, err := asEvent(header, body)
+	if _, ok := err.(unknownEventError); ok {
+		return &UnknownEvent{evtHeader{header}, append(json.RawMessage(nil), raw...)}, nil
+	}
+	return evt, err
+}
+
+// unknownEventError is returned by asEvent for events of unknown types.
+type unknownEventError string
+
+func (e unknownEventError) Error() string {
+	return "Unexpected event type " + string(e)
+}
+
 func asEvent(header *evtHeaderContent, body *evtBody) (Event, error) {
 	switch header.Type {
 	case "CREATE":
 		return &AccountCreateEvent{evtHeader{header}, body}, nil
 	case "MARKET_ORDER_CREATE":
-		return &TradeCloseEvent{evtHeader{header}, body}, nil
+		return &TradeCreateEvent{evtHeader{header}, body}, nil
 	case "LIMIT_ORDER_CREATE", "STOP_ORDER_CREATE", "MARKET_IF_TOUCHED_CREATE":
 		return &OrderCreateEvent{evtHeader{header}, body}, nil
 	case "ORDER_UPDATE":
@@ -429,23 +484,29 @@ func asEvent(header *evtHeaderContent, body *evtBody) (Event, error) {
 		return &DailyInterestEvent{evtHeader{header}, body}, nil
 	case "FEE":
 		return &FeeEvent{evtHeader{header}, body}, nil
+	case "MARGIN_CALL_ENTER", "MARGIN_CALL_EXIT":
+		return &MarginCallEvent{evtHeader{header}, body}, nil
 	}
-	return nil, fmt.Errorf("Unexpected event type %s", header.Type)
+	return nil, unknownEventError(header.Type)
 }
 
 // FullEventHistory returns a url from which a file containing the full transaction history
 // for the account can be downloaded.
 func (c *Client) FullEventHistory() (*url.URL, error) {
-	urlStr := fmt.Sprintf("/v1/accounts/%d/alltransactions", c.accountId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/alltransactions", c.SelectedAccount())
 	req, err := c.NewRequest("GET", urlStr, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	ctx, cancel := c.requestContext(req.Context())
+	defer cancel()
+	req = req.WithContext(ctx)
 	rsp, err := c.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, requestError(req, err)
 	}
+	defer rsp.Body.Close()
 	// FIXME: Return the io.ReadCloser to the data instead of the location URL.  Might want to
 	// wrap that in a streamServer wrapper so that the request can be interrupted?
 	tranUrl, err := rsp.Location()
@@ -461,7 +522,8 @@ func (c *Client) FullEventHistory() (*url.URL, error) {
 // An EventServer receives events (aka transactions) for one or more accountId(s).
 type EventServer struct {
 	// If HeartbeatFunc is not nil it is invoked once for every heartbeat message that the
-	// EventServer receives.
+	// EventServer receives, except for consecutive heartbeats with the same time, which are
+	// delivered only once.
 	HeartbeatFunc HeartbeatHandlerFunc
 	chanMap       *eventChans
 	srv           *messageServer
@@ -477,15 +539,10 @@ type (
 //
 // See http://developer.oanda.com/docs/v1/stream/#events-streaming for further information.
 func (c *Client) NewEventServer(accountId ...int) (*EventServer, error) {
-	req, err := c.NewRequest("GET", "/v1/events", nil)
+	req, err := c.newEventsStreamRequest(context.Background(), accountId)
 	if err != nil {
 		return nil, err
 	}
-	useStreamHost(req)
-
-	q := req.URL.Query()
-	optionalArgs(q).SetIntArray("accountIds", accountId)
-	req.URL.RawQuery = q.Encode()
 
 	es := &EventServer{
 		chanMap: newEventChans(accountId),
@@ -505,6 +562,22 @@ func (c *Client) NewEventServer(accountId ...int) (*EventServer, error) {
 	return es, nil
 }
 
+// newEventsStreamRequest returns a request for the event stream of accountIds.
+func (c *Client) newEventsStreamRequest(ctx context.Context, accountIds []int) (*http.Request,
+	error) {
+
+	req, err := c.NewRequestWithContext(ctx, "GET", "/v1/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	useStreamHost(req)
+
+	q := req.URL.Query()
+	optionalArgs(q).SetIntArray("accountIds", accountIds)
+	req.URL.RawQuery = q.Encode()
+	return req, nil
+}
+
 // ConnectAndDispatch starts the event server and blocks until Stop() is called.  Function handleFn
 // is called for each event that is received.
 //
@@ -544,15 +617,12 @@ func (es *EventServer) handleHeartbeats(hbC <-chan time.Time) {
 
 func (es *EventServer) handleMessages(msgC <-chan StreamMessage) {
 	for msg := range msgC {
-		rawEvent := struct {
-			*evtHeaderContent
-			*evtBody
-		}{}
-		if err := json.Unmarshal(msg.RawMessage, &rawEvent); err != nil {
+		header, body, err := unmarshalEvent(msg.RawMessage)
+		if err != nil {
 			// FIXME: log message
 			return
 		}
-		evt, err := asEvent(rawEvent.evtHeaderContent, rawEvent.evtBody)
+		evt, err := asEvent(header, body)
 		if err != nil {
 			// FIXME: Log error
 			return
@@ -616,3 +686,48 @@ func newEventChans(accountIds []int) *eventChans {
 		m: m,
 	}
 }
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// EventsStream
+
+// EventsStream streams the events of accountIds.  If no accountId is specified events for all
+// accountIds are received.  Events are sent on the returned Event channel; heartbeats are consumed
+// silently.  If the stream fails the error is sent on the error channel.  Both channels are closed
+// when the stream ends or ctx is done.
+//
+// Unlike EventServer the stream is only reopened after it ends with WithStreamReconnect.
+func (c *Client) EventsStream(ctx context.Context, accountIds []int) (<-chan Event, <-chan error) {
+	evtC := make(chan Event, c.streamBuffer)
+	errC := make(chan error, 1)
+
+	req, err := c.newEventsStreamRequest(ctx, accountIds)
+	if err != nil {
+		errC <- err
+		close(errC)
+		close(evtC)
+		return evtC, errC
+	}
+
+	ctx, done := c.workers.track(ctx)
+	drops := dropCounter[Event]{c: c, path: req.URL.Path, ch: evtC}
+	go func() {
+		defer done()
+		defer close(evtC)
+		defer close(errC)
+		c.runStream(ctx, req, errC, func(msg StreamMessage) error {
+			if msg.Type != "transaction" {
+				return nil
+			}
+			header, body, err := unmarshalEvent(msg.RawMessage)
+			if err != nil {
+				return err
+			}
+			evt, err := asEvent(header, body)
+			if err != nil {
+				return err
+			}
+			return drops.deliver(ctx, evt)
+		})
+	}()
+	return evtC, errC
+}
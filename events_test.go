@@ -15,7 +15,12 @@
 package oanda_test
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/santegoeds/oanda"
+	"io"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -133,3 +138,127 @@ func (ts *TestEventSuite) TestEventServer(c *check.C) {
 	ts.c.NewOrder(oanda.Limit, oanda.Buy, 1, "eur_usd", 0.75, expiry)
 	wg.Wait()
 }
+
+const capturedEventStream = `{"heartbeat":{"time":"2014-05-26T13:58:34Z"}}
+{"transaction":{"id":10001,"accountId":12345,"time":"2014-05-26T13:58:36Z","type":"ORDER_FILLED","instrument":"EUR_USD","side":"buy","units":100,"price":1.3634,"orderId":10000,"tradeOpened":{"id":10001,"units":100}}}
+{"heartbeat":{"time":"2014-05-26T13:58:37Z"}}
+{"transaction":{"id":10002,"accountId":12346,"time":"2014-05-26T13:58:38Z","type":"MARGIN_CALL_ENTER"}}
+`
+
+func (ts *TestLocalSuite) TestEventsStream(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/v1/events")
+		c.Check(req.URL.Query().Get("accountIds"), check.Equals, "12345,12346")
+		return newResponse(200, capturedEventStream), nil
+	})
+
+	evtC, errC := ts.c.EventsStream(context.Background(), []int{12345, 12346})
+	var events []oanda.Event
+	for evt := range evtC {
+		events = append(events, evt)
+	}
+	_, ok := <-errC
+	c.Assert(ok, check.Equals, false)
+
+	c.Assert(events, check.HasLen, 2)
+	filled, ok := events[0].(*oanda.OrderFilledEvent)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(filled.TranId(), check.Equals, 10001)
+	c.Assert(filled.AccountId(), check.Equals, 12345)
+	c.Assert(filled.OrderId(), check.Equals, 10000)
+
+	c.Assert(events[1].Type(), check.Equals, "MARGIN_CALL_ENTER")
+	c.Assert(events[1].AccountId(), check.Equals, 12346)
+}
+
+func (ts *TestLocalSuite) TestParseEvent(c *check.C) {
+	parse := func(raw string) oanda.Event {
+		evt, err := oanda.ParseEvent(json.RawMessage(raw))
+		c.Assert(err, check.IsNil)
+		return evt
+	}
+
+	evt := parse(`{"id": 1, "type": "MARKET_ORDER_CREATE", "instrument": "EUR_USD", "units": 2,
+		"tradeOpened": {"id": 1, "units": 2}}`)
+	tradeCreate, ok := evt.(*oanda.TradeCreateEvent)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(tradeCreate.TradeOpened().TradeId(), check.Equals, 1)
+
+	evt = parse(`{"id": 2, "type": "LIMIT_ORDER_CREATE", "price": 1.25}`)
+	orderCreate, ok := evt.(*oanda.OrderCreateEvent)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(orderCreate.Price(), check.Equals, 1.25)
+
+	evt = parse(`{"id": 3, "type": "ORDER_FILLED", "orderId": 2}`)
+	filled, ok := evt.(*oanda.OrderFilledEvent)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(filled.OrderId(), check.Equals, 2)
+
+	for _, typ := range []string{"STOP_LOSS_FILLED", "TAKE_PROFIT_FILLED", "TRAILING_STOP_FILLED"} {
+		evt = parse(`{"id": 4, "type": "` + typ + `", "tradeId": 1, "pl": -1.5}`)
+		closed, ok := evt.(*oanda.TradeCloseEvent)
+		c.Assert(ok, check.Equals, true, check.Commentf("%s", typ))
+		c.Assert(closed.TradeId(), check.Equals, 1)
+		c.Assert(closed.Pl(), check.Equals, -1.5)
+	}
+
+	evt = parse(`{"id": 5, "type": "TRANSFER_FUNDS", "amount": 100}`)
+	funds, ok := evt.(*oanda.TransferFundsEvent)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(funds.Amount(), check.Equals, 100.0)
+
+	evt = parse(`{"id": 6, "type": "DAILY_INTEREST", "interest": 0.1}`)
+	interest, ok := evt.(*oanda.DailyInterestEvent)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(interest.Interest(), check.Equals, 0.1)
+
+	raw := `{"id": 7, "type": "NEW_TYPE", "detail": 1}`
+	evt = parse(raw)
+	unknown, ok := evt.(*oanda.UnknownEvent)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(unknown.TranId(), check.Equals, 7)
+	c.Assert(unknown.Type(), check.Equals, "NEW_TYPE")
+	c.Assert(string(unknown.RawMessage()), check.Equals, raw)
+
+	_, err := oanda.ParseEvent(json.RawMessage(`[]`))
+	c.Assert(err, check.NotNil)
+}
+
+func (ts *TestLocalSuite) TestEventsStreamLargeEvent(c *check.C) {
+	// The event is larger than bufio.MaxScanTokenSize and arrives in two reads that split the
+	// object.
+	line := `{"transaction":{"id":10003,"accountId":12345,"time":"2014-05-26T13:58:39Z",` +
+		`"type":"MARGIN_CALL_ENTER","reason":"` + strings.Repeat("x", 100000) + `"}}` + "\n"
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			io.WriteString(pw, line[:len(line)/2])
+			io.WriteString(pw, line[len(line)/2:])
+			pw.Close()
+		}()
+		rsp := newResponse(200, "")
+		rsp.Body, rsp.ContentLength = pr, -1
+		return rsp, nil
+	})
+
+	evtC, errC := ts.c.EventsStream(context.Background(), nil)
+	var events []oanda.Event
+	for evt := range evtC {
+		events = append(events, evt)
+	}
+	c.Assert(<-errC, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].TranId(), check.Equals, 10003)
+}
+
+func (ts *TestLocalSuite) TestEventsStreamDisconnect(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(200, `{"disconnect":{"code":60,"message":"Access Token connection limit exceeded"}}`), nil
+	})
+
+	evtC, errC := ts.c.EventsStream(context.Background(), nil)
+	_, ok := <-evtC
+	c.Assert(ok, check.Equals, false)
+	err := <-errC
+	c.Assert(err, check.ErrorMatches, ".*connection limit exceeded.*")
+}
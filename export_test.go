@@ -0,0 +1,51 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"net/http"
+	"time"
+)
+
+// Exports of private functions for use in tests.
+var (
+	SessionsFromCandles = sessionsFromCandles
+	NewLedger           = newLedger
+	MarginCallEvents    = marginCallEvents
+	FormEncode          = formEncode
+	UnrealizedPl        = unrealizedPl
+	InitSandboxAccount  = initSandboxAccount
+	SandboxRetryDelay   = &sandboxRetryDelay
+	FillPollInterval    = &fillPollInterval
+	GetAndDecodeContext = getAndDecodeContext
+	RequestAndDecodeRaw = requestAndDecodeRaw
+	BackoffDelay        = backoffDelay
+	MaxDelay            = maxDelay
+	ArchivePollInterval = &archivePollInterval
+	ArchiveAttempts     = &archiveAttempts
+)
+
+// NewPollRequest returns a PollRequest that repeats req with Client c.
+func NewPollRequest(c *Client, req *http.Request) *PollRequest {
+	return &PollRequest{c: c, req: req}
+}
+
+// NextPollInterval returns the interval of PollLoop with WithBackoff(min, max, factor) after n
+// consecutive 304 responses.
+func NextPollInterval(min, max time.Duration, factor float64, interval, base time.Duration,
+	n int) time.Duration {
+
+	return (&pollBackoff{min: min, max: max, factor: factor}).next(interval, base, n)
+}
@@ -2,20 +2,26 @@ package oanda
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
-type Period int
+// Period is the time span of Client method Calendar().  It is sent to the Oanda servers in whole
+// seconds.
+type Period time.Duration
 
 const (
 	Hour  Period = Period(time.Hour)
 	Day   Period = 24 * Hour
 	Week  Period = 7 * Day
-	Month Period = 2592000
-	Year  Period = 31536000
+	Month Period = 30 * Day
+	Year  Period = 365 * Day
 )
 
+// CalendarEvent is an economic event, such as the publication of an economic indicator.
 type CalendarEvent struct {
 	Title     string    `json:"title"`
 	Timestamp time.Time `json:"timestamp"`
@@ -25,6 +31,9 @@ type CalendarEvent struct {
 	Previous  float64   `json:"previous,string"`
 	Actual    float64   `json:"actual,string"`
 	Market    float64   `json:"market,string"`
+
+	// Impact is the expected impact of the event on the market, from 1 (low) to 3 (high).
+	Impact int `json:"impact"`
 }
 
 func (ce *CalendarEvent) UnmarshalJSON(data []byte) error {
@@ -37,14 +46,16 @@ func (ce *CalendarEvent) UnmarshalJSON(data []byte) error {
 		Previous  *float64 `json:"previous,string"`
 		Actual    *float64 `json:"actual,string"`
 		Market    *float64 `json:"market,string"`
+		Impact    *int     `json:"impact"`
 	}{
 		Title:    &ce.Title,
 		Unit:     &ce.Unit,
-		Currency: &ce.Unit,
+		Currency: &ce.Currency,
 		Forecast: &ce.Forecast,
 		Previous: &ce.Previous,
 		Actual:   &ce.Actual,
 		Market:   &ce.Market,
+		Impact:   &ce.Impact,
 	}
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
@@ -53,25 +64,72 @@ func (ce *CalendarEvent) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+type calendarEvents []CalendarEvent
+
+func (ces *calendarEvents) checkReturnCode() error {
+	return nil
+}
+
+// Calendar returns the economic events that affect instrument within period from now.
 func (c *Client) Calendar(instrument string, period Period) ([]CalendarEvent, error) {
-	req, err := c.NewRequest("GET", "/labs/v1/calendar", nil)
-	if err != nil {
+	q := url.Values{}
+	q.Set("instrument", strings.ToUpper(instrument))
+	q.Set("period", strconv.Itoa(int(time.Duration(period)/time.Second)))
+
+	ces := calendarEvents{}
+	if err := getAndDecode(c, "/labs/v1/calendar?"+q.Encode(), &ces); err != nil {
 		return nil, err
 	}
-	q := req.URL.Query()
-	q.Set("instrument", instrument)
-	q.Set("period", strconv.Itoa(int(period)))
-	req.URL.RawQuery = q.Encode()
+	return ces, nil
+}
 
-	rsp, err := c.Do(req)
-	if err != nil {
-		return nil, err
+// SpreadPoint is the spread of an instrument at a point in time.
+type SpreadPoint struct {
+	Time   time.Time
+	Spread float64
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.  The Oanda servers represent a
+// SpreadPoint as an array of a unix timestamp and the spread.
+func (sp *SpreadPoint) UnmarshalJSON(data []byte) error {
+	var v []float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
 	}
-	defer rsp.Body.Close()
-	dec := json.NewDecoder(rsp.Body)
-	ces := []CalendarEvent{}
-	if err := dec.Decode(&ces); err != nil {
+	if len(v) != 2 {
+		return fmt.Errorf("invalid spread point %s", data)
+	}
+	sp.Time = time.Unix(int64(v[0]), 0)
+	sp.Spread = v[1]
+	return nil
+}
+
+// Spreads holds the minimum, average and maximum spreads of an instrument over time.
+type Spreads struct {
+	Min []SpreadPoint `json:"min"`
+	Avg []SpreadPoint `json:"avg"`
+	Max []SpreadPoint `json:"max"`
+}
+
+func (s *Spreads) checkReturnCode() error {
+	return nil
+}
+
+// Spreads returns the spreads of instrument within period until now.  If unique is true
+// consecutive points with the same spread are collapsed into one.
+func (c *Client) Spreads(instrument string, period Period, unique bool) (*Spreads, error) {
+	q := url.Values{}
+	q.Set("instrument", strings.ToUpper(instrument))
+	q.Set("period", strconv.Itoa(int(time.Duration(period)/time.Second)))
+	if unique {
+		q.Set("unique", "1")
+	} else {
+		q.Set("unique", "0")
+	}
+
+	spreads := Spreads{}
+	if err := getAndDecode(c, "/labs/v1/spreads?"+q.Encode(), &spreads); err != nil {
 		return nil, err
 	}
-	return ces, nil
+	return &spreads, nil
 }
@@ -1,7 +1,9 @@
 package oanda_test
 
 import (
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/santegoeds/oanda"
 
@@ -30,3 +32,52 @@ func (ts *TestLabsSuite) TestCalendar(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Log(events)
 }
+
+func (ts *TestLocalSuite) TestLocalCalendar(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/labs/v1/calendar")
+		c.Check(req.URL.Query().Get("instrument"), check.Equals, "EUR_USD")
+		c.Check(req.URL.Query().Get("period"), check.Equals, "604800")
+		return newResponse(http.StatusOK, `[{"title": "CPI", "timestamp": 1396897200,
+			"unit": "%", "currency": "EUR", "forecast": "0.3", "previous": "0.2",
+			"actual": "0.4", "market": "0.3", "impact": 3}]`), nil
+	})
+	events, err := ts.c.Calendar("eur_usd", oanda.Week)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.DeepEquals, []oanda.CalendarEvent{{
+		Title:     "CPI",
+		Timestamp: time.Unix(1396897200, 0),
+		Unit:      "%",
+		Currency:  "EUR",
+		Forecast:  0.3,
+		Previous:  0.2,
+		Actual:    0.4,
+		Market:    0.3,
+		Impact:    3,
+	}})
+}
+
+func (ts *TestLocalSuite) TestSpreads(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/labs/v1/spreads")
+		c.Check(req.URL.Query().Get("instrument"), check.Equals, "EUR_USD")
+		c.Check(req.URL.Query().Get("period"), check.Equals, "86400")
+		c.Check(req.URL.Query().Get("unique"), check.Equals, "1")
+		return newResponse(http.StatusOK, `{
+			"max": [[1390903200, 2.9], [1390906800, 3.1]],
+			"avg": [[1390903200, 1.5]],
+			"min": [[1390903200, 0.9]]}`), nil
+	})
+	spreads, err := ts.c.Spreads("eur_usd", oanda.Day, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(spreads.Max, check.DeepEquals, []oanda.SpreadPoint{
+		{Time: time.Unix(1390903200, 0), Spread: 2.9},
+		{Time: time.Unix(1390906800, 0), Spread: 3.1},
+	})
+	c.Assert(spreads.Avg, check.DeepEquals, []oanda.SpreadPoint{
+		{Time: time.Unix(1390903200, 0), Spread: 1.5},
+	})
+	c.Assert(spreads.Min, check.DeepEquals, []oanda.SpreadPoint{
+		{Time: time.Unix(1390903200, 0), Spread: 0.9},
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// balanceTolerance is the largest difference between a computed and a reported account balance
+// that is attributed to rounding.
+const balanceTolerance = 1e-4
+
+// balanceTypes holds the transaction types that affect the account balance.
+var balanceTypes = map[string]bool{
+	"MARKET_ORDER_CREATE":  true,
+	"ORDER_FILLED":         true,
+	"TRADE_CLOSE":          true,
+	"MIGRATE_TRADE_CLOSE":  true,
+	"TAKE_PROFIT_FILLED":   true,
+	"STOP_LOSS_FILLED":     true,
+	"TRAILING_STOP_FILLED": true,
+	"MARGIN_CLOSEOUT":      true,
+	"DAILY_INTEREST":       true,
+	"TRANSFER_FUNDS":       true,
+	"FEE":                  true,
+}
+
+// LedgerEntry represents a single balance-affecting transaction.
+type LedgerEntry struct {
+	TranId      int
+	Time        time.Time
+	Type        string
+	Description string
+
+	// Amount is the sum of the profit/loss, interest and fees of the transaction.
+	Amount float64
+
+	// Balance is the account balance after the transaction as computed from the preceding
+	// entries.  After a mismatch the computation continues from the reported balance, so that
+	// each difference is flagged once.
+	Balance float64
+
+	// ReportedBalance is the account balance after the transaction as reported by Oanda. It is
+	// only valid if HasReportedBalance is true.
+	ReportedBalance    float64
+	HasReportedBalance bool
+
+	// Mismatch is true if Balance and ReportedBalance differ by more than rounding.
+	Mismatch bool
+}
+
+// String implements the fmt.Stringer interface.
+func (le *LedgerEntry) String() string {
+	return fmt.Sprintf("LedgerEntry{TranId: %d, Type: %s, Amount: %f, Balance: %f}", le.TranId,
+		le.Type, le.Amount, le.Balance)
+}
+
+// Ledger is a running-balance view of an account's transaction history.
+type Ledger struct {
+	OpeningBalance float64
+	ClosingBalance float64
+	Entries        []LedgerEntry
+}
+
+// Mismatches returns the entries whose computed balance does not match the balance that was
+// reported by Oanda.
+func (l *Ledger) Mismatches() []LedgerEntry {
+	entries := []LedgerEntry{}
+	for _, e := range l.Entries {
+		if e.Mismatch {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Ledger returns the balance-affecting transactions of the selected account between from and
+// to, inclusive, with the resulting running balance.  A zero to includes all transactions up to
+// now.  The computed balance is verified against the account balance that Oanda reports for each
+// transaction and differences are flagged with LedgerEntry.Mismatch.
+func (c *Client) Ledger(from, to time.Time) (*Ledger, error) {
+	trans, err := c.transactionsBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return newLedger(trans), nil
+}
+
+// newLedger computes a ledger from transactions in chronological order.  The opening balance is
+// derived from the first transaction that reports an account balance and the amounts of the
+// entries before it.
+func newLedger(trans []Transaction) *Ledger {
+	l := Ledger{
+		Entries: []LedgerEntry{},
+	}
+	opened := false
+	for _, t := range trans {
+		if !balanceTypes[t.Type] {
+			continue
+		}
+		e := LedgerEntry{
+			TranId:      t.TranId,
+			Time:        t.Time.Time,
+			Type:        t.Type,
+			Description: describeTransaction(&t),
+			Amount:      t.Pl + t.Interest + t.Amount,
+		}
+		e.ReportedBalance, e.HasReportedBalance = reportedBalance(&t)
+		if !opened && e.HasReportedBalance {
+			// ClosingBalance holds the sum of the amounts of the preceding entries.
+			l.OpeningBalance = e.ReportedBalance - (l.ClosingBalance + e.Amount)
+			for i := range l.Entries {
+				l.Entries[i].Balance += l.OpeningBalance
+			}
+			l.ClosingBalance += l.OpeningBalance
+			opened = true
+		}
+		l.ClosingBalance += e.Amount
+		e.Balance = l.ClosingBalance
+		if e.HasReportedBalance {
+			e.Mismatch = math.Abs(e.Balance-e.ReportedBalance) > balanceTolerance
+			l.ClosingBalance = e.ReportedBalance
+		}
+		l.Entries = append(l.Entries, e)
+	}
+	return &l
+}
+
+// reportedBalance returns the account balance that is included in the transaction, if any.
+func reportedBalance(t *Transaction) (float64, bool) {
+	v := struct {
+		AccountBalance *float64 `json:"accountBalance"`
+	}{}
+	if len(t.RawMessage) == 0 {
+		return t.AccountBalance, t.AccountBalance != 0
+	}
+	if err := json.Unmarshal(t.RawMessage, &v); err != nil || v.AccountBalance == nil {
+		return 0, false
+	}
+	return *v.AccountBalance, true
+}
+
+func describeTransaction(t *Transaction) string {
+	if t.Instrument == "" {
+		return t.Type
+	}
+	return fmt.Sprintf("%s %d %s", t.Type, t.Units, t.Instrument)
+}
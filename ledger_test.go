@@ -0,0 +1,78 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"encoding/json"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+const ledgerFixture = `[
+	{"id": 1, "accountId": 1, "time": "2014-04-07T18:31:05Z", "type": "CREATE",
+	 "homeCurrency": "USD", "reason": "CLIENT_REQUEST"},
+	{"id": 2, "accountId": 1, "time": "2014-04-07T18:31:06Z", "type": "TRANSFER_FUNDS",
+	 "amount": 100000, "accountBalance": 100000},
+	{"id": 3, "accountId": 1, "time": "2014-04-07T18:32:00Z", "type": "LIMIT_ORDER_CREATE",
+	 "instrument": "EUR_USD", "units": 2, "side": "buy", "price": 1.1},
+	{"id": 4, "accountId": 1, "time": "2014-04-07T19:00:00Z", "type": "TRADE_CLOSE",
+	 "instrument": "EUR_USD", "units": 2, "side": "sell", "price": 1.2, "pl": 12.5,
+	 "interest": 0.25, "accountBalance": 100012.75},
+	{"id": 5, "accountId": 1, "time": "2014-04-08T00:00:00Z", "type": "DAILY_INTEREST",
+	 "interest": -0.5},
+	{"id": 6, "accountId": 1, "time": "2014-04-08T01:00:00Z", "type": "FEE",
+	 "amount": -2, "accountBalance": 100010.75},
+	{"id": 7, "accountId": 1, "time": "2014-04-08T02:00:00Z", "type": "FEE",
+	 "amount": -1, "accountBalance": 100009.75}
+]`
+
+func (ts *TestLocalSuite) TestLedger(c *check.C) {
+	trans := []oanda.Transaction{}
+	c.Assert(json.Unmarshal([]byte(ledgerFixture), &trans), check.IsNil)
+
+	l := oanda.NewLedger(trans)
+	c.Log(l.Entries)
+	c.Assert(l.Entries, check.HasLen, 5)
+	c.Assert(l.OpeningBalance, check.Equals, 0.)
+	c.Assert(l.ClosingBalance, check.Equals, 100009.75)
+	c.Assert(l.Entries[1].Amount, check.Equals, 12.75)
+	c.Assert(l.Entries[1].Description, check.Equals, "TRADE_CLOSE 2 EUR_USD")
+	c.Assert(l.Entries[2].HasReportedBalance, check.Equals, false)
+
+	// The fee was reported against a balance that does not include the daily interest.
+	mismatches := l.Mismatches()
+	c.Assert(mismatches, check.HasLen, 1)
+	c.Assert(mismatches[0].TranId, check.Equals, 6)
+	c.Assert(mismatches[0].ReportedBalance, check.Equals, 100010.75)
+	c.Assert(mismatches[0].Balance, check.Equals, 100010.25)
+	// The balance continues from the reported balance after a mismatch.
+	c.Assert(l.Entries[4].Balance, check.Equals, 100009.75)
+	c.Assert(l.Entries[4].Mismatch, check.Equals, false)
+
+	// Entries without a reported balance before the first entry with one are included in the
+	// opening balance.
+	trans = []oanda.Transaction{}
+	c.Assert(json.Unmarshal([]byte(`[
+		{"id": 1, "time": "2014-04-08T00:00:00Z", "type": "DAILY_INTEREST", "interest": 0.5},
+		{"id": 2, "time": "2014-04-08T01:00:00Z", "type": "TRADE_CLOSE", "pl": 10,
+		 "accountBalance": 1010.5}]`), &trans), check.IsNil)
+	l = oanda.NewLedger(trans)
+	c.Assert(l.OpeningBalance, check.Equals, 1000.)
+	c.Assert(l.Entries[0].Balance, check.Equals, 1000.5)
+	c.Assert(l.Entries[1].Balance, check.Equals, 1010.5)
+	c.Assert(l.Mismatches(), check.HasLen, 0)
+}
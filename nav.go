@@ -0,0 +1,298 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// NAVPoint is a sample of the net asset value of an account.
+type NAVPoint struct {
+	Time    time.Time
+	Balance float64
+
+	// UnrealizedPl is the profit or loss of the trades that were open at Time.  It is only
+	// computed if NAVHistory is called with MarkToMarket.
+	UnrealizedPl float64
+
+	// NAV is the sum of Balance and UnrealizedPl.
+	NAV float64
+}
+
+// String implements the fmt.Stringer interface.
+func (np *NAVPoint) String() string {
+	return fmt.Sprintf("NAVPoint{Time: %s, Balance: %f, NAV: %f}", np.Time.Format(time.RFC3339),
+		np.Balance, np.NAV)
+}
+
+type navHistoryArgs struct {
+	markToMarket bool
+}
+
+// NAVHistoryArg is an optional argument for Client method NAVHistory().
+type NAVHistoryArg interface {
+	applyNAVHistoryArg(*navHistoryArgs)
+}
+
+// MarkToMarket is an optional argument for Client method NAVHistory().  If true the trades that
+// are open at each sample are valued at the historic midpoint prices of their instruments.  This
+// requires the complete transaction history of the account and candles for every instrument that
+// was traded, which makes NAVHistory considerably slower.
+type MarkToMarket bool
+
+func (mtm MarkToMarket) applyNAVHistoryArg(a *navHistoryArgs) {
+	a.markToMarket = bool(mtm)
+}
+
+// NAVHistory samples the account balance of the selected account every step from from up to and
+// including to.  A zero to samples up to now.  The balance is reconstructed from the transaction
+// history as by Client method Ledger().  Open trades are only valued with optional argument
+// MarkToMarket; otherwise NAV equals Balance.
+func (c *Client) NAVHistory(from, to time.Time, step time.Duration,
+	args ...NAVHistoryArg) ([]NAVPoint, error) {
+
+	if step <= 0 {
+		return nil, errors.New("step must be positive")
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	a := navHistoryArgs{}
+	for _, arg := range args {
+		arg.applyNAVHistoryArg(&a)
+	}
+
+	start := from
+	if a.markToMarket {
+		// Trades that were opened before from may still be open.
+		start = time.Time{}
+	}
+	trans, err := c.transactionsBetween(start, to)
+	if err != nil {
+		return nil, err
+	}
+	if !a.markToMarket {
+		return navHistory(trans, from, to, step, nil)
+	}
+	m, err := c.newMarker(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return navHistory(trans, from, to, step, m.unrealizedPl)
+}
+
+// navTrade is a trade that is reconstructed from the transaction history.
+type navTrade struct {
+	Instrument string
+	Side       string
+	Units      int
+	Price      float64
+}
+
+// A markFunc returns the unrealized profit or loss of trades at time t.
+type markFunc func(trades map[int]*navTrade, t time.Time) (float64, error)
+
+// navHistory samples the running balance of chronological transactions trans every step from
+// from up to and including to.  If mark is not nil the trades that are open at each sample are
+// valued with mark.
+func navHistory(trans []Transaction, from, to time.Time, step time.Duration,
+	mark markFunc) ([]NAVPoint, error) {
+
+	ledger := newLedger(trans)
+	entries := ledger.Entries
+	balance := ledger.OpeningBalance
+	trades := make(map[int]*navTrade)
+
+	points := []NAVPoint{}
+	for t := from; !t.After(to); t = t.Add(step) {
+		for len(entries) > 0 && !entries[0].Time.After(t) {
+			balance = entries[0].Balance
+			entries = entries[1:]
+		}
+		for len(trans) > 0 && !trans[0].Time.After(t) {
+			if mark != nil {
+				if err := applyTradeTransaction(trades, &trans[0]); err != nil {
+					return nil, err
+				}
+			}
+			trans = trans[1:]
+		}
+		p := NAVPoint{Time: t, Balance: balance, NAV: balance}
+		if mark != nil && len(trades) > 0 {
+			upl, err := mark(trades, t)
+			if err != nil {
+				return nil, err
+			}
+			p.UnrealizedPl = upl
+			p.NAV += upl
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// closeTypes holds the transaction types that close the trade with the transaction's tradeId.
+var closeTypes = map[string]bool{
+	"TRADE_CLOSE":          true,
+	"MIGRATE_TRADE_CLOSE":  true,
+	"TAKE_PROFIT_FILLED":   true,
+	"STOP_LOSS_FILLED":     true,
+	"TRAILING_STOP_FILLED": true,
+	"MARGIN_CLOSEOUT":      true,
+}
+
+// applyTradeTransaction updates the open trades with the trades that transaction t opens,
+// reduces or closes.
+func applyTradeTransaction(trades map[int]*navTrade, t *Transaction) error {
+	if len(t.RawMessage) == 0 {
+		return nil
+	}
+	_, body, err := unmarshalEvent(t.RawMessage)
+	if err != nil {
+		return err
+	}
+	v := struct {
+		TradesClosed []evtTradeDetailData `json:"tradesClosed"`
+	}{}
+	if err = json.Unmarshal(t.RawMessage, &v); err != nil {
+		return err
+	}
+
+	reduce := func(tradeId, units int) {
+		if tr, ok := trades[tradeId]; ok {
+			tr.Units -= units
+			if units == 0 || tr.Units <= 0 {
+				delete(trades, tradeId)
+			}
+		}
+	}
+	for _, closed := range v.TradesClosed {
+		delete(trades, closed.TradeId)
+	}
+	if body.TradeReduced != nil {
+		reduce(body.TradeReduced.TradeId, body.TradeReduced.Units)
+	}
+	if body.TradeOpened != nil {
+		trades[body.TradeOpened.TradeId] = &navTrade{
+			Instrument: body.Instrument,
+			Side:       body.Side,
+			Units:      body.TradeOpened.Units,
+			Price:      body.Price,
+		}
+	}
+	if closeTypes[t.Type] && body.TradeId != 0 {
+		reduce(body.TradeId, body.Units)
+	}
+	return nil
+}
+
+// marker values open trades at historic midpoint prices.
+type marker struct {
+	c           *Client
+	from, to    time.Time
+	granularity Granularity
+	home        string
+	instruments map[string]InstrumentInfo
+	candles     map[string][]midpoint
+}
+
+type midpoint struct {
+	time  time.Time
+	price float64
+}
+
+func (c *Client) newMarker(from, to time.Time) (*marker, error) {
+	home, instruments, err := c.conversionInfo()
+	if err != nil {
+		return nil, err
+	}
+	m := marker{
+		c:           c,
+		from:        from,
+		to:          to,
+		granularity: D,
+		home:        home,
+		instruments: instruments,
+		candles:     make(map[string][]midpoint),
+	}
+	for _, v := range granularities {
+		if to.Sub(from)/v.d <= maxCandles {
+			m.granularity = v.g
+			break
+		}
+	}
+	return &m, nil
+}
+
+// unrealizedPl implements markFunc.
+func (m *marker) unrealizedPl(trades map[int]*navTrade, t time.Time) (float64, error) {
+	upl := 0.0
+	for _, tr := range trades {
+		price, err := m.price(tr.Instrument, t)
+		if err != nil {
+			return 0, err
+		}
+		_, quote := splitInstrument(tr.Instrument)
+		rate := 1.0
+		if quote != m.home {
+			instr, invert, err := conversionInstrument(quote, m.home, m.instruments)
+			if err != nil {
+				return 0, err
+			}
+			if rate, err = m.price(instr, t); err != nil {
+				return 0, err
+			}
+			if invert {
+				rate = 1 / rate
+			}
+		}
+		diff := price - tr.Price
+		if tr.Side == string(Sell) {
+			diff = -diff
+		}
+		upl += diff * float64(tr.Units) * rate
+	}
+	return upl, nil
+}
+
+// price returns the opening midpoint price of the candle of instrument that includes time t.
+func (m *marker) price(instrument string, t time.Time) (float64, error) {
+	candles, ok := m.candles[instrument]
+	if !ok {
+		rsp, err := m.c.PollMidpointCandles(instrument, m.granularity, StartTime(m.from),
+			EndTime(m.to), IncludeFirst(true))
+		if err != nil {
+			return 0, err
+		}
+		candles = make([]midpoint, len(rsp.Candles))
+		for i, candle := range rsp.Candles {
+			candles[i] = midpoint{candle.Time.Time, candle.OpenMid}
+		}
+		m.candles[instrument] = candles
+	}
+	if len(candles) == 0 {
+		return 0, fmt.Errorf("no price for %s", instrument)
+	}
+	// Samples before the first candle, e.g. on a weekend, use the first available price.
+	i := sort.Search(len(candles), func(i int) bool { return candles[i].time.After(t) })
+	if i == 0 {
+		i = 1
+	}
+	return candles[i-1].price, nil
+}
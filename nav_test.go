@@ -0,0 +1,83 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+const navTransactions = `{"transactions": [
+	{"id": 4, "time": "2014-04-07T03:30:00Z", "type": "TRADE_CLOSE", "instrument": "EUR_USD",
+	 "side": "buy", "units": 100, "price": 1.32, "tradeId": 3, "pl": 2, "accountBalance": 1002},
+	{"id": 3, "time": "2014-04-07T01:30:00Z", "type": "MARKET_ORDER_CREATE",
+	 "instrument": "EUR_USD", "side": "buy", "units": 100, "price": 1.3,
+	 "tradeOpened": {"id": 3, "units": 100}, "accountBalance": 1000},
+	{"id": 2, "time": "2014-04-07T00:30:00Z", "type": "TRANSFER_FUNDS", "amount": 1000,
+	 "accountBalance": 1000},
+	{"id": 1, "time": "2014-04-07T00:00:00Z", "type": "CREATE", "homeCurrency": "USD"}]}`
+
+func (ts *TestLocalSuite) TestNAVHistory(c *check.C) {
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/v1/accounts/1/transactions":
+			return newResponse(http.StatusOK, navTransactions), nil
+		case "/v1/accounts/1":
+			return newResponse(http.StatusOK, `{"accountId": 0, "accountCurrency": "USD"}`), nil
+		case "/v1/instruments":
+			return newResponse(http.StatusOK, `{"instruments": [{"instrument": "EUR_USD"}]}`), nil
+		case "/v1/candles":
+			return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "candles": [
+				{"time": "2014-04-07T00:00:00Z", "openMid": 1.29},
+				{"time": "2014-04-07T02:00:00Z", "openMid": 1.31}]}`), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "not found"}`), nil
+	})
+
+	from := time.Date(2014, 4, 7, 0, 0, 0, 0, time.UTC)
+	to := from.Add(4 * time.Hour)
+
+	points, err := ts.c.NAVHistory(from, to, time.Hour)
+	c.Assert(err, check.IsNil)
+	c.Assert(points, check.HasLen, 5)
+	balances := []float64{0, 1000, 1000, 1000, 1002}
+	for i, p := range points {
+		c.Check(p.Time.Equal(from.Add(time.Duration(i)*time.Hour)), check.Equals, true)
+		c.Check(p.Balance, check.Equals, balances[i])
+		c.Check(p.NAV, check.Equals, p.Balance)
+	}
+
+	points, err = ts.c.NAVHistory(from, to, time.Hour, oanda.MarkToMarket(true))
+	c.Assert(err, check.IsNil)
+	c.Assert(points, check.HasLen, 5)
+	upls := []float64{0, 0, 1, 1, 0}
+	for i, p := range points {
+		if i > 0 {
+			c.Check(p.Time.After(points[i-1].Time), check.Equals, true)
+		}
+		c.Check(p.Balance, check.Equals, balances[i])
+		c.Check(math.Abs(p.UnrealizedPl-upls[i]) < 1e-9, check.Equals, true)
+		c.Check(p.NAV, check.Equals, p.Balance+p.UnrealizedPl)
+	}
+
+	_, err = ts.c.NAVHistory(from, to, 0)
+	c.Assert(err, check.NotNil)
+}
@@ -15,6 +15,9 @@
 package oanda
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
@@ -34,23 +37,38 @@ const (
 	MarketIfTouched OrderType = "marketIfTouched"
 	Limit           OrderType = "limit"
 	Stop            OrderType = "stop"
+
+	// Market orders are submitted with NewTrade, or with CreateOrders as part of a batch.
+	Market OrderType = "market"
 )
 
 type Order struct {
-	OrderId        int       `json:"id"`
-	Units          int       `json:"units"`
-	Instrument     string    `json:"instrument"`
-	Side           string    `json:"side"`
-	Price          float64   `json:"price"`
-	Time           time.Time `json:"time"`
-	StopLoss       float64   `json:"stopLoss"`
-	TakeProfit     float64   `json:"takeProfit"`
-	TrailingStop   float64   `json:"trailingStop"`
-	TrailingAmount float64   `json:"trailingAmount"`
-	OrderType      string    `json:"type"`
-	Expiry         time.Time `json:"expiry"`
-	UpperBound     float64   `json:"upperBound"`
-	LowerBound     float64   `json:"lowerBound"`
+	OrderId        int     `json:"id"`
+	Units          int     `json:"units"`
+	Instrument     string  `json:"instrument"`
+	Side           string  `json:"side"`
+	Price          Decimal `json:"price"`
+	Time           Time    `json:"time"`
+	StopLoss       Decimal `json:"stopLoss"`
+	TakeProfit     Decimal `json:"takeProfit"`
+	TrailingStop   Decimal `json:"trailingStop"`
+	TrailingAmount Decimal `json:"trailingAmount"`
+	OrderType      string  `json:"type"`
+	Expiry         Time    `json:"expiry"`
+	UpperBound     Decimal `json:"upperBound"`
+	LowerBound     Decimal `json:"lowerBound"`
+
+	// PriceString holds Price exactly as it was represented by the Oanda servers.
+	PriceString string `json:"-"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (o *Order) UnmarshalJSON(data []byte) error {
+	type order Order
+	if err := json.Unmarshal(data, (*order)(o)); err != nil {
+		return err
+	}
+	return numberText(data, map[string]*string{"price": &o.PriceString})
 }
 
 // String implements the fmt.Stringer interface.
@@ -60,11 +78,13 @@ func (o *Order) String() string {
 }
 
 // LowerBound is an optional argument for Client methods NewOrder(), ModifyOrder() and
-// NewTrade().
+// NewTrade() that sets the minimum execution price of a market or MarketIfTouched order.  It
+// limits the slippage of a sell.
 type LowerBound float64
 
 // UpperBound is an optional argument for Client methods NewOrder(), ModifyOrder() and
-// NewTrade().
+// NewTrade() that sets the maximum execution price of a market or MarketIfTouched order.  It
+// limits the slippage of a buy.
 type UpperBound float64
 
 // StopLoss is an optional argument for Client methods  NewOrder(), ModifyOrder(), NewTrade()
@@ -79,74 +99,285 @@ type TakeProfit float64
 // and ModifyTrade().
 type TrailingStop float64
 
+// Tag is an optional argument for Client methods NewOrder() and NewTrade() that attaches a
+// client-generated identifier, e.g. to recognize an order that was submitted twice.  The v1 API
+// does not deduplicate orders by tag; it is sent as a custom form value on a best-effort basis.
+type Tag string
+
+// SignedUnits is an optional argument for Client methods NewOrder() and NewTrade() that holds both
+// the side and the units of the order: a positive value buys and a negative value sells.  It
+// requires an empty side and zero units.
+type SignedUnits int64
+
+// TimeInForce determines how long an order remains in effect. It is an optional argument for
+// Client method NewOrder().
+type TimeInForce string
+
+const (
+	GoodTilCancelled  TimeInForce = "GTC"
+	GoodForDay        TimeInForce = "GFD"
+	GoodTilDate       TimeInForce = "GTD"
+	FillOrKill        TimeInForce = "FOK"
+	ImmediateOrCancel TimeInForce = "IOC"
+)
+
+// WithTimeInForce returns an optional argument for Client method NewOrder() that sets the
+// time-in-force of the order. An order with time-in-force GoodTilDate requires an expiry and
+// orders with any other time-in-force must not have one.
+func WithTimeInForce(tif TimeInForce) NewOrderArg {
+	return tif
+}
+
+// orderForm holds the form values of a request to create an order or a trade.
+type orderForm struct {
+	Type         string    `oanda:"type"`
+	Side         string    `oanda:"side"`
+	Units        int       `oanda:"units"`
+	Instrument   string    `oanda:"instrument"`
+	Price        float64   `oanda:"price,omitempty"`
+	Expiry       time.Time `oanda:"expiry,omitempty"`
+	TimeInForce  string    `oanda:"timeInForce,omitempty"`
+	LowerBound   float64   `oanda:"lowerBound,omitempty"`
+	UpperBound   float64   `oanda:"upperBound,omitempty"`
+	StopLoss     float64   `oanda:"stopLoss,omitempty"`
+	TakeProfit   float64   `oanda:"takeProfit,omitempty"`
+	TrailingStop float64   `oanda:"trailingStop,omitempty"`
+	Tag          string    `oanda:"tag,omitempty"`
+
+	signedUnits *int64
+}
+
+// hasPrice returns true if any of prices is set and not zero.
+func hasPrice(prices ...*float64) bool {
+	for _, p := range prices {
+		if p != nil && *p != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// roundPrices rounds prices of an order on instrument to the precision of the instrument, see
+// Client method FormatPrice().  The instrument information is loaded if it is not cached.  Prices
+// of an instrument that the account does not list are not rounded; the Oanda servers reject the
+// order instead.
+func (c *Client) roundPrices(instrument string, prices ...*float64) error {
+	if !hasPrice(prices...) {
+		return nil
+	}
+	instruments, err := c.cachedInstruments()
+	if err != nil {
+		return err
+	}
+	precision := instruments[strings.ToUpper(instrument)].Precision
+	if precision <= 0 {
+		return nil
+	}
+	for _, p := range prices {
+		if p != nil && *p != 0 {
+			*p, _ = strconv.ParseFloat(formatPrice(*p, precision), 64)
+		}
+	}
+	return nil
+}
+
+// roundForm rounds the prices of f, see roundPrices.
+func (c *Client) roundForm(f *orderForm) error {
+	return c.roundPrices(f.Instrument, &f.Price, &f.LowerBound, &f.UpperBound, &f.StopLoss,
+		&f.TakeProfit)
+}
+
 // NewOrderArg represents an optional argument for method NewOrder. Types that implement the
-// interface are LowerBound, UpperBound, StopLoss, TakeProfit and TrailingStop.
+// interface are LowerBound, UpperBound, StopLoss, TakeProfit, TrailingStop, TimeInForce, Tag and
+// SignedUnits.
 type NewOrderArg interface {
-	applyNewOrderArg(url.Values)
+	applyNewOrderArg(*orderForm)
+}
+
+func (lb LowerBound) applyNewOrderArg(f *orderForm) {
+	f.LowerBound = float64(lb)
+}
+
+func (ub UpperBound) applyNewOrderArg(f *orderForm) {
+	f.UpperBound = float64(ub)
+}
+
+func (sl StopLoss) applyNewOrderArg(f *orderForm) {
+	f.StopLoss = float64(sl)
+}
+
+func (tp TakeProfit) applyNewOrderArg(f *orderForm) {
+	f.TakeProfit = float64(tp)
+}
+
+func (ts TrailingStop) applyNewOrderArg(f *orderForm) {
+	f.TrailingStop = float64(ts)
 }
 
-func (lb LowerBound) applyNewOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("lowerBound", float64(lb))
+func (tif TimeInForce) applyNewOrderArg(f *orderForm) {
+	f.TimeInForce = string(tif)
 }
 
-func (ub UpperBound) applyNewOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("upperBound", float64(ub))
+func (t Tag) applyNewOrderArg(f *orderForm) {
+	f.Tag = string(t)
 }
 
-func (sl StopLoss) applyNewOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("stopLoss", float64(sl))
+func (su SignedUnits) applyNewOrderArg(f *orderForm) {
+	n := int64(su)
+	f.signedUnits = &n
 }
 
-func (tp TakeProfit) applyNewOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("takeProfit", float64(tp))
+// applySignedUnits sets the side and units of f from its SignedUnits, if any.
+func applySignedUnits(f *orderForm) error {
+	if f.signedUnits == nil {
+		return nil
+	}
+	n := *f.signedUnits
+	if f.Side != "" || f.Units != 0 {
+		return errors.New("SignedUnits cannot be combined with a side or units")
+	}
+	side := Buy
+	if n < 0 {
+		side, n = Sell, -n
+	}
+	if n <= 0 || int64(int(n)) != n {
+		return fmt.Errorf("invalid SignedUnits %d", *f.signedUnits)
+	}
+	f.Side, f.Units = string(side), int(n)
+	return nil
 }
 
-func (ts TrailingStop) applyNewOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("trailingStop", float64(ts))
+// checkTimeInForce verifies that the time-in-force and expiry of a new order are consistent.
+func checkTimeInForce(f *orderForm) error {
+	hasExpiry := !f.Expiry.IsZero()
+	switch TimeInForce(f.TimeInForce) {
+	case "":
+		return nil
+	case GoodTilDate:
+		if !hasExpiry {
+			return errors.New("time-in-force GTD requires an expiry")
+		}
+	case GoodTilCancelled, GoodForDay, FillOrKill, ImmediateOrCancel:
+		if hasExpiry {
+			return fmt.Errorf("time-in-force %s does not accept an expiry", f.TimeInForce)
+		}
+	default:
+		return fmt.Errorf("invalid time-in-force %s", f.TimeInForce)
+	}
+	return nil
+}
+
+// checkOrder verifies that a new order has a valid type, a price and, unless a time-in-force is
+// specified, an expiry.
+func checkOrder(f *orderForm) error {
+	switch OrderType(f.Type) {
+	case Limit, Stop, MarketIfTouched:
+	default:
+		return fmt.Errorf("invalid order type %s; use NewTrade for market orders", f.Type)
+	}
+	if f.Price <= 0 {
+		return fmt.Errorf("orders of type %s require a price", f.Type)
+	}
+	if f.Expiry.IsZero() && f.TimeInForce == "" {
+		return fmt.Errorf("orders of type %s require an expiry", f.Type)
+	}
+	return nil
 }
 
-// NewOrder creates and submits a new order.
+// checkBounds verifies that only market and MarketIfTouched orders have price bounds, that the
+// upper bound is not less than the lower bound and that the bound that limits the slippage of a
+// MarketIfTouched order is not on the wrong side of its price.
+func checkBounds(f *orderForm) error {
+	if f.LowerBound == 0 && f.UpperBound == 0 {
+		return nil
+	}
+	if f.Type != "market" && OrderType(f.Type) != MarketIfTouched {
+		return fmt.Errorf("orders of type %s do not accept price bounds", f.Type)
+	}
+	if f.LowerBound != 0 && f.UpperBound != 0 && f.UpperBound < f.LowerBound {
+		return fmt.Errorf("upperBound %v is less than lowerBound %v", f.UpperBound, f.LowerBound)
+	}
+	if f.Price == 0 {
+		return nil
+	}
+	if TradeSide(f.Side) == Buy && f.UpperBound != 0 && f.UpperBound < f.Price {
+		return fmt.Errorf("upperBound %v of a buy is below the price %v", f.UpperBound, f.Price)
+	}
+	if TradeSide(f.Side) == Sell && f.LowerBound != 0 && f.LowerBound > f.Price {
+		return fmt.Errorf("lowerBound %v of a sell is above the price %v", f.LowerBound, f.Price)
+	}
+	return nil
+}
+
+// NewOrder creates and submits a new order of type Limit, Stop or MarketIfTouched.  Market
+// orders are rejected; submit them with NewTrade, or with CreateOrders as part of a batch.  Only MarketIfTouched orders accept a LowerBound and UpperBound.
+// An expiry is required unless a time-in-force is specified.  The expiry is sent in the date
+// format of the Client, see WithDateFormat; it is omitted from the request if it is the zero
+// time, which is required for orders with a time-in-force other than GoodTilDate.
 func (c *Client) NewOrder(orderType OrderType, side TradeSide, units int, instrument string,
 	price float64, expiry time.Time, args ...NewOrderArg) (*Order, error) {
 
 	instrument = strings.ToUpper(instrument)
+	if err := validateInstrument(instrument); err != nil {
+		return nil, err
+	}
 
-	o := Order{
+	form := orderForm{
+		Type:       string(orderType),
 		Side:       string(side),
 		Units:      units,
 		Instrument: instrument,
 		Price:      price,
-		OrderType:  string(orderType),
 		Expiry:     expiry,
 	}
-	data := url.Values{
-		"type":       {string(orderType)},
-		"side":       {string(side)},
-		"units":      {strconv.Itoa(units)},
-		"instrument": {instrument},
-		"price":      {strconv.FormatFloat(price, 'f', -1, 64)},
-		"expiry":     {expiry.UTC().Format(time.RFC3339)},
-	}
 	for _, arg := range args {
-		arg.applyNewOrderArg(data)
+		arg.applyNewOrderArg(&form)
+	}
+	if err := applySignedUnits(&form); err != nil {
+		return nil, err
+	}
+	if err := checkOrder(&form); err != nil {
+		return nil, err
+	}
+	if err := checkBounds(&form); err != nil {
+		return nil, err
+	}
+	if err := checkTimeInForce(&form); err != nil {
+		return nil, err
+	}
+	if err := c.roundForm(&form); err != nil {
+		return nil, err
+	}
+	data, err := formEncodeDate(&form, c.dateFormat())
+	if err != nil {
+		return nil, err
 	}
 
+	o := Order{
+		Side:       form.Side,
+		Units:      form.Units,
+		Instrument: instrument,
+		Price:      NewDecimal(price),
+		OrderType:  string(orderType),
+		Expiry:     Time{expiry},
+	}
 	rspData := struct {
 		ApiError
-		Instrument  string    `json:"instrument"`
-		Time        time.Time `json:"time"`
-		Price       float64   `json:"price"`
-		OrderOpened *Order    `json:"orderOpened"`
+		Instrument  string      `json:"instrument"`
+		Time        Time        `json:"time"`
+		Price       json.Number `json:"price"`
+		OrderOpened *Order      `json:"orderOpened"`
 	}{
 		OrderOpened: &o,
 	}
-	urlStr := fmt.Sprintf("/v1/accounts/%d/orders", c.accountId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/orders", c.SelectedAccount())
 	if err := requestAndDecode(c, "POST", urlStr, data, &rspData); err != nil {
 		return nil, err
 	}
 	o.Instrument = rspData.Instrument
 	o.Time = rspData.Time
-	o.Price = rspData.Price
+	_ = o.Price.UnmarshalJSON([]byte(rspData.Price))
+	o.PriceString = rspData.Price.String()
 	return &o, nil
 }
 
@@ -156,7 +387,7 @@ func (c *Client) Order(orderId int) (*Order, error) {
 		ApiError
 		Order
 	}{}
-	urlStr := fmt.Sprintf("/v1/accounts/%d/orders/%d", c.accountId, orderId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/orders/%d", c.SelectedAccount(), orderId)
 	if err := getAndDecode(c, urlStr, &o); err != nil {
 		return nil, err
 	}
@@ -170,11 +401,13 @@ type MaxId int
 // BidAskCandles() and Trades().
 type Count int
 
-// Instrument is an optional argument for Client methods Events(), Orders() and Trades().
+// Instrument is an optional argument for Client methods Events(), Orders() and Trades().  For
+// ModifyOrder() and ModifyTrade() it is the instrument of the order or trade, to which prices are
+// rounded; it is not sent.
 type Instrument string
 
 // OrderArgs represents an optional argument for method Orders. Types that implement the interface
-// are MaxId, Count and Instrument.
+// are MaxId, Count, Instrument and Ids.
 type OrdersArg interface {
 	applyOrdersArg(url.Values)
 }
@@ -191,10 +424,15 @@ func (in Instrument) applyOrdersArg(v url.Values) {
 	v.Set("instrument", string(in))
 }
 
-// Orders returns an array with all orders that match the optional arguments (if any). Supported
-// OrdersArg are MaxId, Count and Instrument.
+func (ids Ids) applyOrdersArg(v url.Values) {
+	optionalArgs(v).SetIntArray("ids", []int(ids))
+}
+
+// Orders returns an array with all pending orders that match the optional arguments (if any).
+// Supported OrdersArg are MaxId, Count, Instrument and Ids.  The array is empty if there are no
+// matching orders.
 func (c *Client) Orders(args ...OrdersArg) ([]Order, error) {
-	u, err := url.Parse(fmt.Sprintf("/v1/accounts/%d/orders", c.accountId))
+	u, err := url.Parse(fmt.Sprintf("/v1/accounts/%d/orders", c.SelectedAccount()))
 	if err != nil {
 		return nil, err
 	}
@@ -212,10 +450,13 @@ func (c *Client) Orders(args ...OrdersArg) ([]Order, error) {
 	if err := getAndDecode(c, u.String(), &rsp); err != nil {
 		return nil, err
 	}
+	if rsp.Orders == nil {
+		rsp.Orders = []Order{}
+	}
 	return rsp.Orders, nil
 }
 
-// Units is an optional argument for Client method ModifyOrder().
+// Units is an optional argument for Client methods ModifyOrder() and CloseTrade().
 type Units int
 
 // Expiry is an optional argument for Client method ModifyOrder().
@@ -224,76 +465,119 @@ type Expiry time.Time
 // Price is an optional argument for Client method ModifyOrder().
 type Price float64
 
+// modifyOrderForm holds the form values of a request to modify an order.  Fields that are nil
+// are left unchanged.
+type modifyOrderForm struct {
+	Units        *int       `oanda:"units"`
+	Price        *float64   `oanda:"price"`
+	Expiry       *time.Time `oanda:"expiry"`
+	LowerBound   *float64   `oanda:"lowerBound"`
+	UpperBound   *float64   `oanda:"upperBound"`
+	StopLoss     *float64   `oanda:"stopLoss"`
+	TakeProfit   *float64   `oanda:"takeProfit"`
+	TrailingStop *float64   `oanda:"trailingStop"`
+
+	instrument string
+}
+
 // ModifyOrderArg represents an opional argument for method ModifyOrder. Types that implement
-// the interface are Units, Price, Expiry, LowerBound, UpperBound, StopLoss, TakeProfit and
-// TrailingStop.
+// the interface are Units, Price, Expiry, LowerBound, UpperBound, StopLoss, TakeProfit,
+// TrailingStop and Instrument.
 type ModifyOrderArg interface {
-	applyModifyOrderArg(url.Values)
+	applyModifyOrderArg(*modifyOrderForm)
 }
 
-func (u Units) applyModifyOrderArg(v url.Values) {
-	optionalArgs(v).SetInt("units", int(u))
+func (u Units) applyModifyOrderArg(f *modifyOrderForm) {
+	n := int(u)
+	f.Units = &n
 }
 
-func (p Price) applyModifyOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("price", float64(p))
+func (p Price) applyModifyOrderArg(f *modifyOrderForm) {
+	v := float64(p)
+	f.Price = &v
 }
 
-func (e Expiry) applyModifyOrderArg(v url.Values) {
-	optionalArgs(v).SetTime("expiry", time.Time(e))
+func (e Expiry) applyModifyOrderArg(f *modifyOrderForm) {
+	t := time.Time(e)
+	f.Expiry = &t
 }
 
-func (lb LowerBound) applyModifyOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("lowerBound", float64(lb))
+func (lb LowerBound) applyModifyOrderArg(f *modifyOrderForm) {
+	v := float64(lb)
+	f.LowerBound = &v
 }
 
-func (ub UpperBound) applyModifyOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("upperBound", float64(ub))
+func (ub UpperBound) applyModifyOrderArg(f *modifyOrderForm) {
+	v := float64(ub)
+	f.UpperBound = &v
 }
 
-func (sl StopLoss) applyModifyOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("stopLoss", float64(sl))
+func (sl StopLoss) applyModifyOrderArg(f *modifyOrderForm) {
+	v := float64(sl)
+	f.StopLoss = &v
 }
 
-func (tp TakeProfit) applyModifyOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("takeProfit", float64(tp))
+func (tp TakeProfit) applyModifyOrderArg(f *modifyOrderForm) {
+	v := float64(tp)
+	f.TakeProfit = &v
 }
 
-func (ts TrailingStop) applyModifyOrderArg(v url.Values) {
-	optionalArgs(v).SetFloat("trailingStop", float64(ts))
+func (ts TrailingStop) applyModifyOrderArg(f *modifyOrderForm) {
+	v := float64(ts)
+	f.TrailingStop = &v
+}
+
+func (in Instrument) applyModifyOrderArg(f *modifyOrderForm) {
+	f.instrument = string(in)
 }
 
 // ModifyOrder updates an open order. Supported arguments are Units(), Price(), Expiry(),
-// UpperBound(), StopLoss(), TakeProfit() and TrailingStop().
+// LowerBound(), UpperBound(), StopLoss(), TakeProfit() and TrailingStop().  Only the specified
+// fields are sent, so that e.g. StopLoss(0) removes the stop loss of the order.  If the
+// instrument of the order is passed with Instrument() prices are rounded to its precision.
 func (c *Client) ModifyOrder(orderId int, arg ModifyOrderArg, args ...ModifyOrderArg) (*Order, error) {
-	data := url.Values{}
-	arg.applyModifyOrderArg(data)
+	form := modifyOrderForm{}
+	arg.applyModifyOrderArg(&form)
 	for _, arg = range args {
-		arg.applyModifyOrderArg(data)
+		arg.applyModifyOrderArg(&form)
+	}
+	if form.instrument != "" {
+		err := c.roundPrices(form.instrument, form.Price, form.LowerBound, form.UpperBound,
+			form.StopLoss, form.TakeProfit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := formEncodeDate(&form, c.dateFormat())
+	if err != nil {
+		return nil, err
 	}
 	o := struct {
 		ApiError
 		Order
 	}{}
-	urlStr := fmt.Sprintf("/v1/accounts/%d/orders/%d", c.accountId, orderId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/orders/%d", c.SelectedAccount(), orderId)
 	if err := requestAndDecode(c, "PATCH", urlStr, data, &o); err != nil {
 		return nil, err
 	}
 	return &o.Order, nil
 }
 
+// CancelOrderResponse holds the details of an order that was cancelled with Client method
+// CancelOrder().
 type CancelOrderResponse struct {
-	TransactionId int       `json:"id"`
-	Instrument    string    `json:"instrument"`
-	Units         int       `json:"units"`
-	Side          string    `json:"side"`
-	Price         float64   `json:"price"`
-	Time          time.Time `json:"time"`
+	TransactionId int     `json:"id"`
+	Instrument    string  `json:"instrument"`
+	Units         int     `json:"units"`
+	Side          string  `json:"side"`
+	Price         float64 `json:"price"`
+	Time          Time    `json:"time"`
 }
 
-// CancelOrder closes an open order.
+// CancelOrder closes an open order.  An *ApiError is returned if the order does not exist, e.g.
+// because it was filled.
 func (c *Client) CancelOrder(orderId int) (*CancelOrderResponse, error) {
-	urlStr := fmt.Sprintf("/v1/accounts/%d/orders/%d", c.accountId, orderId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/orders/%d", c.SelectedAccount(), orderId)
 	cor := struct {
 		ApiError
 		CancelOrderResponse
@@ -303,3 +587,265 @@ func (c *Client) CancelOrder(orderId int) (*CancelOrderResponse, error) {
 	}
 	return &cor.CancelOrderResponse, nil
 }
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// ReplaceOrder
+
+// ErrOrderFilled is returned by Client method ReplaceOrder() if the order was filled before it
+// could be cancelled.
+var ErrOrderFilled = errors.New("order was filled before it could be replaced")
+
+// OrderSpec specifies an order for Client methods ReplaceOrder() and CreateOrders().  The fields
+// correspond to the arguments of Client method NewOrder().  CreateOrders() also accepts specs of
+// type Market, which are submitted with NewTrade() and have neither a price nor an expiry.
+type OrderSpec struct {
+	Type       OrderType
+	Side       TradeSide
+	Units      int
+	Instrument string
+	Price      float64
+	Expiry     time.Time
+	Args       []NewOrderArg
+}
+
+// OrderResponse holds the result of Client method ReplaceOrder().
+type OrderResponse struct {
+	// Cancelled holds the details of the cancellation of the original order.
+	Cancelled *CancelOrderResponse
+
+	// Order is the order that replaces the original order.
+	Order *Order
+
+	// Filled is not nil if the original order was filled before it could be cancelled.
+	Filled *OrderFilledEvent
+}
+
+// ReplaceOrder cancels order orderId and creates a new order as specified by newSpec.  Use
+// ReplaceOrder for changes that ModifyOrder() does not support.
+//
+// If the order cannot be cancelled because it was filled, no new order is created and
+// ReplaceOrder returns ErrOrderFilled with the fill in OrderResponse.Filled.  If creating the new
+// order fails the OrderResponse with the cancellation is returned alongside the error.
+func (c *Client) ReplaceOrder(orderId int, newSpec OrderSpec) (*OrderResponse, error) {
+	cor, err := c.CancelOrder(orderId)
+	if err != nil {
+		if _, ok := err.(*ApiError); !ok {
+			return nil, err
+		}
+		fill, fillErr := c.orderFill(orderId)
+		if fillErr != nil || fill == nil {
+			return nil, err
+		}
+		return &OrderResponse{Filled: fill}, ErrOrderFilled
+	}
+
+	rsp := OrderResponse{Cancelled: cor}
+	rsp.Order, err = c.NewOrder(newSpec.Type, newSpec.Side, newSpec.Units, newSpec.Instrument,
+		newSpec.Price, newSpec.Expiry, newSpec.Args...)
+	if err != nil {
+		return &rsp, err
+	}
+	return &rsp, nil
+}
+
+// ErrOrderNotFilled is returned by AwaitFill() if the order was cancelled, e.g. because it
+// expired, before it was filled.
+var ErrOrderNotFilled = errors.New("order was cancelled before it was filled")
+
+// FillDetails describes the fill of an order.  See Client method AwaitFill().
+type FillDetails struct {
+	OrderId    int
+	TranId     int
+	Instrument string
+	Side       string
+	Units      int
+	Price      float64
+	Time       time.Time
+
+	// Event is the ORDER_FILLED event of the fill.
+	Event *OrderFilledEvent
+}
+
+// fillPollInterval is the interval at which AwaitFill polls the transactions of an account.
+var fillPollInterval = time.Second
+
+// AwaitFill waits until order orderId of account accountId is filled and returns the details of
+// the fill.  The transactions of the account are polled every second, starting with the
+// transaction that created the order so that a fill that already happened is found as well.  If
+// the order is cancelled or expires instead an error that wraps ErrOrderNotFilled is returned; if
+// ctx is done first the error of ctx is returned.
+//
+// Orders of NewTrade are filled immediately and have no ORDER_FILLED transaction.
+func (c *Client) AwaitFill(ctx context.Context, accountId, orderId int) (*FillDetails, error) {
+	ac := c.WithAccount(accountId)
+	// The id of an order is the id of the transaction that created it.
+	minId := orderId
+	for {
+		newest, fill, err := ac.scanTransactions(ctx, minId, orderId)
+		if fill != nil || err != nil {
+			if err != nil && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return fill, err
+		}
+		if newest >= minId {
+			minId = newest + 1
+		}
+
+		select {
+		case <-time.After(fillPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// scanTransactions pages through the transactions of the selected account from id minId onwards
+// until a page has fewer than maxTransactionCount entries and looks for the outcome of order
+// orderId.  It returns the id of the most recent transaction, if any.
+func (c *Client) scanTransactions(ctx context.Context, minId, orderId int) (int, *FillDetails,
+	error) {
+
+	newest := 0
+	args := []EventsArg{MinId(minId), Count(maxTransactionCount)}
+	for {
+		trans, err := c.transactionsContext(ctx, args...)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(trans) > 0 && newest == 0 {
+			newest = trans[0].TranId
+		}
+		// Transactions are ordered most recent first.
+		for i := len(trans) - 1; i >= 0; i-- {
+			fill, err := orderOutcome(&trans[i], orderId)
+			if fill != nil || err != nil {
+				return newest, fill, err
+			}
+		}
+		if len(trans) < maxTransactionCount || trans[len(trans)-1].TranId <= minId {
+			return newest, nil, nil
+		}
+		args = []EventsArg{MinId(minId), MaxId(trans[len(trans)-1].TranId - 1),
+			Count(maxTransactionCount)}
+	}
+}
+
+// orderOutcome returns the FillDetails if transaction t is the fill of order orderId and an
+// error that wraps ErrOrderNotFilled if t is its cancellation.
+func orderOutcome(t *Transaction, orderId int) (*FillDetails, error) {
+	switch t.Type {
+	case "ORDER_FILLED", "ORDER_CANCEL":
+	default:
+		return nil, nil
+	}
+	v := struct {
+		OrderId int    `json:"orderId"`
+		Side    string `json:"side"`
+		Reason  string `json:"reason"`
+	}{}
+	if err := json.Unmarshal(t.RawMessage, &v); err != nil {
+		return nil, err
+	}
+	if v.OrderId != orderId {
+		return nil, nil
+	}
+	if t.Type == "ORDER_CANCEL" {
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotFilled, v.Reason)
+	}
+	evt, err := t.AsEvent()
+	if err != nil {
+		return nil, err
+	}
+	return &FillDetails{
+		OrderId:    orderId,
+		TranId:     t.TranId,
+		Instrument: t.Instrument,
+		Side:       v.Side,
+		Units:      t.Units,
+		Price:      t.Price,
+		Time:       t.Time.Time,
+		Event:      evt.(*OrderFilledEvent),
+	}, nil
+}
+
+// orderFill returns the ORDER_FILLED event of order orderId if it is among the most recent
+// transactions of the selected account.
+func (c *Client) orderFill(orderId int) (*OrderFilledEvent, error) {
+	trans, err := c.Transactions(Count(maxTransactionCount))
+	if err != nil {
+		return nil, err
+	}
+	for i := range trans {
+		if trans[i].Type != "ORDER_FILLED" {
+			continue
+		}
+		evt, err := trans[i].AsEvent()
+		if err != nil {
+			return nil, err
+		}
+		if fill, ok := evt.(*OrderFilledEvent); ok && fill.OrderId() == orderId {
+			return fill, nil
+		}
+	}
+	return nil, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// CreateOrders
+
+// OrderResult holds the result of one order of Client method CreateOrders().  Either Order, or
+// Trade for an order of type Market, or Err is set.
+type OrderResult struct {
+	Order *Order
+	Trade *Trade
+	Err   error
+}
+
+// CreateOrders creates the orders specified by specs for account accountId.  Orders of type
+// Market are submitted with NewTrade() and all other orders with NewOrder().  The orders are
+// submitted concurrently, subject to the rate limit of the Client, and the result of specs[i] is
+// returned in the i-th OrderResult.  Orders that are rejected, e.g. with an *ApiError, do not
+// fail the call; the returned error is only set if an order could not be submitted because of a
+// network error, in which case the results of all orders are still returned.  ErrNoAccount is
+// returned for account id 0.
+func (c *Client) CreateOrders(accountId int, specs []OrderSpec) ([]OrderResult, error) {
+	if accountId <= 0 {
+		return nil, ErrNoAccount
+	}
+	ac := c.WithAccount(accountId)
+	results := make([]OrderResult, len(specs))
+	errs := parallel(len(specs), maxParallelRequests, func(i int) error {
+		spec := &specs[i]
+		if spec.Type == Market {
+			results[i].Trade, results[i].Err = ac.newMarketTrade(spec)
+		} else {
+			results[i].Order, results[i].Err = ac.NewOrder(spec.Type, spec.Side, spec.Units,
+				spec.Instrument, spec.Price, spec.Expiry, spec.Args...)
+		}
+		return results[i].Err
+	})
+	for _, err := range errs {
+		var netErr *NetworkError
+		if errors.As(err, &netErr) {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// newMarketTrade submits the market order of spec with NewTrade.
+func (c *Client) newMarketTrade(spec *OrderSpec) (*Trade, error) {
+	if spec.Price != 0 || !spec.Expiry.IsZero() {
+		return nil, errors.New("market orders do not accept a price or an expiry")
+	}
+	args := make([]NewTradeArg, len(spec.Args))
+	for i, arg := range spec.Args {
+		ta, ok := arg.(NewTradeArg)
+		if !ok {
+			return nil, fmt.Errorf("market orders do not accept %T", arg)
+		}
+		args[i] = ta
+	}
+	return c.NewTrade(spec.Side, spec.Units, spec.Instrument, args...)
+}
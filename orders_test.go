@@ -15,6 +15,14 @@
 package oanda_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/santegoeds/oanda"
@@ -43,27 +51,27 @@ func (ts *TestSuite) SetUpSuite(c *check.C) {
 func (ts *TestSuite) TestOrderApi(c *check.C) {
 	expiry := time.Now().Add(5 * time.Minute)
 
-	o, err := ts.c.NewOrder(oanda.Limit, oanda.Buy, 2, "eur_usd", 0.75, expiry,
+	o, err := ts.c.NewOrder(oanda.MarketIfTouched, oanda.Buy, 2, "eur_usd", 0.75, expiry,
 		oanda.UpperBound(1.0), oanda.LowerBound(0.5))
 	c.Assert(err, check.IsNil)
 	c.Log(o)
 	c.Assert(o.OrderId, check.Not(check.Equals), 0)
 	c.Assert(o.Expiry.UTC().Equal(expiry.Truncate(time.Second)), check.Equals, true)
 	c.Assert(o.Instrument, check.Equals, "EUR_USD")
-	c.Assert(o.OrderType, check.Equals, string(oanda.Limit))
-	c.Assert(o.Price, check.Equals, 0.75)
+	c.Assert(o.OrderType, check.Equals, string(oanda.MarketIfTouched))
+	c.Assert(o.Price, check.Equals, oanda.NewDecimal(0.75))
 	c.Assert(o.Units, check.Equals, 2)
 	c.Assert(o.Side, check.Equals, string(oanda.Buy))
-	c.Assert(o.LowerBound, check.Equals, 0.5)
-	c.Assert(o.UpperBound, check.Equals, 1.0)
-	c.Assert(o.StopLoss, check.Equals, 0.0)
-	c.Assert(o.TakeProfit, check.Equals, 0.0)
-	c.Assert(o.TrailingStop, check.Equals, 0.0)
+	c.Assert(o.LowerBound, check.Equals, oanda.NewDecimal(0.5))
+	c.Assert(o.UpperBound, check.Equals, oanda.NewDecimal(1.0))
+	c.Assert(o.StopLoss, check.Equals, oanda.Decimal{})
+	c.Assert(o.TakeProfit, check.Equals, oanda.Decimal{})
+	c.Assert(o.TrailingStop, check.Equals, oanda.Decimal{})
 
 	dup, err := ts.c.Order(o.OrderId)
 	c.Assert(err, check.IsNil)
 	c.Assert(dup.OrderId, check.Equals, o.OrderId)
-	c.Assert(dup.Expiry.Equal(o.Expiry), check.Equals, true)
+	c.Assert(dup.Expiry.Equal(o.Expiry.Time), check.Equals, true)
 	c.Assert(dup.Instrument, check.Equals, o.Instrument)
 	c.Assert(dup.OrderType, check.Equals, o.OrderType)
 	c.Assert(dup.Price, check.Equals, o.Price)
@@ -80,7 +88,7 @@ func (ts *TestSuite) TestOrderApi(c *check.C) {
 	c.Log(orders)
 	c.Assert(orders, check.HasLen, 1)
 	c.Assert(orders[0].OrderId, check.Equals, o.OrderId)
-	c.Assert(orders[0].Expiry.Equal(o.Expiry), check.Equals, true)
+	c.Assert(orders[0].Expiry.Equal(o.Expiry.Time), check.Equals, true)
 	c.Assert(orders[0].Instrument, check.Equals, o.Instrument)
 	c.Assert(orders[0].OrderType, check.Equals, o.OrderType)
 	c.Assert(orders[0].Price, check.Equals, o.Price)
@@ -103,3 +111,500 @@ func (ts *TestSuite) TestOrderApi(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(orders, check.HasLen, 0)
 }
+
+// withInstruments returns a RoundTripFunc that serves the instrument information of EUR_USD and
+// passes all other requests to rt.
+func withInstruments(rt roundTripFunc) roundTripFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/v1/instruments" {
+			return newResponse(http.StatusOK, `{"instruments": [
+				{"instrument": "EUR_USD", "precision": 0.00001}]}`), nil
+		}
+		return rt(req)
+	}
+}
+
+func (ts *TestLocalSuite) TestNewOrderTimeInForce(c *check.C) {
+	_, err := ts.c.NewOrder(oanda.Limit, oanda.Buy, 1, "eur_usd", 0.75, time.Time{},
+		oanda.WithTimeInForce(oanda.GoodTilDate))
+	c.Assert(err, check.ErrorMatches, "time-in-force GTD requires an expiry")
+
+	_, err = ts.c.NewOrder(oanda.Limit, oanda.Buy, 1, "eur_usd", 0.75, time.Now(),
+		oanda.WithTimeInForce(oanda.FillOrKill))
+	c.Assert(err, check.ErrorMatches, "time-in-force FOK does not accept an expiry")
+
+	_, err = ts.c.NewOrder(oanda.Limit, oanda.Buy, 1, "eur_usd", 0.75, time.Time{},
+		oanda.TimeInForce("XYZ"))
+	c.Assert(err, check.ErrorMatches, "invalid time-in-force XYZ")
+}
+
+func (ts *TestLocalSuite) TestNewOrderValidation(c *check.C) {
+	expiry := time.Now().Add(time.Hour)
+	_, err := ts.c.NewOrder(oanda.OrderType("market"), oanda.Buy, 1, "eur_usd", 0.75, expiry)
+	c.Assert(err, check.ErrorMatches, "invalid order type market; use NewTrade .*")
+
+	_, err = ts.c.NewOrder(oanda.Stop, oanda.Buy, 1, "eur_usd", 0, expiry)
+	c.Assert(err, check.ErrorMatches, "orders of type stop require a price")
+
+	_, err = ts.c.NewOrder(oanda.MarketIfTouched, oanda.Buy, 1, "eur_usd", 0.75, time.Time{})
+	c.Assert(err, check.ErrorMatches, "orders of type marketIfTouched require an expiry")
+}
+
+func (ts *TestLocalSuite) TestOrderBounds(c *check.C) {
+	var form url.Values
+	ts.c.SelectAccount(1)
+	ts.c.Transport = withInstruments(func(req *http.Request) (*http.Response, error) {
+		req.ParseForm()
+		form = req.PostForm
+		return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "orderOpened": {"id": 1}}`), nil
+	})
+	expiry := time.Now().Add(time.Hour)
+
+	// Limit and stop orders do not accept bounds.
+	for _, ot := range []oanda.OrderType{oanda.Limit, oanda.Stop} {
+		_, err := ts.c.NewOrder(ot, oanda.Buy, 1, "eur_usd", 1.2, expiry, oanda.UpperBound(1.3))
+		c.Assert(err, check.ErrorMatches, "orders of type "+string(ot)+" do not accept price bounds")
+		_, err = ts.c.NewOrder(ot, oanda.Sell, 1, "eur_usd", 1.2, expiry, oanda.LowerBound(1.1))
+		c.Assert(err, check.ErrorMatches, "orders of type "+string(ot)+" do not accept price bounds")
+	}
+
+	// MarketIfTouched orders accept both bounds.
+	_, err := ts.c.NewOrder(oanda.MarketIfTouched, oanda.Buy, 1, "eur_usd", 1.2, expiry,
+		oanda.LowerBound(1.1), oanda.UpperBound(1.3))
+	c.Assert(err, check.IsNil)
+	c.Assert(form.Get("lowerBound"), check.Equals, "1.1")
+	c.Assert(form.Get("upperBound"), check.Equals, "1.3")
+	_, err = ts.c.NewOrder(oanda.MarketIfTouched, oanda.Buy, 1, "eur_usd", 1.2, expiry,
+		oanda.LowerBound(1.3), oanda.UpperBound(1.25))
+	c.Assert(err, check.ErrorMatches, "upperBound 1.25 is less than lowerBound 1.3")
+	_, err = ts.c.NewOrder(oanda.MarketIfTouched, oanda.Buy, 1, "eur_usd", 1.2, expiry,
+		oanda.UpperBound(1.1))
+	c.Assert(err, check.ErrorMatches, "upperBound 1.1 of a buy is below the price 1.2")
+	_, err = ts.c.NewOrder(oanda.MarketIfTouched, oanda.Sell, 1, "eur_usd", 1.2, expiry,
+		oanda.LowerBound(1.3))
+	c.Assert(err, check.ErrorMatches, "lowerBound 1.3 of a sell is above the price 1.2")
+	// The bound that does not limit slippage is not checked against the price.
+	_, err = ts.c.NewOrder(oanda.MarketIfTouched, oanda.Sell, 1, "eur_usd", 1.2, expiry,
+		oanda.UpperBound(1.1))
+	c.Assert(err, check.IsNil)
+
+	// Market orders accept both bounds.
+	_, err = ts.c.NewTrade(oanda.Sell, 1, "eur_usd", oanda.LowerBound(1.1), oanda.UpperBound(1.3))
+	c.Assert(err, check.IsNil)
+	c.Assert(form.Get("lowerBound"), check.Equals, "1.1")
+	c.Assert(form.Get("upperBound"), check.Equals, "1.3")
+	_, err = ts.c.NewTrade(oanda.Sell, 1, "eur_usd", oanda.LowerBound(1.3), oanda.UpperBound(1.1))
+	c.Assert(err, check.ErrorMatches, "upperBound 1.1 is less than lowerBound 1.3")
+}
+
+func (ts *TestLocalSuite) TestSignedUnits(c *check.C) {
+	var form url.Values
+	ts.c.SelectAccount(1)
+	ts.c.Transport = withInstruments(func(req *http.Request) (*http.Response, error) {
+		req.ParseForm()
+		form = req.PostForm
+		return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "orderOpened": {"id": 1},
+			"tradeOpened": {"id": 2}}`), nil
+	})
+	expiry := time.Now().Add(time.Hour)
+
+	o, err := ts.c.NewOrder(oanda.Limit, "", 0, "eur_usd", 1.2, expiry, oanda.SignedUnits(-250))
+	c.Assert(err, check.IsNil)
+	c.Assert(form.Get("side"), check.Equals, "sell")
+	c.Assert(form.Get("units"), check.Equals, "250")
+	c.Assert(o.Side, check.Equals, "sell")
+	c.Assert(o.Units, check.Equals, 250)
+
+	t, err := ts.c.NewTrade("", 0, "eur_usd", oanda.SignedUnits(100))
+	c.Assert(err, check.IsNil)
+	c.Assert(form.Get("side"), check.Equals, "buy")
+	c.Assert(form.Get("units"), check.Equals, "100")
+	c.Assert(t.Side, check.Equals, "buy")
+	c.Assert(t.Units, check.Equals, 100)
+
+	_, err = ts.c.NewTrade(oanda.Buy, 0, "eur_usd", oanda.SignedUnits(-100))
+	c.Assert(err, check.ErrorMatches, "SignedUnits cannot be combined with a side or units")
+	_, err = ts.c.NewOrder(oanda.Limit, "", 5, "eur_usd", 1.2, expiry, oanda.SignedUnits(5))
+	c.Assert(err, check.ErrorMatches, "SignedUnits cannot be combined with a side or units")
+	_, err = ts.c.NewTrade("", 0, "eur_usd", oanda.SignedUnits(0))
+	c.Assert(err, check.ErrorMatches, "invalid SignedUnits 0")
+	_, err = ts.c.NewTrade("", 0, "eur_usd", oanda.SignedUnits(math.MinInt64))
+	c.Assert(err, check.ErrorMatches, "invalid SignedUnits -9223372036854775808")
+}
+
+func (ts *TestLocalSuite) TestNewOrderExpiryFormat(c *check.C) {
+	expiry := time.Date(2014, 4, 7, 20, 0, 0, 500000000, time.UTC)
+	for df, expected := range map[string]string{
+		"RFC3339": "2014-04-07T20:00:00Z",
+		"UNIX":    "1396900800500000",
+	} {
+		var form url.Values
+		rt := withInstruments(func(req *http.Request) (*http.Response, error) {
+			c.Check(req.Header.Get("X-Accept-Datetime-Format"), check.Equals, df)
+			if err := req.ParseForm(); err != nil {
+				return nil, err
+			}
+			form = req.PostForm
+			return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "price": 1.2,
+				"orderOpened": {"id": 1, "units": 1, "side": "buy"}}`), nil
+		})
+		client := oanda.NewTestClient(rt, oanda.WithDateFormat(oanda.DateFormat(df)))
+		client.SelectAccount(1)
+		_, err := client.NewOrder(oanda.Limit, oanda.Buy, 1, "eur_usd", 1.2, expiry)
+		c.Assert(err, check.IsNil)
+		c.Assert(form.Get("expiry"), check.Equals, expected)
+
+		// Market orders have no expiry.
+		_, err = client.NewTrade(oanda.Buy, 1, "eur_usd")
+		c.Assert(err, check.IsNil)
+		_, ok := form["expiry"]
+		c.Assert(ok, check.Equals, false)
+	}
+}
+
+func (ts *TestLocalSuite) TestLocalOrders(c *check.C) {
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/v1/accounts/1/orders")
+		if req.URL.Query().Get("ids") == "" {
+			return newResponse(http.StatusOK, `{}`), nil
+		}
+		c.Check(req.URL.Query().Get("ids"), check.Equals, "175427639,175427640")
+		return newResponse(http.StatusOK, `{"orders": [{"id": 175427639, "instrument": "EUR_USD",
+			"units": 20, "side": "buy", "type": "marketIfTouched", "time": "2014-02-11T16:22:07Z",
+			"price": 1, "takeProfit": 0, "stopLoss": 0, "expiry": "2014-02-15T16:22:07Z"}]}`), nil
+	})
+	orders, err := ts.c.Orders()
+	c.Assert(err, check.IsNil)
+	c.Assert(orders, check.NotNil)
+	c.Assert(orders, check.HasLen, 0)
+
+	orders, err = ts.c.Orders(oanda.Ids{175427639, 175427640})
+	c.Assert(err, check.IsNil)
+	c.Assert(orders, check.HasLen, 1)
+	c.Assert(orders[0].OrderId, check.Equals, 175427639)
+	c.Assert(orders[0].OrderType, check.Equals, "marketIfTouched")
+	c.Assert(orders[0].Expiry.Time, check.Equals, time.Date(2014, 2, 15, 16, 22, 7, 0, time.UTC))
+}
+
+func (ts *TestLocalSuite) TestLocalCancelOrder(c *check.C) {
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.Method, check.Equals, "DELETE")
+		if req.URL.Path != "/v1/accounts/1/orders/43211" {
+			return newResponse(http.StatusNotFound, `{"code": 1, "message":
+				"Invalid value specified for 'orderId'"}`), nil
+		}
+		return newResponse(http.StatusOK, `{"id": 43211, "instrument": "EUR_USD", "units": 5,
+			"side": "sell", "price": 1.45123, "time": "2013-01-01T00:00:00Z"}`), nil
+	})
+	rsp, err := ts.c.CancelOrder(43211)
+	c.Assert(err, check.IsNil)
+	c.Assert(*rsp, check.DeepEquals, oanda.CancelOrderResponse{
+		TransactionId: 43211,
+		Instrument:    "EUR_USD",
+		Units:         5,
+		Side:          "sell",
+		Price:         1.45123,
+		Time:          oanda.Time{Time: time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)},
+	})
+
+	_, err = ts.c.CancelOrder(1)
+	c.Assert(errors.Is(err, oanda.ErrInvalidArgument), check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestOrderFormValues(c *check.C) {
+	var form url.Values
+	ts.c.SelectAccount(1)
+	ts.c.Transport = withInstruments(func(req *http.Request) (*http.Response, error) {
+		req.ParseForm()
+		form = req.PostForm
+		return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "orderOpened": {"id": 1}}`), nil
+	})
+
+	expiry := time.Date(2014, 4, 7, 18, 0, 0, 0, time.UTC)
+	_, err := ts.c.NewOrder(oanda.Limit, oanda.Buy, 2, "eur_usd", 0.75, expiry,
+		oanda.TakeProfit(1.5))
+	c.Assert(err, check.IsNil)
+	c.Assert(form, check.DeepEquals, url.Values{
+		"type":       {"limit"},
+		"side":       {"buy"},
+		"units":      {"2"},
+		"instrument": {"EUR_USD"},
+		"price":      {"0.75"},
+		"expiry":     {"2014-04-07T18:00:00Z"},
+		"takeProfit": {"1.5"},
+	})
+
+	_, err = ts.c.NewTrade(oanda.Buy, 1, "eur_usd", oanda.Tag("order-42"))
+	c.Assert(err, check.IsNil)
+	c.Assert(form.Get("tag"), check.Equals, "order-42")
+
+	_, err = ts.c.ModifyTrade(1, oanda.StopLoss(0))
+	c.Assert(err, check.IsNil)
+	c.Assert(form, check.DeepEquals, url.Values{"stopLoss": {"0"}})
+
+	_, err = ts.c.ModifyOrder(1, oanda.Price(0.8), oanda.LowerBound(0.79))
+	c.Assert(err, check.IsNil)
+	c.Assert(form, check.DeepEquals, url.Values{"price": {"0.8"}, "lowerBound": {"0.79"}})
+}
+
+func (ts *TestLocalSuite) TestModifyMissingOrder(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusNotFound, `{"code": 1, "message":
+			"Invalid value specified for 'orderId'"}`), nil
+	})
+	_, err := ts.c.ModifyOrder(1, oanda.Units(2))
+	c.Assert(err, check.FitsTypeOf, &oanda.ApiError{})
+	c.Assert(errors.Is(err, oanda.ErrInvalidArgument), check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestOrderPriceString(c *check.C) {
+	body := `{"id": 1, "price": 1.10000}`
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusOK, body), nil
+	})
+
+	o, err := ts.c.Order(1)
+	c.Assert(err, check.IsNil)
+	c.Assert(o.Price.Cmp(oanda.NewDecimal(1.1)), check.Equals, 0)
+	c.Assert(o.Price.String(), check.Equals, "1.1")
+	c.Assert(o.PriceString, check.Equals, "1.10000")
+
+	body = `{"code": 43, "message": "Order not found", "moreInfo": ""}`
+	_, err = ts.c.Order(1)
+	apiErr, ok := err.(*oanda.ApiError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(apiErr.Code, check.Equals, 43)
+}
+
+func (ts *TestLocalSuite) TestReplaceOrder(c *check.C) {
+	var methods []string
+	ts.c.SelectAccount(1)
+	ts.c.Transport = withInstruments(func(req *http.Request) (*http.Response, error) {
+		methods = append(methods, req.Method)
+		switch req.Method {
+		case "DELETE":
+			return newResponse(http.StatusOK, `{"id": 10, "instrument": "EUR_USD", "units": 2,
+				"side": "buy", "price": 1.1, "time": "2014-04-07T18:31:05Z"}`), nil
+		case "POST":
+			return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "price": 1.2,
+				"time": "2014-04-07T18:31:06Z", "orderOpened": {"id": 11, "units": 3, "side": "buy"}}`), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "not found"}`), nil
+	})
+
+	rsp, err := ts.c.ReplaceOrder(10, oanda.OrderSpec{
+		Type:       oanda.Limit,
+		Side:       oanda.Buy,
+		Units:      3,
+		Instrument: "EUR_USD",
+		Price:      1.2,
+		Expiry:     time.Now().Add(time.Hour),
+		Args:       []oanda.NewOrderArg{oanda.StopLoss(1.1)},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(methods, check.DeepEquals, []string{"DELETE", "POST"})
+	c.Assert(rsp.Cancelled.TransactionId, check.Equals, 10)
+	c.Assert(rsp.Order.OrderId, check.Equals, 11)
+	c.Assert(rsp.Order.Price, check.Equals, oanda.NewDecimal(1.2))
+	c.Assert(rsp.Filled, check.IsNil)
+}
+
+func (ts *TestLocalSuite) TestReplaceFilledOrder(c *check.C) {
+	var methods []string
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		methods = append(methods, req.Method)
+		switch req.Method {
+		case "DELETE":
+			return newResponse(http.StatusBadRequest,
+				`{"code": 22, "message": "Order not found"}`), nil
+		case "GET":
+			return newResponse(http.StatusOK, `{"transactions": [
+				{"id": 13, "type": "TRADE_CREATE", "instrument": "EUR_USD", "units": 2},
+				{"id": 12, "type": "ORDER_FILLED", "orderId": 10},
+				{"id": 11, "type": "ORDER_FILLED", "orderId": 9}]}`), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "not found"}`), nil
+	})
+
+	rsp, err := ts.c.ReplaceOrder(10, oanda.OrderSpec{
+		Type:       oanda.Limit,
+		Side:       oanda.Buy,
+		Units:      3,
+		Instrument: "EUR_USD",
+		Price:      1.2,
+		Expiry:     time.Now().Add(time.Hour),
+	})
+	c.Assert(err, check.Equals, oanda.ErrOrderFilled)
+	c.Assert(methods, check.DeepEquals, []string{"DELETE", "GET"})
+	c.Assert(rsp.Order, check.IsNil)
+	c.Assert(rsp.Filled.TranId(), check.Equals, 12)
+	c.Assert(rsp.Filled.OrderId(), check.Equals, 10)
+}
+
+func (ts *TestLocalSuite) TestCreateOrders(c *check.C) {
+	mtx := sync.Mutex{}
+	var paths []string
+	ts.c.Transport = withInstruments(func(req *http.Request) (*http.Response, error) {
+		mtx.Lock()
+		paths = append(paths, req.URL.Path)
+		mtx.Unlock()
+		if err := req.ParseForm(); err != nil {
+			return nil, err
+		}
+		units := req.PostForm.Get("units")
+		switch units {
+		case "3":
+			return newResponse(http.StatusBadRequest,
+				`{"code": 23, "message": "Insufficient margin"}`), nil
+		case "4":
+			return nil, errors.New("connection refused")
+		}
+		return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "price": 1.2,
+			"orderOpened": {"id": `+units+`, "units": `+units+`, "side": "buy"}}`), nil
+	})
+
+	spec := func(units int) oanda.OrderSpec {
+		return oanda.OrderSpec{
+			Type:       oanda.Limit,
+			Side:       oanda.Buy,
+			Units:      units,
+			Instrument: "EUR_USD",
+			Price:      1.2,
+			Expiry:     time.Now().Add(time.Hour),
+		}
+	}
+	specs := []oanda.OrderSpec{}
+	for units := 1; units <= 10; units++ {
+		if units != 4 {
+			specs = append(specs, spec(units))
+		}
+	}
+	results, err := ts.c.CreateOrders(7, specs)
+	c.Assert(err, check.IsNil)
+	c.Assert(results, check.HasLen, len(specs))
+	for i, result := range results {
+		if specs[i].Units == 3 {
+			c.Assert(result.Order, check.IsNil)
+			c.Assert(result.Err, check.FitsTypeOf, &oanda.ApiError{})
+			continue
+		}
+		c.Assert(result.Err, check.IsNil)
+		c.Assert(result.Order.OrderId, check.Equals, specs[i].Units)
+	}
+	c.Assert(paths, check.HasLen, len(specs))
+	for _, path := range paths {
+		c.Assert(path, check.Equals, "/v1/accounts/7/orders")
+	}
+	c.Assert(ts.c.SelectedAccount(), check.Equals, 0)
+
+	// A network error is returned alongside the results of the other orders.
+	results, err = ts.c.CreateOrders(7, []oanda.OrderSpec{spec(1), spec(4), spec(3)})
+	c.Assert(err, check.ErrorMatches, ".*connection refused")
+	c.Assert(results, check.HasLen, 3)
+	c.Assert(results[0].Order.OrderId, check.Equals, 1)
+	c.Assert(results[1].Err, check.Equals, err)
+	c.Assert(results[2].Err, check.FitsTypeOf, &oanda.ApiError{})
+}
+
+func (ts *TestLocalSuite) TestCreateOrdersMarket(c *check.C) {
+	mtx := sync.Mutex{}
+	forms := map[string]url.Values{}
+	ts.c.Transport = withInstruments(func(req *http.Request) (*http.Response, error) {
+		if err := req.ParseForm(); err != nil {
+			return nil, err
+		}
+		mtx.Lock()
+		forms[req.PostForm.Get("type")] = req.PostForm
+		mtx.Unlock()
+		if req.PostForm.Get("type") == "market" {
+			return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "price": 1.25,
+				"tradeOpened": {"id": 2, "units": 5, "side": "sell"}}`), nil
+		}
+		return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "price": 1.2,
+			"orderOpened": {"id": 1, "units": 3, "side": "buy"}}`), nil
+	})
+
+	results, err := ts.c.CreateOrders(7, []oanda.OrderSpec{
+		{Type: oanda.Limit, Side: oanda.Buy, Units: 3, Instrument: "EUR_USD", Price: 1.2,
+			Expiry: time.Now().Add(time.Hour)},
+		{Type: oanda.Market, Instrument: "EUR_USD",
+			Args: []oanda.NewOrderArg{oanda.SignedUnits(-5), oanda.StopLoss(1.3)}},
+		{Type: oanda.Market, Side: oanda.Buy, Units: 1, Instrument: "EUR_USD", Price: 1.2},
+		{Type: oanda.Market, Side: oanda.Buy, Units: 1, Instrument: "EUR_USD",
+			Args: []oanda.NewOrderArg{oanda.WithTimeInForce(oanda.FillOrKill)}},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(results, check.HasLen, 4)
+	c.Assert(results[0].Err, check.IsNil)
+	c.Assert(results[0].Order.OrderId, check.Equals, 1)
+	c.Assert(results[0].Trade, check.IsNil)
+	c.Assert(results[1].Err, check.IsNil)
+	c.Assert(results[1].Order, check.IsNil)
+	c.Assert(results[1].Trade.TradeId, check.Equals, 2)
+	c.Assert(forms["market"].Get("side"), check.Equals, "sell")
+	c.Assert(forms["market"].Get("units"), check.Equals, "5")
+	c.Assert(forms["market"].Get("stopLoss"), check.Equals, "1.3")
+	c.Assert(forms["market"].Get("price"), check.Equals, "")
+	c.Assert(results[2].Err, check.ErrorMatches, "market orders do not accept a price or an expiry")
+	c.Assert(results[3].Err, check.ErrorMatches, "market orders do not accept .*")
+
+	_, err = ts.c.CreateOrders(0, []oanda.OrderSpec{{Type: oanda.Market}})
+	c.Assert(err, check.Equals, oanda.ErrNoAccount)
+}
+
+func (ts *TestLocalSuite) TestAwaitFill(c *check.C) {
+	defer func(d time.Duration) { *oanda.FillPollInterval = d }(*oanda.FillPollInterval)
+	*oanda.FillPollInterval = time.Millisecond
+
+	var queries []string
+	polls := []string{
+		`{"id": 20, "type": "LIMIT_ORDER_CREATE", "instrument": "EUR_USD"}`,
+		`{"id": 22, "type": "ORDER_FILLED", "orderId": 11, "instrument": "EUR_USD", "units": 1},
+		 {"id": 21, "type": "ORDER_CANCEL", "orderId": 12, "reason": "TIME_IN_FORCE_EXPIRED"}`,
+		`{"id": 24, "type": "ORDER_FILLED", "orderId": 20, "instrument": "EUR_USD", "side": "buy",
+		  "units": 2, "price": 1.25, "time": "2014-04-07T18:31:05Z"}`,
+	}
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Assert(req.URL.Path, check.Equals, "/v1/accounts/4/transactions")
+		queries = append(queries, req.URL.RawQuery)
+		body := `{"transactions": []}`
+		if len(polls) > 0 {
+			body = `{"transactions": [` + polls[0] + `]}`
+			polls = polls[1:]
+		}
+		return newResponse(http.StatusOK, body), nil
+	}))
+
+	fill, err := client.AwaitFill(context.Background(), 4, 20)
+	c.Assert(err, check.IsNil)
+	c.Assert(fill.TranId, check.Equals, 24)
+	c.Assert(fill.Side, check.Equals, "buy")
+	c.Assert(fill.Units, check.Equals, 2)
+	c.Assert(fill.Price, check.Equals, 1.25)
+	c.Assert(fill.Time.Equal(time.Date(2014, 4, 7, 18, 31, 5, 0, time.UTC)), check.Equals, true)
+	c.Assert(fill.Event.OrderId(), check.Equals, 20)
+	// The first request starts at the order and later requests only poll newer transactions.
+	c.Assert(queries, check.DeepEquals, []string{"count=500&minId=20", "count=500&minId=21",
+		"count=500&minId=23"})
+
+	// Full pages are followed by older pages until the order is reached.
+	page := make([]string, 500)
+	for i := range page {
+		page[i] = fmt.Sprintf(`{"id": %d, "type": "MARKET_ORDER_CREATE"}`, 600-i)
+	}
+	queries = nil
+	polls = []string{strings.Join(page, ","), `{"id": 50, "type": "ORDER_CANCEL", "orderId": 12,
+		"reason": "TIME_IN_FORCE_EXPIRED"}`}
+	_, err = client.AwaitFill(context.Background(), 4, 12)
+	c.Assert(errors.Is(err, oanda.ErrOrderNotFilled), check.Equals, true)
+	c.Assert(err, check.ErrorMatches, ".*: TIME_IN_FORCE_EXPIRED")
+	c.Assert(queries, check.DeepEquals, []string{"count=500&minId=12",
+		"count=500&maxId=100&minId=12"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.AwaitFill(ctx, 4, 30)
+	c.Assert(err, check.Equals, context.DeadlineExceeded)
+}
@@ -15,7 +15,9 @@
 package oanda
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -36,23 +38,31 @@ func (p *Position) String() string {
 		p.Instrument, p.Units, p.AvgPrice)
 }
 
+// PositionCloseResponse holds the result of Client method ClosePosition().
 type PositionCloseResponse struct {
 	// Ids are the transaction ids that are created as a result of closing the position.
 	TranIds    Ids    `json:"ids"`
 	Instrument string `json:"instrument"`
 	TotalUnits int    `json:"totalUnits"`
+
+	// Price is the average price at which the trades of the position were closed.
+	Price float64 `json:"price"`
 }
 
 type Positions []Position
 
 // Positions returns all positions for the selected account.
 func (c *Client) Positions() (Positions, error) {
-	urlStr := fmt.Sprintf("/v1/accounts/%d/positions", c.accountId)
+	return c.positions(context.Background())
+}
+
+func (c *Client) positions(ctx context.Context) (Positions, error) {
+	urlStr := fmt.Sprintf("/v1/accounts/%d/positions", c.SelectedAccount())
 	positions := struct {
 		ApiError
 		Positions Positions `json:"positions"`
 	}{}
-	if err := getAndDecode(c, urlStr, &positions); err != nil {
+	if err := getAndDecodeContext(ctx, c, urlStr, &positions); err != nil {
 		return nil, err
 	}
 	return positions.Positions, nil
@@ -61,7 +71,7 @@ func (c *Client) Positions() (Positions, error) {
 // Position returns the position for the selected account and instrument.
 func (c *Client) Position(instrument string) (*Position, error) {
 	instrument = strings.ToUpper(instrument)
-	urlStr := fmt.Sprintf("/v1/accounts/%d/positions/%s", c.accountId, instrument)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/positions/%s", c.SelectedAccount(), instrument)
 	p := struct {
 		ApiError
 		Position
@@ -72,16 +82,89 @@ func (c *Client) Position(instrument string) (*Position, error) {
 	return &p.Position, nil
 }
 
-// ClosePosition closes an existing position.
+// ClosePosition closes an existing position.  An *ApiError is returned if there is no open
+// position for instrument.
 func (c *Client) ClosePosition(instrument string) (*PositionCloseResponse, error) {
+	return c.closePosition(context.Background(), instrument)
+}
+
+func (c *Client) closePosition(ctx context.Context, instrument string) (*PositionCloseResponse,
+	error) {
+
 	instrument = strings.ToUpper(instrument)
 	pcr := struct {
 		ApiError
 		PositionCloseResponse
 	}{}
-	urlStr := fmt.Sprintf("/v1/accounts/%d/positions/%s", c.accountId, instrument)
-	if err := requestAndDecode(c, "DELETE", urlStr, nil, &pcr); err != nil {
+	urlStr := fmt.Sprintf("/v1/accounts/%d/positions/%s", c.SelectedAccount(), instrument)
+	if err := requestAndDecodeContext(ctx, c, "DELETE", urlStr, nil, &pcr); err != nil {
 		return nil, err
 	}
 	return &pcr.PositionCloseResponse, nil
 }
+
+// ClosedPosition is a position that was closed by Client method CloseAllPositions().
+type ClosedPosition struct {
+	// Position is the position as it was before it was closed.
+	Position
+	Response PositionCloseResponse
+}
+
+// ClosePositionsError is returned by Client method CloseAllPositions() if one or more positions
+// could not be closed.
+type ClosePositionsError struct {
+	// Errs holds the error for each instrument of which the position is still open.
+	Errs map[string]error
+}
+
+func (ce *ClosePositionsError) Error() string {
+	instrs := make([]string, 0, len(ce.Errs))
+	for instr := range ce.Errs {
+		instrs = append(instrs, instr)
+	}
+	sort.Strings(instrs)
+	msgs := make([]string, len(instrs))
+	for i, instr := range instrs {
+		msgs[i] = fmt.Sprintf("%s: %s", instr, ce.Errs[instr])
+	}
+	return "closing positions: " + strings.Join(msgs, "; ")
+}
+
+// CloseAllPositions closes all open positions of the account with the specified accountId.  The
+// positions are closed concurrently, subject to the rate limit and retries of the Client.  A
+// position that cannot be closed does not stop the others from being closed; CloseAllPositions
+// returns the positions that were closed together with a *ClosePositionsError for the positions
+// that were not.  ErrNoAccount is returned for account id 0.
+func (c *Client) CloseAllPositions(ctx context.Context, accountId int) ([]ClosedPosition, error) {
+	if accountId <= 0 {
+		return nil, ErrNoAccount
+	}
+	ac := c.WithAccount(accountId)
+	positions, err := ac.positions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*PositionCloseResponse, len(positions))
+	errs := parallel(len(positions), maxParallelRequests, func(i int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		results[i], err = ac.closePosition(ctx, positions[i].Instrument)
+		return err
+	})
+
+	closed := []ClosedPosition{}
+	ce := ClosePositionsError{Errs: make(map[string]error)}
+	for i, p := range positions {
+		if errs[i] != nil {
+			ce.Errs[p.Instrument] = errs[i]
+			continue
+		}
+		closed = append(closed, ClosedPosition{p, *results[i]})
+	}
+	if len(ce.Errs) > 0 {
+		return closed, &ce
+	}
+	return closed, nil
+}
@@ -15,6 +15,10 @@
 package oanda_test
 
 import (
+	"context"
+	"net/http"
+	"path"
+
 	"github.com/santegoeds/oanda"
 
 	"gopkg.in/check.v1"
@@ -31,14 +35,14 @@ func (ts *TestSuite) TestPositionsApi(c *check.C) {
 	c.Assert(positions, check.HasLen, 1)
 	c.Assert(positions[0].Side, check.Equals, t.Side)
 	c.Assert(positions[0].Units, check.Equals, t.Units)
-	c.Assert(positions[0].AvgPrice, check.Equals, t.Price)
+	c.Assert(positions[0].AvgPrice, check.Equals, t.Price.Float64())
 
 	p, err := ts.c.Position("eur_usd")
 	c.Assert(err, check.IsNil)
 	c.Log(p)
 	c.Assert(p.Side, check.Equals, t.Side)
 	c.Assert(p.Units, check.Equals, t.Units)
-	c.Assert(p.AvgPrice, check.Equals, t.Price)
+	c.Assert(p.AvgPrice, check.Equals, t.Price.Float64())
 
 	cpr, err := ts.c.ClosePosition("eur_usd")
 	c.Assert(err, check.IsNil)
@@ -60,3 +64,68 @@ func (ts *TestSuite) TestNonexistingPosition(c *check.C) {
 	c.Assert(ok, check.Equals, true)
 	c.Assert(apiErr.Code, check.Equals, 14)
 }
+
+func (ts *TestLocalSuite) TestClosePosition(c *check.C) {
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.Method, check.Equals, "DELETE")
+		if req.URL.Path != "/v1/accounts/1/positions/EUR_USD" {
+			return newResponse(http.StatusBadRequest, `{"code": 14, "message":
+				"Position not found"}`), nil
+		}
+		return newResponse(http.StatusOK, `{"ids": [12345, 12346], "instrument": "EUR_USD",
+			"totalUnits": 1234, "price": 1.2345}`), nil
+	})
+	pcr, err := ts.c.ClosePosition("eur_usd")
+	c.Assert(err, check.IsNil)
+	c.Assert(*pcr, check.DeepEquals, oanda.PositionCloseResponse{
+		TranIds:    oanda.Ids{12345, 12346},
+		Instrument: "EUR_USD",
+		TotalUnits: 1234,
+		Price:      1.2345,
+	})
+
+	_, err = ts.c.ClosePosition("usd_jpy")
+	c.Assert(err, check.FitsTypeOf, &oanda.ApiError{})
+}
+
+func (ts *TestLocalSuite) TestCloseAllPositions(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/v1/accounts/7/positions":
+			return newResponse(http.StatusOK, `{"positions": [
+				{"side": "buy", "instrument": "EUR_USD", "units": 100, "avgPrice": 1.3},
+				{"side": "sell", "instrument": "USD_JPY", "units": 50, "avgPrice": 110.5},
+				{"side": "buy", "instrument": "GBP_USD", "units": 20, "avgPrice": 1.6}]}`), nil
+		case req.Method == "DELETE" && req.URL.Path == "/v1/accounts/7/positions/USD_JPY":
+			return newResponse(http.StatusBadRequest, `{"code": 14, "message":
+				"Position not found"}`), nil
+		case req.Method == "DELETE":
+			instr := path.Base(req.URL.Path)
+			return newResponse(http.StatusOK, `{"ids": [1], "instrument": "`+instr+`",
+				"totalUnits": 10, "price": 1.5}`), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "Not found"}`), nil
+	})
+	closed, err := ts.c.CloseAllPositions(context.Background(), 7)
+	c.Assert(closed, check.HasLen, 2)
+	c.Assert(closed[0].Instrument, check.Equals, "EUR_USD")
+	c.Assert(closed[0].Units, check.Equals, 100)
+	c.Assert(closed[0].Response.Instrument, check.Equals, "EUR_USD")
+	c.Assert(closed[1].Instrument, check.Equals, "GBP_USD")
+	c.Assert(closed[1].Response.TranIds, check.DeepEquals, oanda.Ids{1})
+
+	ce, ok := err.(*oanda.ClosePositionsError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(ce.Errs, check.HasLen, 1)
+	c.Assert(ce.Errs["USD_JPY"], check.FitsTypeOf, &oanda.ApiError{})
+	c.Assert(err, check.ErrorMatches, "closing positions: USD_JPY: .*Position not found.*")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ts.c.CloseAllPositions(ctx, 7)
+	c.Assert(err, check.NotNil)
+
+	_, err = ts.c.CloseAllPositions(context.Background(), 0)
+	c.Assert(err, check.Equals, oanda.ErrNoAccount)
+}
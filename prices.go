@@ -15,7 +15,13 @@
 package oanda
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,10 +33,20 @@ type Prices map[string]PriceTick
 // PriceTick holds the Bid price, Ask price and status for an instrument at a given point
 // in time
 type PriceTick struct {
-	Time   time.Time `json:"time"`
-	Bid    float64   `json:"bid"`
-	Ask    float64   `json:"ask"`
-	Status string    `json:"status"`
+	Time   Time    `json:"time"`
+	Bid    float64 `json:"bid"`
+	Ask    float64 `json:"ask"`
+	Status string  `json:"status"`
+
+	// BidString and AskString hold Bid and Ask exactly as they were represented by the Oanda
+	// servers.
+	BidString string `json:"-"`
+	AskString string `json:"-"`
+
+	// Bids and Asks hold the available liquidity at multiple price levels for environments
+	// that provide market depth.
+	Bids []PriceBucket `json:"bids,omitempty"`
+	Asks []PriceBucket `json:"asks,omitempty"`
 }
 
 // Spread returns the difference between Ask and Bid prices.
@@ -38,11 +54,53 @@ func (p *PriceTick) Spread() float64 {
 	return p.Ask - p.Bid
 }
 
+// Mid returns the midpoint of the Bid and Ask prices.
+func (p *PriceTick) Mid() float64 {
+	return (p.Bid + p.Ask) / 2
+}
+
+// Tradeable returns false if trading in the instrument is halted, e.g. because the market is
+// closed.
+func (p *PriceTick) Tradeable() bool {
+	return p.Status != "halted"
+}
+
+// A PriceBucket holds the liquidity that is available at a price.  A Liquidity of zero means
+// that the liquidity is not known.
+type PriceBucket struct {
+	Price     float64 `json:"price,string"`
+	Liquidity int     `json:"liquidity"`
+}
+
+// Buckets returns the bid and ask price buckets of the PriceTick.
+//
+// None of the v1 environments (sandbox, fxpractice and fxtrade) report market depth; for those
+// Buckets returns a single bucket for the top of book Bid and Ask prices with unknown liquidity.
+// Real depth is only returned when the prices include "bids" and "asks" price levels.
+func (p *PriceTick) Buckets() (bids, asks []PriceBucket) {
+	bids, asks = p.Bids, p.Asks
+	if len(bids) == 0 {
+		bids = []PriceBucket{{Price: p.Bid}}
+	}
+	if len(asks) == 0 {
+		asks = []PriceBucket{{Price: p.Ask}}
+	}
+	return bids, asks
+}
+
 // PollPrices returns the latest PriceTick for the specified instruments.
 func (c *Client) PollPrices(instrument string, instruments ...string) (Prices, error) {
 	return c.PollPricesSince(time.Time{}, instrument, instruments...)
 }
 
+// Prices is like PollPrices for a slice of instruments.  An empty slice is an error.
+func (c *Client) Prices(instruments []string) (Prices, error) {
+	if len(instruments) == 0 {
+		return nil, errors.New("no instruments")
+	}
+	return c.PollPrices(instruments[0], instruments[1:]...)
+}
+
 // PollPricesSince returns the PriceTicks for instruments.  If since is not the zero time
 // instruments whose prices were not updated since the requested time.Time are excluded from the
 // result.
@@ -54,63 +112,146 @@ func (c *Client) PollPricesSince(since time.Time, instr string, instrs ...string
 	return pp.Poll()
 }
 
+// MaxPriceInstruments is the maximum number of instruments for which a PricePoller requests
+// prices in a single request.  Larger sets of instruments are polled in chunks.
+const MaxPriceInstruments = 50
+
 type PricePoller struct {
-	pr         *PollRequest
-	lastPrices Prices
+	chunks []*priceChunk
+}
+
+// priceChunk polls the prices of at most MaxPriceInstruments instruments.
+type priceChunk struct {
+	pr          *PollRequest
+	instruments []string
+	lastPrices  Prices
+}
+
+// PriceChunkError is returned by PricePoller method Poll() if the prices of a chunk of the
+// instruments could not be polled.
+type PriceChunkError struct {
+	// Instruments holds the instruments of the chunk that failed.
+	Instruments []string
+	Err         error
+}
+
+func (pe *PriceChunkError) Error() string {
+	return fmt.Sprintf("polling prices for %s: %s", strings.Join(pe.Instruments, ","), pe.Err)
+}
+
+// Unwrap returns the error of the failed request.
+func (pe *PriceChunkError) Unwrap() error {
+	return pe.Err
 }
 
 // NewPricePoller returns a poller to repeatedly poll Oanda for updates of the same set of
-// instruments.
+// instruments.  Instruments are requested in chunks of at most MaxPriceInstruments.
 func (c *Client) NewPricePoller(since time.Time, instr string, instrs ...string) (*PricePoller, error) {
-	instrs = append(instrs, instr)
+	instrs = append([]string{instr}, instrs...)
+	pp := PricePoller{}
+	for len(instrs) > 0 {
+		n := len(instrs)
+		if n > MaxPriceInstruments {
+			n = MaxPriceInstruments
+		}
+		chunk, err := c.newPriceChunk(since, instrs[:n])
+		if err != nil {
+			return nil, err
+		}
+		pp.chunks = append(pp.chunks, chunk)
+		instrs = instrs[n:]
+	}
+	return &pp, nil
+}
+
+func (c *Client) newPriceChunk(since time.Time, instrs []string) (*priceChunk, error) {
 	req, err := c.NewRequest("GET", "/v1/prices", nil)
 	if err != nil {
 		return nil, err
 	}
+	instruments := make([]string, len(instrs))
+	for i, instr := range instrs {
+		instruments[i] = strings.ToUpper(instr)
+		if err := validateInstrument(instruments[i]); err != nil {
+			return nil, err
+		}
+	}
 	q := req.URL.Query()
-	q.Set("instruments", strings.ToUpper(strings.Join(instrs, ",")))
+	q.Set("instruments", strings.Join(instruments, ","))
 	if !since.IsZero() {
 		q.Set("since", since.UTC().Format(time.RFC3339))
 	}
 	req.URL.RawQuery = q.Encode()
-	pp := PricePoller{
-		pr:         &PollRequest{c, req},
-		lastPrices: make(Prices),
+	pc := priceChunk{
+		pr:          &PollRequest{c: c, req: req},
+		instruments: instruments,
+		lastPrices:  make(Prices),
 	}
-	return &pp, err
+	return &pc, nil
+}
+
+// Request returns the PollRequest with which the PricePoller polls Oanda.  If the instruments
+// are polled in chunks Request returns the PollRequest of the first chunk.
+func (pp *PricePoller) Request() *PollRequest {
+	return pp.chunks[0].pr
 }
 
 // Poll returns the most recent set of prices for the instruments with which the PricePoller
-// was configured.
+// was configured.  If the instruments are polled in chunks and a chunk fails, Poll returns the
+// prices of the other chunks together with a *PriceChunkError for the first chunk that failed.
 func (pp *PricePoller) Poll() (Prices, error) {
-	rsp, err := pp.pr.Poll()
+	if len(pp.chunks) == 1 {
+		return pp.chunks[0].poll()
+	}
+	prices := make(Prices)
+	var firstErr error
+	for _, chunk := range pp.chunks {
+		chunkPrices, err := chunk.poll()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = &PriceChunkError{chunk.instruments, err}
+			}
+			continue
+		}
+		for instr, p := range chunkPrices {
+			prices[instr] = p
+		}
+	}
+	return prices, firstErr
+}
+
+func (pc *priceChunk) poll() (Prices, error) {
+	rsp, err := pc.pr.Poll()
 	if err != nil {
 		return nil, err
 	}
 	defer rsp.Body.Close()
-	if rsp.ContentLength == 0 {
-		return pp.lastPrices, nil
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
 	}
 
 	v := struct {
 		ApiError
-		Prices []struct {
-			Instrument string `json:"instrument"`
-			PriceTick
-		} `json:"prices"`
+		Prices []instrumentTick `json:"prices"`
 	}{}
-	dec := json.NewDecoder(rsp.Body)
-	if err = dec.Decode(&v); err != nil {
-		return nil, err
+	if rsp.StatusCode == http.StatusNotModified {
+		// The body, if any, is the cached body of the last successful response.
+		if len(body) == 0 {
+			return pc.lastPrices, nil
+		}
+		err = json.Unmarshal(body, &v)
+	} else {
+		err = decodeResponse(rsp, body, &v)
 	}
-	if err = v.checkReturnCode(); err != nil {
+	if err != nil {
 		return nil, err
 	}
 	prices := make(Prices)
 	for _, p := range v.Prices {
 		prices[p.Instrument] = p.PriceTick
 	}
-	pp.lastPrices = prices
+	pc.lastPrices = prices
 	return prices, nil
 }
 
@@ -119,6 +260,20 @@ type instrumentTick struct {
 	PriceTick
 }
 
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (it *instrumentTick) UnmarshalJSON(data []byte) error {
+	type tick instrumentTick
+	// Ticks are reused, see tickPool.
+	*it = instrumentTick{}
+	if err := json.Unmarshal(data, (*tick)(it)); err != nil {
+		return err
+	}
+	return numberText(data, map[string]*string{
+		"bid": &it.BidString,
+		"ask": &it.AskString,
+	})
+}
+
 var tickPool = sync.Pool{
 	New: func() interface{} { return &instrumentTick{} },
 }
@@ -131,10 +286,28 @@ type TickHandlerFunc func(instr string, pp PriceTick)
 // A PriceServer receives PriceTicks for one or more instrument(s).
 type PriceServer struct {
 	// If HeartbeatFunc is not nil it is invoked once for every heartbeat message that the
-	// PriceServer receives.
+	// PriceServer receives, except for consecutive heartbeats with the same time, which are
+	// delivered only once.
 	HeartbeatFunc HeartbeatHandlerFunc
-	srv           *messageServer
-	chanMap       *tickChans
+
+	// If OutOfOrderFunc is not nil it is invoked for every tick that is dropped because the
+	// Client was created with option WithMonotonicTimestamps.
+	OutOfOrderFunc TickHandlerFunc
+
+	srv      *messageServer
+	chanMap  *tickChans
+	handleFn TickHandlerFunc
+
+	// anyInstrument is true if ticks for instruments that are not known in advance are
+	// accepted.
+	anyInstrument bool
+
+	// lastTicks holds the time and status of the last tick delivered for each instrument if
+	// timestamps are monotonic.
+	lastTicks map[string]tickMark
+
+	// dedup drops replayed ticks if the Client was created with option WithStreamDedup.
+	dedup tickDedup
 }
 
 // NewPriceServer returns a PriceServer instance for receiving and handling Ticks.
@@ -144,22 +317,20 @@ func (c *Client) NewPriceServer(instr string, instrs ...string) (*PriceServer, e
 		instrs[i] = strings.ToUpper(instr)
 	}
 
-	req, err := c.NewRequest("GET", "/v1/prices", nil)
+	req, err := c.newPricesStreamRequest(context.Background(), instrs)
 	if err != nil {
 		return nil, err
 	}
-	useStreamHost(req)
-
-	u := req.URL
-	q := u.Query()
-	q.Set("instruments", strings.Join(instrs, ","))
-	q.Set("accountId", strconv.Itoa(c.accountId))
-
-	u.RawQuery = q.Encode()
 
 	ps := PriceServer{
 		chanMap: newTickChans(instrs),
 	}
+	if c.monotonicTicks {
+		ps.lastTicks = make(map[string]tickMark)
+	}
+	if c.streamDedup {
+		ps.dedup = make(tickDedup)
+	}
 
 	streamSrv := StreamServer{
 		handleMessagesFn:   ps.handleMessages,
@@ -175,6 +346,30 @@ func (c *Client) NewPriceServer(instr string, instrs ...string) (*PriceServer, e
 	return &ps, nil
 }
 
+// newPricesStreamRequest returns a request for the price stream of instruments instrs.
+func (c *Client) newPricesStreamRequest(ctx context.Context, instrs []string) (*http.Request,
+	error) {
+
+	for _, instr := range instrs {
+		if err := validateInstrument(instr); err != nil {
+			return nil, err
+		}
+	}
+	req, err := c.NewRequestWithContext(ctx, "GET", "/v1/prices", nil)
+	if err != nil {
+		return nil, err
+	}
+	useStreamHost(req)
+
+	u := req.URL
+	q := u.Query()
+	q.Set("instruments", strings.Join(instrs, ","))
+	q.Set("accountId", strconv.Itoa(c.SelectedAccount()))
+
+	u.RawQuery = q.Encode()
+	return req, nil
+}
+
 // ConnectAndHandle connects to the Oanda server and invokes handleFn for every Tick received.
 func (ps *PriceServer) ConnectAndHandle(handleFn TickHandlerFunc) error {
 	ps.initServer(handleFn)
@@ -187,17 +382,26 @@ func (ps *PriceServer) Stop() {
 }
 
 func (ps *PriceServer) initServer(handleFn TickHandlerFunc) {
+	ps.handleFn = handleFn
 	for _, instr := range ps.chanMap.Instruments() {
-		tickC := make(chan *instrumentTick, defaultBufferSize)
-		ps.chanMap.Set(instr, tickC)
+		ps.newTickChan(instr)
+	}
+}
+
+func (ps *PriceServer) newTickChan(instr string) chan *instrumentTick {
+	tickC := make(chan *instrumentTick, defaultBufferSize)
+	ps.chanMap.Set(instr, tickC)
 
-		go func(lclC <-chan *instrumentTick) {
-			for tick := range lclC {
-				handleFn(tick.Instrument, tick.PriceTick)
-				tickPool.Put(tick)
+	go func(lclC <-chan *instrumentTick) {
+		for tick := range lclC {
+			// Buffered ticks are discarded after Stop.
+			if !ps.srv.stopped() {
+				ps.handleFn(tick.Instrument, tick.PriceTick)
 			}
-		}(tickC)
-	}
+			tickPool.Put(tick)
+		}
+	}(tickC)
+	return tickC
 }
 
 func (ps *PriceServer) handleHeartbeats(hbC <-chan time.Time) {
@@ -215,7 +419,21 @@ func (ps *PriceServer) handleMessages(msgC <-chan StreamMessage) {
 			ps.Stop()
 			return
 		}
+		if ps.dedup.duplicate(tick) {
+			tickPool.Put(tick)
+			continue
+		}
+		if !ps.inOrder(tick) {
+			if ps.OutOfOrderFunc != nil {
+				ps.OutOfOrderFunc(tick.Instrument, tick.PriceTick)
+			}
+			tickPool.Put(tick)
+			continue
+		}
 		tickC, ok := ps.chanMap.Get(tick.Instrument)
+		if !ok && ps.anyInstrument {
+			tickC, ok = ps.newTickChan(tick.Instrument), true
+		}
 		if !ok {
 			// FIXME: Log error "unexpected instrument"
 		} else if tickC != nil {
@@ -232,6 +450,203 @@ func (ps *PriceServer) handleMessages(msgC <-chan StreamMessage) {
 	}
 }
 
+// inOrder returns false if timestamps are monotonic and tick is not newer than the last tick
+// that was delivered for the same instrument.  A tick with the time of the last tick is in order
+// if it changes the status of the instrument.
+func (ps *PriceServer) inOrder(tick *instrumentTick) bool {
+	if ps.lastTicks == nil {
+		return true
+	}
+	last, ok := ps.lastTicks[tick.Instrument]
+	if ok && (tick.Time.Before(last.time) || last.same(tick)) {
+		return false
+	}
+	ps.lastTicks[tick.Instrument] = tickMark{tick.Time.Time, tick.Status}
+	return true
+}
+
+// tickMark holds the time and status of a tick.
+type tickMark struct {
+	time   time.Time
+	status string
+}
+
+// same returns true if tick has the time and status of tm.
+func (tm tickMark) same(tick *instrumentTick) bool {
+	return tm.time.Equal(tick.Time.Time) && tm.status == tick.Status
+}
+
+// tickDedup holds the time and status of the last tick of each instrument to detect replayed
+// ticks.  A nil tickDedup detects no duplicates.
+type tickDedup map[string]tickMark
+
+// duplicate returns true if tick has the time and status of the previous tick of its
+// instrument.  A status transition, e.g. when the market closes, is not a duplicate.
+func (td tickDedup) duplicate(tick *instrumentTick) bool {
+	if td == nil {
+		return false
+	}
+	if last, ok := td[tick.Instrument]; ok && last.same(tick) {
+		return true
+	}
+	td[tick.Instrument] = tickMark{tick.Time.Time, tick.Status}
+	return false
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// PollPricesStream
+
+// Tick is a PriceTick for an instrument.
+type Tick struct {
+	Instrument string
+	PriceTick
+}
+
+// PollPricesStream streams the prices of instruments.  Ticks are sent on the returned Tick
+// channel; heartbeats are consumed silently.  If the stream fails the error is sent on the error
+// channel.  Both channels are closed when the stream ends or ctx is done.
+//
+// Unlike PriceServer the stream is only reopened after it ends with WithStreamReconnect.
+func (c *Client) PollPricesStream(ctx context.Context, instruments []string) (<-chan Tick,
+	<-chan error) {
+
+	tickC := make(chan Tick, c.streamBuffer)
+	errC := make(chan error, 1)
+
+	instrs := make([]string, len(instruments))
+	for i, instr := range instruments {
+		instrs[i] = strings.ToUpper(instr)
+	}
+	req, err := c.newPricesStreamRequest(ctx, instrs)
+	if err == nil && len(instrs) == 0 {
+		err = errors.New("no instruments")
+	}
+	if err != nil {
+		errC <- err
+		close(errC)
+		close(tickC)
+		return tickC, errC
+	}
+
+	var dedup tickDedup
+	if c.streamDedup {
+		dedup = make(tickDedup)
+	}
+	ctx, done := c.workers.track(ctx)
+	drops := dropCounter[Tick]{c: c, path: req.URL.Path, ch: tickC}
+	go func() {
+		defer done()
+		defer close(tickC)
+		defer close(errC)
+		c.runStream(ctx, req, errC, func(msg StreamMessage) error {
+			if msg.Type != "tick" {
+				return nil
+			}
+			tick := instrumentTick{}
+			if err := json.Unmarshal(msg.RawMessage, &tick); err != nil {
+				return err
+			}
+			if dedup.duplicate(&tick) {
+				return nil
+			}
+			t := Tick{tick.Instrument, tick.PriceTick}
+			return drops.deliver(ctx, t)
+		})
+	}()
+	return tickC, errC
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// PriceStream
+
+// PriceStream replays a previously captured price stream.
+type PriceStream struct {
+	*PriceServer
+	speed float64
+	stopC chan struct{}
+	once  sync.Once
+	last  time.Time
+}
+
+// ReplayArg is an optional argument for function ReplayPriceStream.
+type ReplayArg interface {
+	applyReplayArg(*PriceStream)
+}
+
+// ReplaySpeed is an optional argument for function ReplayPriceStream that determines how fast a
+// captured stream is replayed relative to the time at which messages were originally received.
+// A ReplaySpeed of 10 replays ten times faster than real time and a ReplaySpeed of zero or less
+// replays the stream without delay.  The default is to replay in real time.
+type ReplaySpeed float64
+
+func (rs ReplaySpeed) applyReplayArg(ps *PriceStream) {
+	ps.speed = float64(rs)
+}
+
+// ReplayPriceStream returns a PriceStream that replays the raw price stream read from r, e.g. a
+// stream that was previously captured to a file. The messages are decoded and dispatched exactly
+// like those of a live PriceServer.  ConnectAndHandle returns when the end of r is reached or
+// after Close is called.
+func ReplayPriceStream(r io.Reader, args ...ReplayArg) (*PriceStream, error) {
+	if r == nil {
+		return nil, errors.New("no stream to replay")
+	}
+	ps := PriceStream{
+		PriceServer: &PriceServer{
+			chanMap:       newTickChans(nil),
+			anyInstrument: true,
+		},
+		speed: 1,
+		stopC: make(chan struct{}),
+	}
+	for _, arg := range args {
+		arg.applyReplayArg(&ps)
+	}
+
+	streamSrv := StreamServer{
+		handleMessagesFn:   ps.handleMessages,
+		handleHeartbeatsFn: ps.handleHeartbeats,
+	}
+	ps.srv = newReplayServer(r, &streamSrv)
+	ps.srv.pace = ps.pace
+	return &ps, nil
+}
+
+// Close terminates the replay.
+func (ps *PriceStream) Close() error {
+	ps.once.Do(func() { close(ps.stopC) })
+	ps.PriceServer.Stop()
+	return nil
+}
+
+// Stop terminates the replay and causes ConnectAndHandle to return.
+func (ps *PriceStream) Stop() {
+	ps.Close()
+}
+
+// pace delays a message by the time that passed since the previous message was received,
+// scaled by the replay speed.
+func (ps *PriceStream) pace(msg StreamMessage) {
+	if ps.speed <= 0 {
+		return
+	}
+	v := struct {
+		Time Time `json:"time"`
+	}{}
+	if err := json.Unmarshal(msg.RawMessage, &v); err != nil || v.Time.IsZero() {
+		return
+	}
+	last := ps.last
+	ps.last = v.Time.Time
+	if last.IsZero() || !v.Time.After(last) {
+		return
+	}
+	select {
+	case <-time.After(time.Duration(float64(v.Time.Sub(last)) / ps.speed)):
+	case <-ps.stopC:
+	}
+}
+
 type tickChans struct {
 	mtx sync.RWMutex
 	m   map[string]chan *instrumentTick
@@ -15,6 +15,14 @@
 package oanda_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"time"
 
@@ -120,3 +128,765 @@ func (ts *TestSuite) TestPriceServerHeartbeat(c *check.C) {
 	})
 	c.Assert(err, check.IsNil)
 }
+
+const capturedPriceStream = `{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:05Z","bid":1.37060,"ask":1.37063}}
+{"heartbeat":{"time":"2014-04-07T18:31:06Z"}}
+{"tick":{"instrument":"USD_JPY","time":"2014-04-07T18:31:06.5Z","bid":103.3,"ask":103.31}}
+{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:07Z","bid":1.3707,"ask":1.3709}}
+`
+
+func (ts *TestLocalSuite) TestReplayPriceStream(c *check.C) {
+	ps, err := oanda.ReplayPriceStream(strings.NewReader(capturedPriceStream),
+		oanda.ReplaySpeed(100))
+	c.Assert(err, check.IsNil)
+
+	heartbeats := Counter{}
+	ps.HeartbeatFunc = func(time.Time) { heartbeats.Inc() }
+
+	mtx := sync.Mutex{}
+	ticks := make(map[string][]oanda.PriceTick)
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+
+	start := time.Now()
+	err = ps.ConnectAndHandle(func(instr string, tick oanda.PriceTick) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		ticks[instr] = append(ticks[instr], tick)
+		wg.Done()
+	})
+	c.Assert(err, check.IsNil)
+	wg.Wait()
+
+	// Two seconds of captured stream at a hundred times real time.
+	c.Assert(time.Since(start) >= 20*time.Millisecond, check.Equals, true)
+	c.Assert(heartbeats.Val(), check.Equals, 1)
+	c.Assert(ticks["EUR_USD"], check.HasLen, 2)
+	c.Assert(ticks["EUR_USD"][0].Bid, check.Equals, 1.3706)
+	c.Assert(ticks["EUR_USD"][0].BidString, check.Equals, "1.37060")
+	c.Assert(ticks["EUR_USD"][0].AskString, check.Equals, "1.37063")
+	c.Assert(ticks["EUR_USD"][1].Bid, check.Equals, 1.3707)
+	c.Assert(ticks["USD_JPY"], check.HasLen, 1)
+	c.Assert(ticks["USD_JPY"][0].Ask, check.Equals, 103.31)
+}
+
+func (ts *TestLocalSuite) TestReplayPriceStreamClose(c *check.C) {
+	ps, err := oanda.ReplayPriceStream(strings.NewReader(capturedPriceStream))
+	c.Assert(err, check.IsNil)
+
+	time.AfterFunc(100*time.Millisecond, func() { ps.Close() })
+	start := time.Now()
+	err = ps.ConnectAndHandle(func(string, oanda.PriceTick) {})
+	c.Assert(err, check.IsNil)
+	c.Assert(time.Since(start) < time.Second, check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestReplayPriceStreamCloseStopsDelivery(c *check.C) {
+	start := time.Date(2014, 4, 7, 18, 0, 0, 0, time.UTC)
+	lines := make([]string, 10000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`{"tick":{"instrument":"EUR_USD","time":"%s","bid":1.37,"ask":1.38}}`,
+			start.Add(time.Duration(i)*time.Second).Format(time.RFC3339))
+	}
+	// A large fixture that is not an io.Closer and a blocking one that is.
+	pr, pw := io.Pipe()
+	go func() {
+		fmt.Fprintln(pw, strings.Join(lines[:5], "\n"))
+		// The writer blocks until the reader is closed.
+		fmt.Fprintln(pw, strings.Join(lines[5:], "\n"))
+	}()
+	defer pw.Close()
+
+	for _, r := range []io.Reader{strings.NewReader(strings.Join(lines, "\n")), pr} {
+		ps, err := oanda.ReplayPriceStream(r, oanda.ReplaySpeed(0))
+		c.Assert(err, check.IsNil)
+
+		n := Counter{}
+		errC := make(chan error, 1)
+		go func() {
+			errC <- ps.ConnectAndHandle(func(string, oanda.PriceTick) {
+				if n.Inc() == 3 {
+					ps.Close()
+				}
+			})
+		}()
+		select {
+		case err = <-errC:
+		case <-time.After(5 * time.Second):
+			c.Fatal("replay did not stop after Close")
+		}
+		c.Assert(err, check.IsNil)
+		c.Assert(n.Val(), check.Equals, 3)
+		time.Sleep(20 * time.Millisecond)
+		c.Assert(n.Val(), check.Equals, 3)
+	}
+}
+
+func (ts *TestLocalSuite) TestMonotonicTimestamps(c *check.C) {
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithMonotonicTimestamps())
+	c.Assert(err, check.IsNil)
+
+	connects := Counter{}
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		connects.Inc()
+		if connects.Val() > 1 {
+			return nil, errors.New("stream closed")
+		}
+		return newResponse(http.StatusOK, capturedPriceStream+
+			`{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:07Z","bid":1.3708,"ask":1.3709}}
+{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:06Z","bid":1.3705,"ask":1.3706}}
+{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:08Z","bid":1.3710,"ask":1.3711}}
+`), nil
+	})
+
+	ps, err := client.NewPriceServer("EUR_USD", "USD_JPY")
+	c.Assert(err, check.IsNil)
+
+	mtx := sync.Mutex{}
+	var ticks, dropped []oanda.PriceTick
+	wg := sync.WaitGroup{}
+	wg.Add(6)
+	ps.OutOfOrderFunc = func(instr string, tick oanda.PriceTick) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		dropped = append(dropped, tick)
+		wg.Done()
+	}
+	go func() {
+		wg.Wait()
+		ps.Stop()
+	}()
+	err = ps.ConnectAndHandle(func(instr string, tick oanda.PriceTick) {
+		if instr != "EUR_USD" {
+			wg.Done()
+			return
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		ticks = append(ticks, tick)
+		wg.Done()
+	})
+	c.Assert(err, check.IsNil)
+
+	c.Assert(ticks, check.HasLen, 3)
+	c.Assert(ticks[0].Bid, check.Equals, 1.3706)
+	c.Assert(ticks[1].Bid, check.Equals, 1.3707)
+	c.Assert(ticks[2].Bid, check.Equals, 1.371)
+	c.Assert(dropped, check.HasLen, 2)
+	c.Assert(dropped[0].Bid, check.Equals, 1.3708)
+	c.Assert(dropped[1].Bid, check.Equals, 1.3705)
+}
+
+func (ts *TestLocalSuite) TestPriceBuckets(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusOK, `{"prices": [
+			{"instrument": "EUR_USD", "bid": 1.3706, "ask": 1.37063},
+			{"instrument": "USD_JPY", "bid": 103.3, "ask": 103.31,
+			 "bids": [{"price": "103.3", "liquidity": 1000000}, {"price": "103.29", "liquidity": 5000000}],
+			 "asks": [{"price": "103.31", "liquidity": 2000000}]}]}`), nil
+	})
+	prices, err := ts.c.PollPrices("EUR_USD", "USD_JPY")
+	c.Assert(err, check.IsNil)
+
+	tick := prices["EUR_USD"]
+	bids, asks := tick.Buckets()
+	c.Assert(bids, check.DeepEquals, []oanda.PriceBucket{{Price: 1.3706}})
+	c.Assert(asks, check.DeepEquals, []oanda.PriceBucket{{Price: 1.37063}})
+
+	tick = prices["USD_JPY"]
+	bids, asks = tick.Buckets()
+	c.Assert(bids, check.DeepEquals, []oanda.PriceBucket{{103.3, 1000000}, {103.29, 5000000}})
+	c.Assert(asks, check.DeepEquals, []oanda.PriceBucket{{103.31, 2000000}})
+}
+
+func (ts *TestLocalSuite) TestPrices(c *check.C) {
+	var query string
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		query = req.URL.Query().Get("instruments")
+		return newResponse(http.StatusOK, `{"prices": [
+			{"instrument": "EUR_USD", "bid": 1.3706, "ask": 1.37063},
+			{"instrument": "USD_JPY", "bid": 103.3, "ask": 103.32}]}`), nil
+	})
+
+	prices, err := ts.c.Prices([]string{"eur_usd", "USD_JPY"})
+	c.Assert(err, check.IsNil)
+	c.Assert(query, check.Equals, "EUR_USD,USD_JPY")
+	c.Assert(prices, check.HasLen, 2)
+	c.Assert(prices["USD_JPY"].Ask, check.Equals, 103.32)
+
+	query = ""
+	_, err = ts.c.Prices(nil)
+	c.Assert(err, check.ErrorMatches, "no instruments")
+	_, err = ts.c.Prices([]string{})
+	c.Assert(err, check.ErrorMatches, "no instruments")
+	c.Assert(query, check.Equals, "")
+}
+
+func (ts *TestLocalSuite) TestPollPricesHTTPError(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusBadGateway, "<html>Bad Gateway</html>"), nil
+	})
+	_, err := ts.c.PollPrices("EUR_USD")
+	var httpErr *oanda.HTTPError
+	c.Assert(errors.As(err, &httpErr), check.Equals, true)
+	c.Assert(httpErr.StatusCode, check.Equals, http.StatusBadGateway)
+
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rsp := newResponse(http.StatusTooManyRequests, "")
+		rsp.Header.Set("Retry-After", "2")
+		return rsp, nil
+	})
+	_, err = ts.c.PollPrices("EUR_USD")
+	c.Assert(errors.Is(err, oanda.ErrRateLimited), check.Equals, true)
+
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusOK, "<html>OK</html>"), nil
+	})
+	_, err = ts.c.PollPrices("EUR_USD")
+	var decErr *oanda.DecodeError
+	c.Assert(errors.As(err, &decErr), check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestPollRequestETag(c *check.C) {
+	var ifNoneMatch, ifModifiedSince []string
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		ifNoneMatch = append(ifNoneMatch, req.Header.Get("If-None-Match"))
+		ifModifiedSince = append(ifModifiedSince, req.Header.Get("If-Modified-Since"))
+		if len(ifNoneMatch) > 1 {
+			return newResponse(http.StatusNotModified, ""), nil
+		}
+		rsp := newResponse(http.StatusOK, `{"prices": [
+			{"instrument": "EUR_USD", "bid": 1.3706, "ask": 1.37063}]}`)
+		rsp.Header.Set("ETag", `"abc"`)
+		rsp.Header.Set("Last-Modified", "Mon, 07 Apr 2014 18:31:05 GMT")
+		return rsp, nil
+	})
+
+	pp, err := ts.c.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+	c.Assert(pp.Request().LastETag(), check.Equals, "")
+
+	prices, err := pp.Poll()
+	c.Assert(err, check.IsNil)
+	c.Assert(pp.Request().LastETag(), check.Equals, `"abc"`)
+	c.Assert(pp.Request().LastModified(), check.Equals, "Mon, 07 Apr 2014 18:31:05 GMT")
+
+	cached, err := pp.Poll()
+	c.Assert(err, check.IsNil)
+	c.Assert(cached, check.DeepEquals, prices)
+	c.Assert(ifNoneMatch, check.DeepEquals, []string{"", `"abc"`})
+	// The ETag is preferred over Last-Modified.
+	c.Assert(ifModifiedSince, check.DeepEquals, []string{"", ""})
+}
+
+func (ts *TestLocalSuite) TestPollRequestLastModified(c *check.C) {
+	var ifNoneMatch, ifModifiedSince []string
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		ifNoneMatch = append(ifNoneMatch, req.Header.Get("If-None-Match"))
+		ifModifiedSince = append(ifModifiedSince, req.Header.Get("If-Modified-Since"))
+		if len(ifModifiedSince) > 1 {
+			return newResponse(http.StatusNotModified, ""), nil
+		}
+		rsp := newResponse(http.StatusOK, `{"prices": [
+			{"instrument": "EUR_USD", "bid": 1.3706, "ask": 1.37063}]}`)
+		rsp.Header.Set("Last-Modified", "Mon, 07 Apr 2014 18:31:05 GMT")
+		return rsp, nil
+	})
+
+	pp, err := ts.c.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+	prices, err := pp.Poll()
+	c.Assert(err, check.IsNil)
+	cached, err := pp.Poll()
+	c.Assert(err, check.IsNil)
+	c.Assert(cached, check.DeepEquals, prices)
+	c.Assert(pp.Request().LastETag(), check.Equals, "")
+	c.Assert(ifNoneMatch, check.DeepEquals, []string{"", ""})
+	c.Assert(ifModifiedSince, check.DeepEquals, []string{"", "Mon, 07 Apr 2014 18:31:05 GMT"})
+}
+
+func (ts *TestLocalSuite) TestPollContext(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		// Simulate a hung connection.
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	pp, err := ts.c.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = pp.Request().PollContext(ctx)
+	c.Assert(err, check.Equals, context.DeadlineExceeded)
+
+	// Poll uses the context of the request.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := ts.c.NewRequestWithContext(ctx, "GET", "/v1/prices?instruments=EUR_USD", nil)
+	c.Assert(err, check.IsNil)
+	_, err = oanda.NewPollRequest(ts.c, req).Poll()
+	c.Assert(err, check.Equals, context.DeadlineExceeded)
+}
+
+func (ts *TestLocalSuite) TestPollLoop(c *check.C) {
+	polls := Counter{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := polls.Inc()
+		etag := `"1"`
+		if n > 2 {
+			etag = `"2"`
+		}
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `{"prices": [{"instrument": "EUR_USD", "bid": 1.370%d}]}`, n)
+	}))
+	defer srv.Close()
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+		req.URL.Host = srv.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	pp, err := ts.c.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rspC, errC := pp.Request().PollLoop(ctx, 10*time.Millisecond)
+
+	var bodies []string
+	for rsp := range rspC {
+		data, err := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		c.Assert(err, check.IsNil)
+		bodies = append(bodies, string(data))
+		if len(bodies) == 2 {
+			cancel()
+		}
+	}
+	_, ok := <-errC
+	c.Assert(ok, check.Equals, false)
+
+	// The second poll returned 304 Not Modified and was skipped.
+	c.Assert(bodies, check.DeepEquals, []string{
+		`{"prices": [{"instrument": "EUR_USD", "bid": 1.3701}]}`,
+		`{"prices": [{"instrument": "EUR_USD", "bid": 1.3703}]}`,
+	})
+}
+
+func (ts *TestLocalSuite) TestPollLoopCancel(c *check.C) {
+	polling := make(chan struct{}, 1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		polling <- struct{}{}
+		// Simulate a hung connection.
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	pp, err := ts.c.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rspC, errC := pp.Request().PollLoop(ctx, time.Millisecond)
+
+	// Cancelling a poll ends the loop without an error.
+	<-polling
+	cancel()
+	for err := range errC {
+		c.Errorf("unexpected error %v", err)
+	}
+	_, ok := <-rspC
+	c.Assert(ok, check.Equals, false)
+}
+
+func (ts *TestLocalSuite) TestPollPricesStream(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Check(req.URL.Path, check.Equals, "/v1/prices")
+		c.Check(req.URL.Query().Get("instruments"), check.Equals, "EUR_USD,USD_JPY")
+		fmt.Fprint(w, capturedPriceStream)
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+		req.URL.Host = srv.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tickC, errC := ts.c.PollPricesStream(ctx, []string{"eur_usd", "usd_jpy"})
+
+	var ticks []oanda.Tick
+	for tick := range tickC {
+		ticks = append(ticks, tick)
+		if len(ticks) == 3 {
+			cancel()
+		}
+	}
+	_, ok := <-errC
+	c.Assert(ok, check.Equals, false)
+
+	c.Assert(ticks, check.HasLen, 3)
+	c.Assert(ticks[0].Instrument, check.Equals, "EUR_USD")
+	c.Assert(ticks[0].Bid, check.Equals, 1.3706)
+	c.Assert(ticks[1].Instrument, check.Equals, "USD_JPY")
+	c.Assert(ticks[1].Ask, check.Equals, 103.31)
+	c.Assert(ticks[2].Time.Time, check.Equals, time.Date(2014, 4, 7, 18, 31, 7, 0, time.UTC))
+}
+
+func (ts *TestLocalSuite) TestStreamReconnect(c *check.C) {
+	client, err := oanda.NewFxPracticeClient("token",
+		oanda.WithStreamReconnect(2, time.Millisecond))
+	c.Assert(err, check.IsNil)
+	conns := Counter{}
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch conns.Inc() {
+		case 1, 3:
+			return newResponse(200, capturedPriceStream), nil
+		}
+		return nil, errors.New("connection refused")
+	})
+
+	tickC, errC := client.PollPricesStream(context.Background(), []string{"EUR_USD"})
+	ticks := 0
+	var errs []error
+	for tickC != nil || errC != nil {
+		select {
+		case _, ok := <-tickC:
+			if !ok {
+				tickC = nil
+			} else {
+				ticks++
+			}
+		case err, ok := <-errC:
+			if !ok {
+				errC = nil
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	c.Assert(ticks, check.Equals, 6)
+	c.Assert(conns.Val(), check.Equals, 5)
+	c.Assert(errs, check.HasLen, 5)
+	for i, attempt := range []int{1, 2, 1, 2} {
+		re, ok := errs[i].(*oanda.ReconnectError)
+		c.Assert(ok, check.Equals, true)
+		c.Assert(re.Attempt, check.Equals, attempt)
+	}
+	c.Assert(errs[0].(*oanda.ReconnectError).Err, check.Equals, io.EOF)
+	c.Assert(errs[4], check.ErrorMatches, "stream failed after 2 reconnect attempts: .*")
+}
+
+func (ts *TestLocalSuite) TestStreamHeartbeatTimeout(c *check.C) {
+	// stalledStream sends a heartbeat and then blocks until it is closed.
+	stalledStream := func(req *http.Request) (*http.Response, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			io.WriteString(pw, `{"heartbeat":{"time":"2014-04-07T18:31:06Z"}}`+"\n")
+		}()
+		rsp := newResponse(200, "")
+		rsp.Body, rsp.ContentLength = pr, -1
+		return rsp, nil
+	}
+
+	client := oanda.NewTestClient(roundTripFunc(stalledStream),
+		oanda.WithStreamHeartbeatTimeout(20*time.Millisecond))
+	_, errC := client.PollPricesStream(context.Background(), []string{"EUR_USD"})
+	err := <-errC
+	hte, ok := err.(*oanda.HeartbeatTimeoutError)
+	c.Assert(ok, check.Equals, true, check.Commentf("%v", err))
+	c.Assert(hte.Timeout, check.Equals, 20*time.Millisecond)
+
+	client = oanda.NewTestClient(roundTripFunc(stalledStream),
+		oanda.WithStreamHeartbeatTimeout(20*time.Millisecond),
+		oanda.WithStreamReconnect(1, time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	_, errC = client.PollPricesStream(ctx, []string{"EUR_USD"})
+	// Every connection receives a heartbeat, so the stream is reopened until it is cancelled.
+	for i := 0; i < 2; i++ {
+		re, ok := (<-errC).(*oanda.ReconnectError)
+		c.Assert(ok, check.Equals, true)
+		c.Assert(re.Attempt, check.Equals, 1)
+		_, ok = re.Err.(*oanda.HeartbeatTimeoutError)
+		c.Assert(ok, check.Equals, true)
+	}
+	cancel()
+	for range errC {
+	}
+
+	_, err = oanda.NewFxPracticeClient("token", oanda.WithStreamHeartbeatTimeout(0))
+	c.Assert(err, check.ErrorMatches, "timeout must be positive")
+}
+
+func (ts *TestLocalSuite) TestShutdown(c *check.C) {
+	// The stream blocks until its request is cancelled; polls return the same price.
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/v1/prices" {
+			return newResponse(200, `{"prices": [{"instrument": "EUR_USD", "bid": 1.3701}]}`), nil
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			<-req.Context().Done()
+			pw.CloseWithError(req.Context().Err())
+		}()
+		rsp := newResponse(200, "")
+		rsp.Body, rsp.ContentLength = pr, -1
+		return rsp, nil
+	}))
+	tickC, errC := client.PollPricesStream(context.Background(), []string{"EUR_USD"})
+	pp, err := client.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+	rspC, pollErrC := pp.Request().PollLoop(context.Background(), time.Millisecond)
+	rsp := <-rspC
+	rsp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Assert(client.Shutdown(ctx), check.IsNil)
+	for range errC {
+	}
+	_, ok := <-tickC
+	c.Assert(ok, check.Equals, false)
+	for rsp := range rspC {
+		rsp.Body.Close()
+	}
+	_, ok = <-pollErrC
+	c.Assert(ok, check.Equals, false)
+
+	// Streams that are started after Shutdown end immediately.
+	tickC, errC = client.WithAccount(1).PollPricesStream(context.Background(), []string{"EUR_USD"})
+	for range errC {
+	}
+	_, ok = <-tickC
+	c.Assert(ok, check.Equals, false)
+}
+
+func (ts *TestLocalSuite) TestPollBackoff(c *check.C) {
+	ms := time.Millisecond
+	d := 10 * ms
+	expected := []time.Duration{10 * ms, 10 * ms, 20 * ms, 20 * ms, 20 * ms, 40 * ms, 40 * ms,
+		40 * ms, 50 * ms}
+	for n := 1; n <= len(expected); n++ {
+		d = oanda.NextPollInterval(5*ms, 50*ms, 2, d, 10*ms, n)
+		c.Check(d, check.Equals, expected[n-1], check.Commentf("after %d responses", n))
+	}
+	// Changed data resets the interval.
+	c.Assert(oanda.NextPollInterval(5*ms, 50*ms, 2, d, 10*ms, 0), check.Equals, 10*ms)
+	// The interval is never shorter than min.
+	c.Assert(oanda.NextPollInterval(20*ms, 50*ms, 2, d, 10*ms, 0), check.Equals, 20*ms)
+}
+
+func (ts *TestLocalSuite) TestPollRequestCachedBody(c *check.C) {
+	const body = `{"prices": [{"instrument": "EUR_USD", "bid": 1.3706, "ask": 1.37063}]}`
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-None-Match") == `"abc"` {
+			return newResponse(http.StatusNotModified, ""), nil
+		}
+		rsp := newResponse(http.StatusOK, body)
+		rsp.Header.Set("ETag", `"abc"`)
+		return rsp, nil
+	})
+	pp, err := ts.c.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+
+	for i, status := range []int{http.StatusOK, http.StatusNotModified, http.StatusNotModified} {
+		rsp, err := pp.Request().Poll()
+		c.Assert(err, check.IsNil)
+		data, err := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		c.Assert(err, check.IsNil)
+		c.Assert(rsp.StatusCode, check.Equals, status)
+		c.Assert(string(data), check.Equals, body)
+		c.Assert(rsp.Header.Get(oanda.CachedResponseHeader) == "true", check.Equals, i > 0)
+	}
+}
+
+func (ts *TestLocalSuite) TestPollRequestConcurrent(c *check.C) {
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithCompression())
+	c.Assert(err, check.IsNil)
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.Header.Get("Accept-Encoding"), check.Equals, "gzip")
+		rsp := newResponse(http.StatusOK, `{"prices": [{"instrument": "EUR_USD", "bid": 1.3706}]}`)
+		rsp.Header.Set("ETag", `"abc"`)
+		return rsp, nil
+	})
+	pp, err := client.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+	pr := pp.Request()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				rsp, err := pr.Poll()
+				if c.Check(err, check.IsNil) {
+					rsp.Body.Close()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (ts *TestLocalSuite) TestPollResult(c *check.C) {
+	const body = `{"prices": [{"instrument": "EUR_USD", "bid": 1.3706, "ask": 1.37063}]}`
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-None-Match") == `"abc"` {
+			return newResponse(http.StatusNotModified, ""), nil
+		}
+		rsp := newResponse(http.StatusOK, body)
+		rsp.Header.Set("ETag", `"abc"`)
+		return rsp, nil
+	})
+	pp, err := ts.c.NewPricePoller(time.Time{}, "EUR_USD")
+	c.Assert(err, check.IsNil)
+
+	for _, notModified := range []bool{false, true} {
+		res, err := pp.Request().PollResult()
+		c.Assert(err, check.IsNil)
+		data, err := ioutil.ReadAll(res.Response.Body)
+		res.Response.Body.Close()
+		c.Assert(err, check.IsNil)
+		c.Assert(res.NotModified, check.Equals, notModified)
+		c.Assert(res.ETag, check.Equals, `"abc"`)
+		c.Assert(string(data), check.Equals, body)
+	}
+}
+
+func (ts *TestLocalSuite) TestPricePollerChunks(c *check.C) {
+	instrs := make([]string, 80)
+	for i := range instrs {
+		instrs[i] = fmt.Sprintf("i%02d_usd", i)
+	}
+	requests := Counter{}
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Inc()
+		chunk := strings.Split(req.URL.Query().Get("instruments"), ",")
+		c.Assert(len(chunk) <= oanda.MaxPriceInstruments, check.Equals, true)
+		if chunk[0] != "I00_USD" {
+			return newResponse(400, `{"code": 1, "message": "Invalid instrument"}`), nil
+		}
+		prices := make([]string, len(chunk))
+		for i, instr := range chunk {
+			prices[i] = fmt.Sprintf(`{"instrument": %q, "bid": 1.1, "ask": 1.2}`, instr)
+		}
+		return newResponse(200, `{"prices": [`+strings.Join(prices, ",")+`]}`), nil
+	}))
+
+	prices, err := client.PollPrices(instrs[0], instrs[1:]...)
+	c.Assert(requests.Val(), check.Equals, 2)
+	c.Assert(prices, check.HasLen, oanda.MaxPriceInstruments)
+	c.Assert(prices["I49_USD"].Bid, check.Equals, 1.1)
+	ce, ok := err.(*oanda.PriceChunkError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(ce.Instruments, check.HasLen, 80-oanda.MaxPriceInstruments)
+	c.Assert(ce.Instruments[0], check.Equals, "I50_USD")
+	c.Assert(err, check.ErrorMatches, "polling prices for I50_USD,.*,I79_USD: .*Invalid instrument.*")
+}
+
+const scriptedPriceStream = `{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:05Z","bid":1.37060,"ask":1.37063}}
+
+{"heartbeat":{"time":"2014-04-07T18:31:06Z"}}
+{"heartbeat":{"time":"2014-04-07T18:31:06Z"}}
+{}
+
+{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:05Z","bid":1.37060,"ask":1.37063}}
+{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:07Z","bid":1.3707,"ask":1.3709}}
+`
+
+func (ts *TestLocalSuite) TestStreamSkipsEmptyAndDuplicateLines(c *check.C) {
+	ps, err := oanda.ReplayPriceStream(strings.NewReader(scriptedPriceStream), oanda.ReplaySpeed(0))
+	c.Assert(err, check.IsNil)
+	heartbeats := Counter{}
+	ps.HeartbeatFunc = func(time.Time) { heartbeats.Inc() }
+	ticks := Counter{}
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	err = ps.ConnectAndHandle(func(string, oanda.PriceTick) {
+		ticks.Inc()
+		wg.Done()
+	})
+	c.Assert(err, check.IsNil)
+	wg.Wait()
+	// Without WithStreamDedup the replayed tick is delivered.
+	c.Assert(ticks.Val(), check.Equals, 3)
+	c.Assert(heartbeats.Val(), check.Equals, 1)
+
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(200, scriptedPriceStream), nil
+	}), oanda.WithStreamDedup())
+	tickC, errC := client.PollPricesStream(context.Background(), []string{"EUR_USD"})
+	var times []string
+	for tick := range tickC {
+		times = append(times, tick.Time.Format("15:04:05"))
+	}
+	c.Assert(<-errC, check.IsNil)
+	c.Assert(times, check.DeepEquals, []string{"18:31:05", "18:31:07"})
+}
+
+func (ts *TestLocalSuite) TestTickStatus(c *check.C) {
+	// The market closes and reopens; the halted tick has the time of the last tradeable tick.
+	stream := `{"tick":{"instrument":"EUR_USD","time":"2014-04-11T20:59:59Z","bid":1.3880,"ask":1.3882}}
+{"tick":{"instrument":"EUR_USD","time":"2014-04-11T20:59:59Z","bid":1.3880,"ask":1.3882,"status":"halted"}}
+{"tick":{"instrument":"EUR_USD","time":"2014-04-13T21:00:00Z","bid":1.3884,"ask":1.3888}}
+`
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(200, stream), nil
+	}), oanda.WithStreamDedup())
+	tickC, errC := client.PollPricesStream(context.Background(), []string{"EUR_USD"})
+	var tradeable []bool
+	for tick := range tickC {
+		tradeable = append(tradeable, tick.Tradeable())
+	}
+	c.Assert(<-errC, check.IsNil)
+	c.Assert(tradeable, check.DeepEquals, []bool{true, false, true})
+}
+
+func (ts *TestLocalSuite) TestStreamDropPolicy(c *check.C) {
+	stream := ""
+	for i := 1; i <= 5; i++ {
+		stream += fmt.Sprintf(`{"tick":{"instrument":"EUR_USD","time":"2014-04-07T18:31:0%dZ",`+
+			`"bid":1.3706,"ask":1.3708}}`+"\n", i)
+	}
+	// The receiver only reads after the stream has ended.
+	received := func(policy oanda.DropPolicy) ([]int, []int) {
+		var dropped []int
+		client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return newResponse(200, stream), nil
+		}), oanda.WithStreamBuffer(2), oanda.WithStreamDropPolicy(policy),
+			oanda.WithStreamDropHook(func(path string, n int) {
+				c.Check(path, check.Equals, "/v1/prices")
+				dropped = append(dropped, n)
+			}))
+		tickC, errC := client.PollPricesStream(context.Background(), []string{"EUR_USD"})
+		for err := range errC {
+			c.Assert(err, check.IsNil)
+		}
+		var secs []int
+		for tick := range tickC {
+			secs = append(secs, tick.Time.Second())
+		}
+		return secs, dropped
+	}
+
+	secs, dropped := received(oanda.DropOldest)
+	c.Assert(secs, check.DeepEquals, []int{4, 5})
+	c.Assert(dropped, check.DeepEquals, []int{1, 2, 3})
+
+	secs, dropped = received(oanda.DropNewest)
+	c.Assert(secs, check.DeepEquals, []int{1, 2})
+	c.Assert(dropped, check.DeepEquals, []int{1, 2, 3})
+
+	_, err := oanda.NewFxPracticeClient("token", oanda.WithStreamDropPolicy(oanda.DropPolicy(7)))
+	c.Assert(err, check.ErrorMatches, "unknown drop policy 7")
+	_, err = oanda.NewFxPracticeClient("token", oanda.WithStreamBuffer(-1))
+	c.Assert(err, check.ErrorMatches, "n must not be negative")
+}
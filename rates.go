@@ -15,7 +15,10 @@
 package oanda
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/url"
 	"strconv"
 	"strings"
@@ -42,6 +45,9 @@ func (ii *InstrumentInfo) String() string {
 		ii.Pip, ii.MarginRate)
 }
 
+// ErrNoAccount is returned by methods that require an account if no account is selected.
+var ErrNoAccount = errors.New("no account selected")
+
 type InstrumentField string
 
 const (
@@ -56,12 +62,17 @@ const (
 	InterestRateField    InstrumentField = "interestRate"
 )
 
-// Instruments returns instrument information.  Only the specified instruments are returned if instruments
-// is not nil.  If fields is not nil additional information fields is included.
+// Instruments returns instrument information for the selected account.  Only the specified
+// instruments are returned if instruments is not nil.  If fields is not nil additional information
+// fields is included.  ErrNoAccount is returned if no account is selected.
 //
 // See http://developer.oanda.com/docs/v1/rates/#get-an-instrument-list for further information.
 func (c *Client) Instruments(instruments []string, fields []InstrumentField) (map[string]InstrumentInfo, error) {
 
+	accountId := c.SelectedAccount()
+	if accountId == 0 {
+		return nil, ErrNoAccount
+	}
 	u, err := url.Parse("/v1/instruments")
 	if err != nil {
 		return nil, err
@@ -78,9 +89,7 @@ func (c *Client) Instruments(instruments []string, fields []InstrumentField) (ma
 		}
 		q.Set("fields", strings.Join(ss, ","))
 	}
-	if c.accountId != 0 {
-		q.Set("accountId", strconv.Itoa(c.accountId))
-	}
+	q.Set("accountId", strconv.Itoa(accountId))
 	u.RawQuery = q.Encode()
 
 	v := struct {
@@ -102,6 +111,94 @@ func (c *Client) Instruments(instruments []string, fields []InstrumentField) (ma
 	return info, nil
 }
 
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Instrument cache
+
+// defaultInstrumentCacheTTL is the default duration for which instrument information is reused.
+const defaultInstrumentCacheTTL = time.Hour
+
+// cachedInstrumentFields are the fields of the instrument information that is cached.
+var cachedInstrumentFields = []InstrumentField{DisplayNameField, PipField, MaxTradeUnitsField,
+	PrecisionField, MaxTrailingStopField, MinTrailingStopField, MarginRateField, HaltedField}
+
+// InstrumentInfo returns the cached information of instrument name for the selected account.
+// The cache is loaded if it is empty or older than the TTL of WithInstrumentCacheTTL.  False is
+// returned if the instrument is unknown or the cache could not be loaded.
+func (c *Client) InstrumentInfo(name string) (InstrumentInfo, bool) {
+	instruments, err := c.cachedInstruments()
+	if err != nil {
+		return InstrumentInfo{}, false
+	}
+	info, ok := instruments[strings.ToUpper(name)]
+	return info, ok
+}
+
+// FormatPrice rounds price to the precision of instrument, e.g. to 3 decimals for USD_JPY or 5
+// for EUR_USD, and formats it without exponent or trailing zeros.  The precision is taken from
+// the instrument cache, see InstrumentInfo.
+func (c *Client) FormatPrice(instrument string, price float64) (string, error) {
+	instrument = strings.ToUpper(instrument)
+	instruments, err := c.cachedInstruments()
+	if err != nil {
+		return "", err
+	}
+	info, ok := instruments[instrument]
+	if !ok {
+		return "", fmt.Errorf("unknown instrument %s", instrument)
+	}
+	return formatPrice(price, info.Precision), nil
+}
+
+// formatPrice rounds price to a multiple of precision and formats it with at most as many
+// decimals as precision.  Prices are formatted unrounded if the precision is not known.
+func formatPrice(price, precision float64) string {
+	if precision <= 0 {
+		return strconv.FormatFloat(price, 'f', -1, 64)
+	}
+	decimals := 0
+	p := strconv.FormatFloat(precision, 'f', -1, 64)
+	if i := strings.IndexByte(p, '.'); i >= 0 {
+		decimals = len(p) - i - 1
+	}
+	s := strconv.FormatFloat(math.Round(price/precision)*precision, 'f', decimals, 64)
+	if decimals > 0 {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// RefreshInstruments reloads the cached instrument information of account accountId.
+func (c *Client) RefreshInstruments(accountId int) error {
+	_, err := c.WithAccount(accountId).loadInstruments()
+	return err
+}
+
+// cachedInstruments returns the instrument information of the selected account from the cache.
+func (c *Client) cachedInstruments() (map[string]InstrumentInfo, error) {
+	rc := c.rates
+	rc.mtx.Lock()
+	ci, ok := rc.instruments[c.SelectedAccount()]
+	rc.mtx.Unlock()
+	if ok && time.Since(ci.time) < c.instrumentTTL {
+		return ci.infos, nil
+	}
+	return c.loadInstruments()
+}
+
+// loadInstruments requests the instrument information of the selected account and caches it.
+func (c *Client) loadInstruments() (map[string]InstrumentInfo, error) {
+	accountId := c.SelectedAccount()
+	infos, err := c.Instruments(nil, cachedInstrumentFields)
+	if err != nil {
+		return nil, err
+	}
+	rc := c.rates
+	rc.mtx.Lock()
+	rc.instruments[accountId] = cachedInstruments{infos, time.Now()}
+	rc.mtx.Unlock()
+	return infos, nil
+}
+
 type (
 	// Granularity determines the interval at which historic instrument prices are converted into candles.
 	Granularity string
@@ -131,6 +228,23 @@ const (
 	M   Granularity = "M"
 )
 
+// granularities lists the intraday granularities in increasing order alongside their duration.
+var granularities = []struct {
+	g Granularity
+	d time.Duration
+}{
+	{S5, 5 * time.Second}, {S10, 10 * time.Second}, {S15, 15 * time.Second},
+	{S30, 30 * time.Second}, {M1, time.Minute}, {M2, 2 * time.Minute}, {M3, 3 * time.Minute},
+	{M5, 5 * time.Minute}, {M10, 10 * time.Minute}, {M15, 15 * time.Minute},
+	{M30, 30 * time.Minute}, {H1, time.Hour}, {H2, 2 * time.Hour}, {H3, 3 * time.Hour},
+	{H4, 4 * time.Hour}, {H6, 6 * time.Hour}, {H8, 8 * time.Hour}, {H12, 12 * time.Hour},
+	{D, 24 * time.Hour},
+}
+
+// ErrCountWithRange is returned for candle requests that specify Count as well as both StartTime
+// and EndTime, which Oanda rejects.
+var ErrCountWithRange = errors.New("count cannot be combined with both start and end time")
+
 // CandlesArg implements optional arguments for MidpointCandles and BidAskCandles.
 type CandlesArg interface {
 	applyCandlesArg(url.Values)
@@ -205,36 +319,55 @@ func (wa WeeklyAlignment) applyCandlesArg(v url.Values) {
 
 // MidpointCandles represents instrument history with a specific granularity.
 type MidpointCandles struct {
-	Instrument  string      `json:"instrument"`
-	Granularity Granularity `json:"granularity"`
-	Candles     []struct {
-		Time     time.Time `json:"time"`
-		OpenMid  float64   `json:"openMid"`
-		HighMid  float64   `json:"highMid"`
-		LowMid   float64   `json:"lowMid"`
-		CloseMid float64   `json:"closeMid"`
-		Volume   int       `json:"volume"`
-		Complete bool      `json:"complete"`
-	} `json:"candles"`
+	Instrument  string           `json:"instrument"`
+	Granularity Granularity      `json:"granularity"`
+	Candles     []MidpointCandle `json:"candles"`
+}
+
+// MidpointCandle holds the midpoint prices of an instrument during a single period.
+type MidpointCandle struct {
+	Time     Time    `json:"time"`
+	OpenMid  float64 `json:"openMid"`
+	HighMid  float64 `json:"highMid"`
+	LowMid   float64 `json:"lowMid"`
+	CloseMid float64 `json:"closeMid"`
+	Volume   int     `json:"volume"`
+	Complete bool    `json:"complete"`
+}
+
+// Mid returns the midpoint prices of the candle.
+func (mc *MidpointCandle) Mid() (open, high, low, close float64) {
+	return mc.OpenMid, mc.HighMid, mc.LowMid, mc.CloseMid
 }
 
 // BidAskCandles represents Bid and Ask instrument history with a specific granularity.
 type BidAskCandles struct {
-	Instrument  string      `json:"instrument"`
-	Granularity Granularity `json:"granularity"`
-	Candles     []struct {
-		Time     time.Time `json:"time"`
-		OpenBid  float64   `json:"openBid"`
-		OpenAsk  float64   `json:"openAsk"`
-		HighBid  float64   `json:"highBid"`
-		HighAsk  float64   `json:"highAsk"`
-		LowBid   float64   `json:"lowBid"`
-		LowAsk   float64   `json:"lowAsk"`
-		CloseBid float64   `json:"closeBid"`
-		CloseAsk float64   `json:"closeAsk"`
-		Volume   int       `json:"volume"`
-		Complete bool      `json:"complete"`
-	} `json:"candles"`
+	Instrument  string         `json:"instrument"`
+	Granularity Granularity    `json:"granularity"`
+	Candles     []BidAskCandle `json:"candles"`
+}
+
+// BidAskCandle holds the bid and ask prices of an instrument during a single period.
+type BidAskCandle struct {
+	Time     Time    `json:"time"`
+	OpenBid  float64 `json:"openBid"`
+	OpenAsk  float64 `json:"openAsk"`
+	HighBid  float64 `json:"highBid"`
+	HighAsk  float64 `json:"highAsk"`
+	LowBid   float64 `json:"lowBid"`
+	LowAsk   float64 `json:"lowAsk"`
+	CloseBid float64 `json:"closeBid"`
+	CloseAsk float64 `json:"closeAsk"`
+	Volume   int     `json:"volume"`
+	Complete bool    `json:"complete"`
+}
+
+// Mid returns the midpoints of the bid and ask prices of the candle.  The high and low midpoints
+// are computed from the high and low bid and ask prices, which need not have occurred at the
+// same time.
+func (bc *BidAskCandle) Mid() (open, high, low, close float64) {
+	return (bc.OpenBid + bc.OpenAsk) / 2, (bc.HighBid + bc.HighAsk) / 2,
+		(bc.LowBid + bc.LowAsk) / 2, (bc.CloseBid + bc.CloseAsk) / 2
 }
 
 // PollMidpointCandles returns historic midpoint prices for an instrument.
@@ -276,6 +409,9 @@ func (c *Client) PollBidAskCandles(instrument string, granularity Granularity,
 func (c *Client) newCandlesURL(instrument string, granularity Granularity, candleFormat string,
 	args ...CandlesArg) (*url.URL, error) {
 
+	if err := validateInstrument(instrument); err != nil {
+		return nil, err
+	}
 	u, err := url.Parse("/v1/candles")
 	if err != nil {
 		return nil, err
@@ -288,7 +424,172 @@ func (c *Client) newCandlesURL(instrument string, granularity Granularity, candl
 	for _, arg := range args {
 		arg.applyCandlesArg(q)
 	}
+	if q.Get("count") != "" && q.Get("start") != "" && q.Get("end") != "" {
+		return nil, ErrCountWithRange
+	}
 	u.RawQuery = q.Encode()
 
 	return u, err
 }
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Candle ranges
+
+// CandlesRange returns the midpoint candles of instrument from start up to and including end.
+// Ranges with more candles than Oanda returns for a single request are split into sub-ranges
+// that are fetched concurrently, subject to the rate limit and retries of the Client, and
+// stitched together in chronological order without duplicates.  Sub-ranges without candles, e.g.
+// on weekends, are not an error.
+//
+// If the request for a sub-range fails CandlesRange returns the candles of the other sub-ranges
+// together with the error of the earliest sub-range that failed.
+func (c *Client) CandlesRange(ctx context.Context, instrument string, granularity Granularity,
+	start, end time.Time) ([]MidpointCandle, error) {
+
+	if end.Before(start) {
+		return nil, errors.New("end is before start")
+	}
+	ranges := candleRanges(granularity, start, end)
+	results := make([][]MidpointCandle, len(ranges))
+	errs := parallel(len(ranges), maxParallelRequests, func(i int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		u, err := c.newCandlesURL(instrument, granularity, "midpoint",
+			StartTime(ranges[i].start), EndTime(ranges[i].end), IncludeFirst(true))
+		if err != nil {
+			return err
+		}
+		candles := struct {
+			ApiError
+			MidpointCandles
+		}{}
+		if err = getAndDecodeContext(ctx, c, u.String(), &candles); err != nil {
+			return fmt.Errorf("candles from %s to %s: %w",
+				ranges[i].start.Format(time.RFC3339), ranges[i].end.Format(time.RFC3339), err)
+		}
+		results[i] = candles.Candles
+		return nil
+	})
+
+	var err error
+	for _, e := range errs {
+		if e != nil {
+			err = e
+			break
+		}
+	}
+	candles := []MidpointCandle{}
+	for _, r := range results {
+		for _, candle := range r {
+			// Adjacent sub-ranges share the candle at their boundary.
+			if n := len(candles); n > 0 && !candle.Time.After(candles[n-1].Time.Time) {
+				continue
+			}
+			candles = append(candles, candle)
+		}
+	}
+	return candles, err
+}
+
+type timeRange struct {
+	start, end time.Time
+}
+
+// candleRanges splits the period from start to end into sub-ranges of at most maxCandles
+// candles of granularity, including the candle at each end.  Granularities of a week or more are
+// not split.
+func candleRanges(granularity Granularity, start, end time.Time) []timeRange {
+	var d time.Duration
+	for _, v := range granularities {
+		if v.g == granularity {
+			d = v.d
+		}
+	}
+	if d == 0 {
+		return []timeRange{{start, end}}
+	}
+	ranges := []timeRange{}
+	// Adjacent sub-ranges share the candle at their boundary.
+	span := (maxCandles - 1) * d
+	for s := start; ; s = s.Add(span) {
+		e := s.Add(span)
+		if !e.Before(end) {
+			return append(ranges, timeRange{s, end})
+		}
+		ranges = append(ranges, timeRange{s, e})
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Trading schedule
+
+const (
+	// maxCandles is the maximum number of candles that Oanda returns for a single request.
+	maxCandles = 5000
+
+	// minSessionGap is the shortest period without candles that is considered a closed market.
+	minSessionGap = time.Hour
+)
+
+// Session represents a period during which an instrument was traded.
+type Session struct {
+	Start time.Time
+	End   time.Time
+}
+
+// String implements the fmt.Stringer interface.
+func (s *Session) String() string {
+	return fmt.Sprintf("Session{Start: %s, End: %s}", s.Start.Format(time.RFC3339),
+		s.End.Format(time.RFC3339))
+}
+
+// TradingSchedule infers the trading sessions of an instrument from the candles of the lookback
+// period that ends now.  A period without candles of at least an hour, such as a weekend or a
+// holiday, separates two sessions.  Start and End of each Session are in UTC.
+func (c *Client) TradingSchedule(instrument string, lookback time.Duration) ([]Session, error) {
+	instrument = strings.ToUpper(instrument)
+	if err := validateInstrument(instrument); err != nil {
+		return nil, err
+	}
+	if lookback <= 0 {
+		return nil, errors.New("lookback must be positive")
+	}
+	g, d := D, 24*time.Hour
+	for _, v := range granularities {
+		if lookback/v.d <= maxCandles {
+			g, d = v.g, v.d
+			break
+		}
+	}
+	end := time.Now()
+	candles, err := c.PollMidpointCandles(instrument, g,
+		StartTime(end.Add(-lookback)), EndTime(end))
+	if err != nil {
+		return nil, err
+	}
+	times := make([]time.Time, len(candles.Candles))
+	for i, candle := range candles.Candles {
+		times[i] = candle.Time.Time
+	}
+	return sessionsFromCandles(times, d), nil
+}
+
+// sessionsFromCandles groups the candles that start at the specified times into sessions.  Each
+// candle spans period d.
+func sessionsFromCandles(times []time.Time, d time.Duration) []Session {
+	gap := minSessionGap
+	if 2*d > gap {
+		gap = 2 * d
+	}
+	sessions := []Session{}
+	for _, t := range times {
+		t = t.UTC()
+		if n := len(sessions); n > 0 && t.Sub(sessions[n-1].End) < gap {
+			sessions[n-1].End = t.Add(d)
+			continue
+		}
+		sessions = append(sessions, Session{Start: t, End: t.Add(d)})
+	}
+	return sessions
+}
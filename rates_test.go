@@ -15,6 +15,17 @@
 package oanda_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/santegoeds/oanda"
+
 	"gopkg.in/check.v1"
 )
 
@@ -24,3 +35,274 @@ func (ts *TestSuite) TestInstruments(c *check.C) {
 	c.Log(instruments)
 	c.Assert(instruments, check.Not(check.HasLen), 0)
 }
+
+func (ts *TestLocalSuite) TestLocalInstruments(c *check.C) {
+	_, err := ts.c.Instruments(nil, nil)
+	c.Assert(err, check.Equals, oanda.ErrNoAccount)
+
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		c.Check(q.Get("accountId"), check.Equals, "1")
+		c.Check(q.Get("instruments"), check.Equals, "EUR_USD")
+		c.Check(q.Get("fields"), check.Equals, "pip,halted")
+		return newResponse(http.StatusOK, `{"instruments": [{"instrument": "EUR_USD",
+			"pip": "0.0001", "halted": true}]}`), nil
+	})
+	info, err := ts.c.Instruments([]string{"eur_usd"},
+		[]oanda.InstrumentField{oanda.PipField, oanda.HaltedField})
+	c.Assert(err, check.IsNil)
+	c.Assert(info["EUR_USD"].Pip, check.Equals, 0.0001)
+	c.Assert(info["EUR_USD"].Halted, check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestCandlesArgs(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		c.Check(q.Get("candleFormat"), check.Equals, "bidask")
+		c.Check(q.Get("count"), check.Equals, "2")
+		c.Check(q.Get("start"), check.Equals, "2014-04-07T00:00:00Z")
+		return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "granularity": "H1",
+			"candles": [{"time": "2014-04-07T01:00:00Z", "openBid": 1.37, "openAsk": 1.3702,
+			"volume": 10, "complete": true}]}`), nil
+	})
+	start := time.Date(2014, 4, 7, 0, 0, 0, 0, time.UTC)
+	candles, err := ts.c.PollBidAskCandles("EUR_USD", oanda.H1, oanda.Count(2),
+		oanda.StartTime(start))
+	c.Assert(err, check.IsNil)
+	c.Assert(candles.Candles, check.HasLen, 1)
+	c.Assert(candles.Candles[0].OpenAsk, check.Equals, 1.3702)
+	c.Assert(candles.Candles[0].Complete, check.Equals, true)
+
+	_, err = ts.c.PollMidpointCandles("EUR_USD", oanda.H1, oanda.Count(2),
+		oanda.StartTime(start), oanda.EndTime(start.Add(time.Hour)))
+	c.Assert(err, check.Equals, oanda.ErrCountWithRange)
+}
+
+func (ts *TestLocalSuite) TestSessionsFromCandles(c *check.C) {
+	// A week of hourly candles for an instrument that does not trade on the Wednesday (holiday)
+	// and on the weekend.
+	start := time.Date(2014, 12, 22, 0, 0, 0, 0, time.UTC)
+	times := []time.Time{}
+	for t := start; t.Before(start.Add(8 * 24 * time.Hour)); t = t.Add(time.Hour) {
+		switch t.Weekday() {
+		case time.Wednesday, time.Saturday, time.Sunday:
+			continue
+		}
+		times = append(times, t)
+	}
+
+	sessions := oanda.SessionsFromCandles(times, time.Hour)
+	c.Log(sessions)
+	c.Assert(sessions, check.HasLen, 3)
+	c.Assert(sessions[0].Start, check.Equals, start)
+	c.Assert(sessions[0].End, check.Equals, start.Add(48*time.Hour))
+	c.Assert(sessions[1].Start, check.Equals, start.Add(72*time.Hour))
+	c.Assert(sessions[1].End, check.Equals, start.Add(120*time.Hour))
+	c.Assert(sessions[2].Start, check.Equals, start.Add(168*time.Hour))
+	c.Assert(sessions[2].End, check.Equals, start.Add(192*time.Hour))
+
+	c.Assert(oanda.SessionsFromCandles(nil, time.Hour), check.HasLen, 0)
+}
+
+func (ts *TestLocalSuite) TestTradingSchedule(c *check.C) {
+	var query url.Values
+	body := `{"instrument": "EUR_USD", "granularity": "H1", "candles": [
+		{"time": "2014-12-22T00:00:00Z", "complete": true},
+		{"time": "2014-12-22T01:00:00Z", "complete": true},
+		{"time": "2014-12-22T05:00:00Z", "complete": true}]}`
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		query = req.URL.Query()
+		return newResponse(http.StatusOK, body), nil
+	})
+
+	sessions, err := ts.c.TradingSchedule("eur_usd", 5000*time.Hour)
+	c.Assert(err, check.IsNil)
+	c.Assert(query.Get("instrument"), check.Equals, "EUR_USD")
+	c.Assert(query.Get("granularity"), check.Equals, "H1")
+	c.Assert(sessions, check.HasLen, 2)
+	c.Assert(sessions[0].End, check.Equals, time.Date(2014, 12, 22, 2, 0, 0, 0, time.UTC))
+
+	// No candles, e.g. of an instrument that did not trade, is no session.
+	body = `{"instrument": "EUR_USD", "granularity": "H1", "candles": []}`
+	sessions, err = ts.c.TradingSchedule("EUR_USD", 100*time.Hour)
+	c.Assert(err, check.IsNil)
+	c.Assert(sessions, check.HasLen, 0)
+
+	body = `{"code": 1, "message": "Invalid value specified for 'start'"}`
+	_, err = ts.c.TradingSchedule("EUR_USD", 100*time.Hour)
+	c.Assert(errors.Is(err, oanda.ErrInvalidArgument), check.Equals, true)
+
+	query = nil
+	_, err = ts.c.TradingSchedule("EUR_USD", 0)
+	c.Assert(err, check.ErrorMatches, "lookback must be positive")
+	_, err = ts.c.TradingSchedule("EURUSD", time.Hour)
+	c.Assert(errors.Is(err, oanda.ErrInvalidInstrument), check.Equals, true)
+	c.Assert(query, check.IsNil)
+}
+
+func (ts *TestLocalSuite) TestCandleMid(c *check.C) {
+	bac := oanda.BidAskCandle{OpenBid: 1.25, OpenAsk: 1.75, HighBid: 2, HighAsk: 2.5,
+		LowBid: 1, LowAsk: 1.5, CloseBid: 1.5, CloseAsk: 2}
+	open, high, low, close := bac.Mid()
+	c.Assert([]float64{open, high, low, close}, check.DeepEquals, []float64{1.5, 2.25, 1.25, 1.75})
+
+	mc := oanda.MidpointCandle{OpenMid: 1.5, HighMid: 2.25, LowMid: 1.25, CloseMid: 1.75}
+	open, high, low, close = mc.Mid()
+	c.Assert([]float64{open, high, low, close}, check.DeepEquals, []float64{1.5, 2.25, 1.25, 1.75})
+
+	tick := oanda.PriceTick{Bid: 1.25, Ask: 1.75}
+	c.Assert(tick.Mid(), check.Equals, 1.5)
+}
+
+func (ts *TestLocalSuite) TestInstrumentCache(c *check.C) {
+	requests := Counter{}
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		n := requests.Inc()
+		c.Assert(req.URL.Query().Get("fields"), check.Matches, ".*precision.*halted.*")
+		return newResponse(http.StatusOK, fmt.Sprintf(`{"instruments": [{"instrument": "EUR_USD",
+			"pip": "0.0001", "precision": 0.00001, "halted": %t}]}`, n > 1)), nil
+	}), oanda.WithInstrumentCacheTTL(time.Hour))
+	client.SelectAccount(1)
+
+	info, ok := client.InstrumentInfo("eur_usd")
+	c.Assert(ok, check.Equals, true)
+	c.Assert(info.Precision, check.Equals, 0.00001)
+	c.Assert(info.Halted, check.Equals, false)
+	_, ok = client.InstrumentInfo("USD_JPY")
+	c.Assert(ok, check.Equals, false)
+	c.Assert(requests.Val(), check.Equals, 1)
+
+	c.Assert(client.RefreshInstruments(1), check.IsNil)
+	c.Assert(requests.Val(), check.Equals, 2)
+	info, _ = client.InstrumentInfo("EUR_USD")
+	c.Assert(info.Halted, check.Equals, true)
+
+	_, err := oanda.NewFxPracticeClient("token", oanda.WithInstrumentCacheTTL(0))
+	c.Assert(err, check.ErrorMatches, "ttl must be positive")
+}
+
+func (ts *TestLocalSuite) TestFormatPrice(c *check.C) {
+	var form url.Values
+	failInstruments := true
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch {
+		case req.URL.Path == "/v1/instruments" && failInstruments:
+			return newResponse(http.StatusInternalServerError, `{"code": 1,
+				"message": "unavailable"}`), nil
+		case req.URL.Path == "/v1/instruments":
+			return newResponse(http.StatusOK, `{"instruments": [
+				{"instrument": "EUR_USD", "precision": 0.00001},
+				{"instrument": "USD_JPY", "precision": 0.001},
+				{"instrument": "DE30_EUR", "precision": 0.1},
+				{"instrument": "XAG_USD", "precision": 0.000001}]}`), nil
+		case req.Method == "GET":
+			c.Errorf("unexpected request for %s", req.URL.Path)
+		}
+		req.ParseForm()
+		form = req.PostForm
+		return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "orderOpened": {"id": 1}}`), nil
+	}))
+	client.SelectAccount(1)
+
+	// Orders are not sent unrounded if the instrument information cannot be loaded.
+	expiry := time.Date(2014, 4, 7, 18, 0, 0, 0, time.UTC)
+	_, err := client.NewOrder(oanda.Limit, oanda.Buy, 1, "EUR_USD", 1.234567, expiry)
+	c.Assert(err, check.ErrorMatches, ".*unavailable.*")
+	c.Assert(form, check.IsNil)
+
+	// The instrument information is loaded for the first order.
+	failInstruments = false
+	_, err = client.NewOrder(oanda.Limit, oanda.Buy, 1, "EUR_USD", 1.234567, expiry)
+	c.Assert(err, check.IsNil)
+	c.Assert(form.Get("price"), check.Equals, "1.23457")
+
+	for _, t := range []struct {
+		instr    string
+		price    float64
+		expected string
+	}{
+		{"EUR_USD", 1.234567, "1.23457"},
+		{"EUR_USD", 1.3, "1.3"},
+		{"USD_JPY", 103.12345, "103.123"},
+		{"DE30_EUR", 9512.06, "9512.1"},
+		{"DE30_EUR", 9511.96, "9512"},
+		{"XAG_USD", 0.0000123, "0.000012"},
+	} {
+		s, err := client.FormatPrice(t.instr, t.price)
+		c.Assert(err, check.IsNil)
+		c.Check(s, check.Equals, t.expected, check.Commentf("%s %f", t.instr, t.price))
+	}
+	_, err = client.FormatPrice("GBP_USD", 1.5)
+	c.Assert(err, check.ErrorMatches, "unknown instrument GBP_USD")
+
+	_, err = client.NewOrder(oanda.Limit, oanda.Buy, 1, "EUR_USD", 1.234567, expiry,
+		oanda.TakeProfit(1.3000004))
+	c.Assert(err, check.IsNil)
+	c.Assert(form.Get("price"), check.Equals, "1.23457")
+	c.Assert(form.Get("takeProfit"), check.Equals, "1.3")
+
+	// Modified orders and trades are rounded to the precision of their instrument, if known.
+	_, err = client.ModifyOrder(5, oanda.Price(103.12345), oanda.StopLoss(102.0004),
+		oanda.Instrument("USD_JPY"))
+	c.Assert(err, check.IsNil)
+	c.Assert(form, check.DeepEquals, url.Values{"price": {"103.123"}, "stopLoss": {"102"}})
+	_, err = client.ModifyTrade(5, oanda.TakeProfit(104.5556), oanda.Instrument("usd_jpy"))
+	c.Assert(err, check.IsNil)
+	c.Assert(form, check.DeepEquals, url.Values{"takeProfit": {"104.556"}})
+	_, err = client.ModifyTrade(5, oanda.TakeProfit(104.5556))
+	c.Assert(err, check.IsNil)
+	c.Assert(form.Get("takeProfit"), check.Equals, "104.5556")
+}
+
+func (ts *TestLocalSuite) TestCandlesRange(c *check.C) {
+	start := time.Date(2014, 4, 4, 12, 0, 0, 0, time.UTC)
+	end := start.Add(20 * time.Hour)
+	// The market is closed for two hours in the second sub-range.
+	gapStart, gapEnd := start.Add(8*time.Hour), start.Add(10*time.Hour)
+	var failStart time.Time
+
+	mtx := sync.Mutex{}
+	requests := 0
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		from, _ := time.Parse(time.RFC3339, q.Get("start"))
+		to, _ := time.Parse(time.RFC3339, q.Get("end"))
+		mtx.Lock()
+		requests++
+		mtx.Unlock()
+		c.Check(to.Sub(from)/(5*time.Second) < 5000, check.Equals, true)
+		if from.Equal(failStart) {
+			return newResponse(http.StatusBadRequest, `{"code": 1, "message": "bad range"}`), nil
+		}
+		candles := []string{}
+		for t := from; !t.After(to); t = t.Add(5 * time.Second) {
+			if !t.Before(gapStart) && t.Before(gapEnd) {
+				continue
+			}
+			candles = append(candles, fmt.Sprintf(`{"time": "%s", "openMid": 1.3}`,
+				t.Format(time.RFC3339)))
+		}
+		return newResponse(http.StatusOK, `{"instrument": "EUR_USD", "granularity": "S5",
+			"candles": [`+strings.Join(candles, ",")+`]}`), nil
+	}))
+
+	candles, err := client.CandlesRange(context.Background(), "EUR_USD", oanda.S5, start, end)
+	c.Assert(err, check.IsNil)
+	c.Assert(requests, check.Equals, 3)
+	c.Assert(candles, check.HasLen, 20*720+1-2*720)
+	c.Assert(candles[0].Time.Equal(start), check.Equals, true)
+	c.Assert(candles[len(candles)-1].Time.Equal(end), check.Equals, true)
+	for i := 1; i < len(candles); i++ {
+		c.Assert(candles[i].Time.After(candles[i-1].Time.Time), check.Equals, true)
+	}
+
+	// The candles of the other sub-ranges are returned with the error.
+	failStart = start.Add(4999 * 5 * time.Second)
+	partial, err := client.CandlesRange(context.Background(), "EUR_USD", oanda.S5, start, end)
+	c.Assert(err, check.ErrorMatches, "candles from 2014-04-04T18:56:35Z to .*bad range.*")
+	_, ok := errors.Unwrap(err).(*oanda.ApiError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(partial, check.HasLen, 5000+4403)
+	c.Assert(partial[5000].Time.Equal(start.Add(49990*time.Second)), check.Equals, true)
+}
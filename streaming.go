@@ -15,13 +15,16 @@
 package oanda
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -44,6 +47,9 @@ type TimedReader struct {
 	Timeout time.Duration
 	io.ReadCloser
 	timer *time.Timer
+
+	// expired is set to 1 when a Read times out.
+	expired int32
 }
 
 // NewTimedReader returns an instance of TimedReader where Read operations time out.
@@ -56,7 +62,10 @@ func NewTimedReader(r io.ReadCloser, timeout time.Duration) *TimedReader {
 
 func (r *TimedReader) Read(p []byte) (int, error) {
 	if r.timer == nil {
-		r.timer = time.AfterFunc(r.Timeout, func() { r.Close() })
+		r.timer = time.AfterFunc(r.Timeout, func() {
+			atomic.StoreInt32(&r.expired, 1)
+			r.Close()
+		})
 	} else {
 		r.timer.Reset(r.Timeout)
 	}
@@ -65,6 +74,29 @@ func (r *TimedReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// timedOut returns true if the ReadCloser was closed because a Read timed out.
+func (r *TimedReader) timedOut() bool {
+	return atomic.LoadInt32(&r.expired) == 1
+}
+
+// HeartbeatTimeoutError is returned when a stream receives no data, not even heartbeats, within
+// the timeout of WithStreamHeartbeatTimeout.
+type HeartbeatTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (he *HeartbeatTimeoutError) Error() string {
+	return fmt.Sprintf("no stream data received for %s", he.Timeout)
+}
+
+// stallTimeout returns the time after which a stream of c without data is considered dead.
+func (c *Client) stallTimeout() time.Duration {
+	if c.streamHeartbeatTimeout > 0 {
+		return c.streamHeartbeatTimeout
+	}
+	return defaultStallTimeout
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 // StreamMessage
 
@@ -129,6 +161,174 @@ func (ss StreamServer) HandleHeartbeats(hbC <-chan time.Time) {
 	}
 }
 
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// DropPolicy
+
+// DropPolicy determines what a stream does with a message when the buffer of its channel is
+// full.  See WithStreamDropPolicy.
+type DropPolicy int
+
+const (
+	// Block waits until the receiver makes room, which stalls the stream.  It is the default.
+	Block DropPolicy = iota
+
+	// DropOldest discards the oldest buffered message to make room for the new one.  Without a
+	// buffer, see WithStreamBuffer, the new message is discarded instead.
+	DropOldest
+
+	// DropNewest discards the new message.
+	DropNewest
+)
+
+// dropCounter delivers the messages of a stream on channel ch according to the DropPolicy of a
+// Client and counts the messages that are dropped.
+type dropCounter[T any] struct {
+	c       *Client
+	path    string
+	ch      chan T
+	dropped int
+}
+
+// deliver sends v on the channel of the stream.  If the channel is full v is dropped, the oldest
+// message is evicted or deliver waits until ctx is done, depending on the DropPolicy.
+func (dc *dropCounter[T]) deliver(ctx context.Context, v T) error {
+	if dc.trySend(v) {
+		return nil
+	}
+	switch dc.c.streamDrop {
+	case DropOldest:
+		for dc.evict() {
+			dc.drop()
+			if dc.trySend(v) {
+				return nil
+			}
+		}
+		dc.drop()
+		return nil
+	case DropNewest:
+		dc.drop()
+		return nil
+	}
+	select {
+	case dc.ch <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (dc *dropCounter[T]) trySend(v T) bool {
+	select {
+	case dc.ch <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// evict removes the oldest message from the channel and reports whether there was one.
+func (dc *dropCounter[T]) evict() bool {
+	select {
+	case <-dc.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (dc *dropCounter[T]) drop() {
+	dc.dropped++
+	if dc.c.streamDropHook != nil {
+		dc.c.streamDropHook(dc.path, dc.dropped)
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// workerGroup
+
+// workerGroup tracks the goroutines of the streams and poll loops of a Client so that Shutdown
+// can stop them and wait for them to end.
+type workerGroup struct {
+	mtx   sync.Mutex
+	wg    sync.WaitGroup
+	stops map[int]func()
+	next  int
+	down  bool
+}
+
+func newWorkerGroup() *workerGroup {
+	return &workerGroup{stops: make(map[int]func())}
+}
+
+// add registers a worker that is ended by stop.  The returned function must be called when the
+// worker has ended.  If the group was shut down stop is called immediately.
+func (g *workerGroup) add(stop func()) (done func()) {
+	if g == nil {
+		return func() {}
+	}
+	g.mtx.Lock()
+	if g.down {
+		g.mtx.Unlock()
+		stop()
+		return func() {}
+	}
+	id := g.next
+	g.next++
+	g.stops[id] = stop
+	g.wg.Add(1)
+	g.mtx.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mtx.Lock()
+			delete(g.stops, id)
+			g.mtx.Unlock()
+			g.wg.Done()
+		})
+	}
+}
+
+// track returns a context derived from ctx that is cancelled on shutdown.  The returned function
+// must be called when the worker that uses the context has ended.
+func (g *workerGroup) track(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := g.add(cancel)
+	return ctx, func() {
+		cancel()
+		done()
+	}
+}
+
+// shutdown stops all workers and waits until they have ended or ctx is done.
+func (g *workerGroup) shutdown(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mtx.Lock()
+	g.down = true
+	stops := make([]func(), 0, len(g.stops))
+	for _, stop := range g.stops {
+		stops = append(stops, stop)
+	}
+	g.mtx.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+
+	doneC := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(doneC)
+	}()
+	select {
+	case <-doneC:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 // messageServer
 
@@ -137,7 +337,18 @@ type messageServer struct {
 	c      *Client
 	mtx    sync.Mutex
 	req    *http.Request
+	rdr    io.ReadCloser
 	runFlg bool
+
+	// stopFlg is set by Stop; unlike runFlg it is not cleared when the stream ends by itself.
+	stopFlg bool
+
+	// connect opens the stream from which messages are read.  An error of io.EOF indicates
+	// that the stream is exhausted and should not be reopened.
+	connect func() (io.ReadCloser, error)
+
+	// If pace is not nil it is invoked before each message is dispatched.
+	pace func(StreamMessage)
 }
 
 // newMessageServer returns a new instance of messageServer that forwards each message and
@@ -148,14 +359,44 @@ func (c *Client) newMessageServer(req *http.Request, sh StreamHandler) (*message
 		c:   c,
 		req: req,
 	}
+	s.connect = func() (io.ReadCloser, error) {
+		rsp, err := s.c.Do(s.req)
+		if err != nil {
+			return nil, err
+		}
+		return NewTimedReader(rsp.Body, s.c.stallTimeout()), nil
+	}
 	return &s, nil
 }
 
+// newReplayServer returns a new instance of messageServer that forwards each message and
+// heartbeat that is read from r to the specified StreamHandler.
+func newReplayServer(r io.Reader, sh StreamHandler) *messageServer {
+	s := messageServer{
+		sh: sh,
+	}
+	s.connect = func() (io.ReadCloser, error) {
+		if r == nil {
+			return nil, io.EOF
+		}
+		rdr := ioutil.NopCloser(r)
+		if rc, ok := r.(io.ReadCloser); ok {
+			rdr = rc
+		}
+		r = nil
+		return rdr, nil
+	}
+	return &s
+}
+
 // ConnectAndDispatch
 func (s *messageServer) ConnectAndDispatch() (err error) {
 	if err = s.initServer(); err != nil {
 		return
 	}
+	if s.c != nil {
+		defer s.c.workers.add(s.Stop)()
+	}
 	err = s.readMessages()
 
 	s.mtx.Lock()
@@ -169,9 +410,17 @@ func (s *messageServer) Stop() {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 	s.runFlg = false
+	s.stopFlg = true
 	cancelRequest(s)
 }
 
+// stopped returns true if the messageServer was stopped with Stop.
+func (s *messageServer) stopped() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.stopFlg
+}
+
 func (s *messageServer) initServer() error {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -179,6 +428,7 @@ func (s *messageServer) initServer() error {
 		return errors.New("server is already running")
 	}
 	s.runFlg = true
+	s.stopFlg = false
 	return nil
 }
 
@@ -197,12 +447,15 @@ func (s *messageServer) readMessages() error {
 			s.mtx.Lock()
 			runFlg := s.runFlg
 			if runFlg {
-				rsp, err := s.c.Do(s.req)
-				if err == nil {
-					rdr = NewTimedReader(rsp.Body, defaultStallTimeout)
-				}
+				rdr, err = s.connect()
+				s.rdr = rdr
+			} else {
+				err = nil
 			}
 			s.mtx.Unlock()
+			if err == io.EOF {
+				return nil, nil
+			}
 			if !runFlg || rdr != nil || d >= maxDelay {
 				break
 			}
@@ -219,8 +472,10 @@ func (s *messageServer) readMessages() error {
 		}
 		dec := json.NewDecoder(rdr)
 
-		msg := StreamMessage{}
+		// lastHb is the time of the last heartbeat; duplicate heartbeats are not forwarded.
+		var lastHb time.Time
 		for {
+			msg := StreamMessage{}
 			err = dec.Decode(&msg)
 			if err != nil {
 				if _, ok := err.(*ApiError); ok {
@@ -229,18 +484,30 @@ func (s *messageServer) readMessages() error {
 				}
 				break
 			}
+			if msg.Type == "" {
+				// Empty messages carry no data.
+				continue
+			}
+			if s.pace != nil {
+				s.pace(msg)
+			}
+			if s.stopped() {
+				// A reader that cannot be closed, e.g. of a replay, is abandoned after Stop.
+				break
+			}
 
 			switch msg.Type {
 			default:
 				msgC <- msg
 			case "heartbeat":
 				v := struct {
-					Time time.Time `json:"time"`
+					Time Time `json:"time"`
 				}{}
 				if err := json.Unmarshal(msg.RawMessage, &v); err != nil {
 					// FIXME: log error
-				} else {
-					hbC <- v.Time
+				} else if !v.Time.Equal(lastHb) {
+					lastHb = v.Time.Time
+					hbC <- v.Time.Time
 				}
 			case "disconnect":
 				apiErr := ApiError{}
@@ -258,15 +525,148 @@ func (s *messageServer) readMessages() error {
 	}
 }
 
+// ReconnectError is sent on the error channel of a stream for every attempt to reopen the stream.
+// See WithStreamReconnect.
+type ReconnectError struct {
+	// Attempt is the number of the consecutive attempt, starting at 1.
+	Attempt int
+
+	// Err is the error that ended the previous connection, or io.EOF.
+	Err error
+}
+
+// Error implements the error interface.
+func (re *ReconnectError) Error() string {
+	return fmt.Sprintf("reconnecting stream (attempt %d): %s", re.Attempt, re.Err)
+}
+
+// Unwrap returns the error that ended the previous connection.
+func (re *ReconnectError) Unwrap() error {
+	return re.Err
+}
+
+// runStream reads the stream of req with readStream and reopens the stream as configured with
+// WithStreamReconnect.  ReconnectErrors and the error that ends the stream, if any, are sent on
+// errC.
+func (c *Client) runStream(ctx context.Context, req *http.Request, errC chan<- error,
+	fn func(StreamMessage) error) {
+
+	sendErr := func(err error) bool {
+		select {
+		case errC <- err:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	attempt := 0
+	for {
+		var fnErr error
+		err := c.readStream(ctx, req, func(msg StreamMessage) error {
+			attempt = 0
+			fnErr = fn(msg)
+			return fnErr
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if c.streamReconnects == 0 || fnErr != nil || !reconnectable(err) {
+			if err != nil {
+				sendErr(err)
+			}
+			return
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		if attempt == c.streamReconnects {
+			sendErr(fmt.Errorf("stream failed after %d reconnect attempts: %s", attempt, err))
+			return
+		}
+		attempt++
+		if !sendErr(&ReconnectError{attempt, err}) {
+			return
+		}
+		select {
+		case <-time.After(backoffDelay(c.streamBackoff, attempt)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconnectable returns true if a stream that ended with err may be reopened.  Errors that are
+// reported by the Oanda servers are not retried.
+func reconnectable(err error) bool {
+	switch err.(type) {
+	case *ApiError, *HTTPError:
+		return false
+	}
+	return true
+}
+
+// readStream opens the stream of req and invokes fn for every message, including heartbeats,
+// until the stream ends, fn returns an error or ctx is done.
+func (c *Client) readStream(ctx context.Context, req *http.Request,
+	fn func(StreamMessage) error) error {
+
+	rsp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, maxErrorBody))
+		apiErr := ApiError{}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != 0 {
+			return &apiErr
+		}
+		return newHTTPError(rsp.StatusCode, body)
+	}
+
+	rdr := NewTimedReader(rsp.Body, c.stallTimeout())
+	dec := json.NewDecoder(rdr)
+	for {
+		msg := StreamMessage{}
+		if err = dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if rdr.timedOut() && ctx.Err() == nil {
+				return &HeartbeatTimeoutError{rdr.Timeout}
+			}
+			return contextError(ctx, err)
+		}
+		if msg.Type == "" {
+			continue
+		}
+		if msg.Type == "disconnect" {
+			apiErr := ApiError{}
+			if err = json.Unmarshal(msg.RawMessage, &apiErr); err != nil {
+				return err
+			}
+			return &apiErr
+		}
+		if err = fn(msg); err != nil {
+			return err
+		}
+	}
+}
+
 func cancelRequest(s *messageServer) {
 	if s.req != nil {
 		s.c.CancelRequest(s.req)
+	} else if s.rdr != nil {
+		s.rdr.Close()
 	}
 }
 
+// useStreamHost directs req to the stream server of the environment.  Custom hosts, see Host,
+// serve streams themselves.
 func useStreamHost(req *http.Request) {
 	u := req.URL
-	parts := strings.Split(u.Host, "-")
-	parts[0] = "stream"
-	u.Host = strings.Join(parts, "-")
+	if strings.HasPrefix(u.Host, "api-") {
+		u.Host = "stream-" + strings.TrimPrefix(u.Host, "api-")
+	}
 }
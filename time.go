@@ -0,0 +1,53 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time is a time.Time that is decoded from either of the date formats of the Oanda servers:
+// an RFC3339 string or the number of microseconds since the UNIX epoch, which the servers
+// return if the Client is created with WithDateFormat(DateFormatUNIX).
+type Time struct {
+	time.Time
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (t *Time) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	if s != "" && strings.Trim(s, "0123456789") == "" {
+		usec, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		t.Time = time.Unix(0, usec*int64(time.Microsecond)).UTC()
+		return nil
+	}
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+	t.Time = v
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+func (ts *TestLocalSuite) TestTime(c *check.C) {
+	expected := time.Date(2014, 4, 7, 18, 31, 5, 123456000, time.UTC)
+	for _, data := range []string{
+		`"2014-04-07T18:31:05.123456Z"`,
+		`"1396895465123456"`,
+		`1396895465123456`,
+	} {
+		var t oanda.Time
+		c.Assert(json.Unmarshal([]byte(data), &t), check.IsNil)
+		c.Assert(t.Equal(expected), check.Equals, true, check.Commentf("%s", data))
+	}
+	var t oanda.Time
+	c.Assert(json.Unmarshal([]byte(`"yesterday"`), &t), check.NotNil)
+}
+
+func (ts *TestLocalSuite) TestUnixDateFormat(c *check.C) {
+	client, err := oanda.NewFxPracticeClient("token", oanda.WithDateFormat(oanda.DateFormatUNIX))
+	c.Assert(err, check.IsNil)
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.Header.Get("X-Accept-Datetime-Format"), check.Equals, "UNIX")
+		return newResponse(http.StatusOK, `{"prices": [{"instrument": "EUR_USD",
+			"time": "1396895465123456", "bid": 1.3706, "ask": 1.37063}]}`), nil
+	})
+	prices, err := client.PollPrices("EUR_USD")
+	c.Assert(err, check.IsNil)
+	c.Assert(prices["EUR_USD"].Time.Equal(time.Unix(1396895465, 123456000)), check.Equals, true)
+
+	unix, err := oanda.NewFxPracticeClient("token", oanda.WithDateFormat("unix"))
+	c.Assert(err, check.IsNil)
+	c.Assert(unix, check.NotNil)
+	_, err = oanda.NewFxPracticeClient("token", oanda.WithDateFormat("RFC1123"))
+	c.Assert(err, check.ErrorMatches, `unknown date format "RFC1123"`)
+}
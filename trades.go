@@ -15,35 +15,49 @@
 package oanda
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 )
 
+// NewTradeArg represents an optional argument for method NewTrade.  Types that implement the
+// interface are LowerBound, UpperBound, StopLoss, TakeProfit, TrailingStop, Tag and SignedUnits.
 type NewTradeArg interface {
-	applyNewTradeArg(url.Values)
+	applyNewTradeArg(*orderForm)
 }
 
-func (lb LowerBound) applyNewTradeArg(v url.Values) {
-	optionalArgs(v).SetFloat("lowerBound", float64(lb))
+func (lb LowerBound) applyNewTradeArg(f *orderForm) {
+	f.LowerBound = float64(lb)
 }
 
-func (ub UpperBound) applyNewTradeArg(v url.Values) {
-	optionalArgs(v).SetFloat("upperBound", float64(ub))
+func (ub UpperBound) applyNewTradeArg(f *orderForm) {
+	f.UpperBound = float64(ub)
 }
 
-func (sl StopLoss) applyNewTradeArg(v url.Values) {
-	optionalArgs(v).SetFloat("stopLoss", float64(sl))
+func (sl StopLoss) applyNewTradeArg(f *orderForm) {
+	f.StopLoss = float64(sl)
 }
 
-func (tp TakeProfit) applyNewTradeArg(v url.Values) {
-	optionalArgs(v).SetFloat("takeProfit", float64(tp))
+func (tp TakeProfit) applyNewTradeArg(f *orderForm) {
+	f.TakeProfit = float64(tp)
 }
 
-func (ts TrailingStop) applyNewTradeArg(v url.Values) {
-	optionalArgs(v).SetFloat("trailingStop", float64(ts))
+func (ts TrailingStop) applyNewTradeArg(f *orderForm) {
+	f.TrailingStop = float64(ts)
+}
+
+func (t Tag) applyNewTradeArg(f *orderForm) {
+	f.Tag = string(t)
+}
+
+func (su SignedUnits) applyNewTradeArg(f *orderForm) {
+	su.applyNewOrderArg(f)
 }
 
 type TradesArg interface {
@@ -66,90 +80,175 @@ func (ids Ids) applyTradesArg(v url.Values) {
 	optionalArgs(v).SetIntArray("ids", []int(ids))
 }
 
+// modifyTradeForm holds the form values of a request to modify a trade.  Fields that are nil
+// are left unchanged.
+type modifyTradeForm struct {
+	StopLoss     *float64 `oanda:"stopLoss"`
+	TakeProfit   *float64 `oanda:"takeProfit"`
+	TrailingStop *float64 `oanda:"trailingStop"`
+
+	instrument string
+}
+
+// ModifyTradeArg represents an optional argument for method ModifyTrade.  Types that implement
+// the interface are StopLoss, TakeProfit, TrailingStop and Instrument.
 type ModifyTradeArg interface {
-	applyModifyTradeArg(url.Values)
+	applyModifyTradeArg(*modifyTradeForm)
+}
+
+func (i Instrument) applyModifyTradeArg(f *modifyTradeForm) {
+	f.instrument = string(i)
 }
 
-func (sl StopLoss) applyModifyTradeArg(v url.Values) {
-	optionalArgs(v).SetFloat("stopLoss", float64(sl))
+func (sl StopLoss) applyModifyTradeArg(f *modifyTradeForm) {
+	v := float64(sl)
+	f.StopLoss = &v
 }
 
-func (tp TakeProfit) applyModifyTradeArg(v url.Values) {
-	optionalArgs(v).SetFloat("takeProfit", float64(tp))
+func (tp TakeProfit) applyModifyTradeArg(f *modifyTradeForm) {
+	v := float64(tp)
+	f.TakeProfit = &v
 }
 
-func (ts TrailingStop) applyModifyTradeArg(v url.Values) {
-	optionalArgs(v).SetFloat("trailingStop", float64(ts))
+func (ts TrailingStop) applyModifyTradeArg(f *modifyTradeForm) {
+	v := float64(ts)
+	f.TrailingStop = &v
 }
 
 // Trade represents an open Oanda trade.
 type Trade struct {
-	TradeId        int       `json:"id"`
-	Units          int       `json:"units"`
-	Instrument     string    `json:"instrument"`
-	Side           string    `json:"side"`
-	Price          float64   `json:"price"`
-	Time           time.Time `json:"time"`
-	StopLoss       float64   `json:"stopLoss"`
-	TakeProfit     float64   `json:"takeProfit"`
-	TrailingStop   float64   `json:"trailingStop"`
-	TrailingAmount float64   `json:"trailingAmount"`
+	TradeId        int     `json:"id"`
+	Units          int     `json:"units"`
+	Instrument     string  `json:"instrument"`
+	Side           string  `json:"side"`
+	Price          Decimal `json:"price"`
+	Time           Time    `json:"time"`
+	StopLoss       Decimal `json:"stopLoss"`
+	TakeProfit     Decimal `json:"takeProfit"`
+	TrailingStop   Decimal `json:"trailingStop"`
+	TrailingAmount Decimal `json:"trailingAmount"`
+
+	// UnrealizedPl is the unrealized profit or loss of the trade in the account currency.  Not
+	// all environments include it in their response. See Client method TradesWithPnL().
+	UnrealizedPl float64 `json:"unrealizedPL"`
+
+	// PriceString holds Price exactly as it was represented by the Oanda servers.
+	PriceString string `json:"-"`
+
+	hasUnrealizedPl bool
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (t *Trade) UnmarshalJSON(data []byte) error {
+	type trade Trade
+	if err := json.Unmarshal(data, (*trade)(t)); err != nil {
+		return err
+	}
+	upl := ""
+	err := numberText(data, map[string]*string{
+		"price":        &t.PriceString,
+		"unrealizedPL": &upl,
+	})
+	t.hasUnrealizedPl = upl != ""
+	return err
 }
 
 // String implements the Stringer interface.
 func (t *Trade) String() string {
-	return fmt.Sprintf("Trade{TradeId: %d, Side: %s, Units: %d, Instrument: %s, Price: %f}",
+	return fmt.Sprintf("Trade{TradeId: %d, Side: %s, Units: %d, Instrument: %s, Price: %s}",
 		t.TradeId, t.Side, t.Units, t.Instrument, t.Price)
 }
 
 type Trades []Trade
 
-// NewTrade submits a MarketOrder request to the Oanda servers. Supported OptionalArgs are
-// UpperBound(), LowerBound(), StopLoss(), TakeProfit() and TrailingStop().
+// MaxTradeId returns the highest trade id in ts, or 0 if ts is empty.
+func (ts Trades) MaxTradeId() int {
+	maxId := 0
+	for i := range ts {
+		if ts[i].TradeId > maxId {
+			maxId = ts[i].TradeId
+		}
+	}
+	return maxId
+}
+
+// MinTradeId returns the lowest trade id in ts, or 0 if ts is empty.  Client method Trades()
+// returns the most recent trades first, so MaxId(ts.MinTradeId() - 1) requests the next page of
+// older trades.
+func (ts Trades) MinTradeId() int {
+	minId := 0
+	for i := range ts {
+		if minId == 0 || ts[i].TradeId < minId {
+			minId = ts[i].TradeId
+		}
+	}
+	return minId
+}
+
+// NewTrade submits a MarketOrder request to the Oanda servers.  It is the only way to submit a
+// single market order; NewOrder rejects them and CreateOrders submits specs of type Market with
+// NewTrade.  Supported OptionalArgs are UpperBound(), LowerBound(), StopLoss(), TakeProfit(),
+// TrailingStop(), Tag() and SignedUnits().
 func (c *Client) NewTrade(side TradeSide, units int, instrument string,
 	args ...NewTradeArg) (*Trade, error) {
 
 	instrument = strings.ToUpper(instrument)
-
-	data := url.Values{
-		"type":       {"market"},
-		"side":       {string(side)},
-		"units":      {strconv.Itoa(units)},
-		"instrument": {instrument},
+	if err := validateInstrument(instrument); err != nil {
+		return nil, err
 	}
 
+	form := orderForm{
+		Type:       "market",
+		Side:       string(side),
+		Units:      units,
+		Instrument: instrument,
+	}
 	for _, arg := range args {
-		arg.applyNewTradeArg(data)
+		arg.applyNewTradeArg(&form)
+	}
+	if err := applySignedUnits(&form); err != nil {
+		return nil, err
+	}
+	if err := checkBounds(&form); err != nil {
+		return nil, err
+	}
+	if err := c.roundForm(&form); err != nil {
+		return nil, err
+	}
+	data, err := formEncodeDate(&form, c.dateFormat())
+	if err != nil {
+		return nil, err
 	}
 
 	// FIXME: Replace this with a TradeCreatedResponse that mimics the structure that is actually
 	// returned.
 	t := &Trade{
-		Side:       string(side),
-		Units:      units,
+		Side:       form.Side,
+		Units:      form.Units,
 		Instrument: instrument,
 	}
 
 	rspData := struct {
 		ApiError
-		Instrument   string    `json:"instrument"`
-		Time         time.Time `json:"time"`
-		Price        float64   `json:"price"`
-		TradeOpened  *Trade    `json:"tradeOpened"`
-		TradeReduced *Trade    `json:"tradeReduced"`
+		Instrument   string      `json:"instrument"`
+		Time         Time        `json:"time"`
+		Price        json.Number `json:"price"`
+		TradeOpened  *Trade      `json:"tradeOpened"`
+		TradeReduced *Trade      `json:"tradeReduced"`
 	}{
 		TradeOpened:  t,
 		TradeReduced: t,
 	}
 
-	urlStr := fmt.Sprintf("/v1/accounts/%d/orders", c.accountId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/orders", c.SelectedAccount())
 	if err := requestAndDecode(c, "POST", urlStr, data, &rspData); err != nil {
 		return nil, err
 	}
 
 	t.Instrument = rspData.Instrument
 	t.Time = rspData.Time
-	t.Price = rspData.Price
+	_ = t.Price.UnmarshalJSON([]byte(rspData.Price))
+	t.PriceString = rspData.Price.String()
 
 	return t, nil
 }
@@ -160,7 +259,7 @@ func (c *Client) Trade(tradeId int) (*Trade, error) {
 		ApiError
 		Trade
 	}{}
-	urlStr := fmt.Sprintf("/v1/accounts/%d/trades/%d", c.accountId, tradeId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/trades/%d", c.SelectedAccount(), tradeId)
 	if err := getAndDecode(c, urlStr, &t); err != nil {
 		return nil, err
 	}
@@ -170,7 +269,7 @@ func (c *Client) Trade(tradeId int) (*Trade, error) {
 // Trades returns a list of open trades that match the optional arguments.  Supported
 // optional arguments are MaxId(), Count(), Instrument() and Ids().
 func (c *Client) Trades(args ...TradesArg) (Trades, error) {
-	urlStr := fmt.Sprintf("/v1/accounts/%d/trades", c.accountId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/trades", c.SelectedAccount())
 
 	u, err := url.Parse(urlStr)
 	if err != nil {
@@ -194,19 +293,30 @@ func (c *Client) Trades(args ...TradesArg) (Trades, error) {
 	return rspData.Trades, nil
 }
 
-// ModifyTrade modifies an open trade.  Supported optional arguments are StopLoss(),
-// TakeProfit(), TrailingStop()
+// ModifyTrade modifies an open trade and returns the updated trade.  Supported arguments are
+// StopLoss(), TakeProfit(), TrailingStop() and Instrument().  Only the specified fields are sent,
+// and a zero value removes the stop loss, take profit or trailing stop of the trade.  If the
+// instrument of the trade is passed with Instrument() prices are rounded to its precision.
 func (c *Client) ModifyTrade(tradeId int, arg ModifyTradeArg, args ...ModifyTradeArg) (*Trade, error) {
-	data := url.Values{}
-	arg.applyModifyTradeArg(data)
+	form := modifyTradeForm{}
+	arg.applyModifyTradeArg(&form)
 	for _, arg := range args {
-		arg.applyModifyTradeArg(data)
+		arg.applyModifyTradeArg(&form)
+	}
+	if form.instrument != "" {
+		if err := c.roundPrices(form.instrument, form.StopLoss, form.TakeProfit); err != nil {
+			return nil, err
+		}
+	}
+	data, err := formEncodeDate(&form, c.dateFormat())
+	if err != nil {
+		return nil, err
 	}
 	t := struct {
 		ApiError
 		Trade
 	}{}
-	urlStr := fmt.Sprintf("/v1/accounts/%d/trades/%d", c.accountId, tradeId)
+	urlStr := fmt.Sprintf("/v1/accounts/%d/trades/%d", c.SelectedAccount(), tradeId)
 	if err := requestAndDecode(c, "PATCH", urlStr, data, &t); err != nil {
 		return nil, err
 	}
@@ -215,23 +325,329 @@ func (c *Client) ModifyTrade(tradeId int, arg ModifyTradeArg, args ...ModifyTrad
 }
 
 type CloseTradeResponse struct {
-	TransactionId int       `json:"id"`
-	Price         float64   `json:"price"`
-	Instrument    string    `json:"instrument"`
-	Profit        float64   `json:"profit"`
-	Side          string    `json:"side"`
-	Time          time.Time `json:"time"`
+	TransactionId int     `json:"id"`
+	Price         float64 `json:"price"`
+	Instrument    string  `json:"instrument"`
+	Profit        float64 `json:"profit"`
+	Side          string  `json:"side"`
+	Time          Time    `json:"time"`
 }
 
-// CloseTrade closes an open trade.
-func (c *Client) CloseTrade(tradeId int) (*CloseTradeResponse, error) {
+// ErrUnitsExceeded is returned by CloseTrade() when more units are to be closed than the trade
+// has open.
+var ErrUnitsExceeded = errors.New("units exceed the open units of the trade")
+
+type closeTradeArgs struct {
+	units  int
+	verify bool
+	trade  *Trade
+}
+
+// CloseTradeArg represents an optional argument for method CloseTrade.  Types that implement the
+// interface are Units and VerifyUnits.
+type CloseTradeArg interface {
+	applyCloseTradeArg(*closeTradeArgs)
+}
+
+func (u Units) applyCloseTradeArg(args *closeTradeArgs) {
+	args.units = int(u)
+}
+
+// VerifyUnits is an optional argument for Client method CloseTrade() that verifies that the Units
+// to close do not exceed the open units of the trade before the trade is closed.  Trade is used
+// for verification if it is not nil.  Otherwise the trade is retrieved first, which costs an
+// additional request.
+type VerifyUnits struct {
+	Trade *Trade
+}
+
+func (vu VerifyUnits) applyCloseTradeArg(args *closeTradeArgs) {
+	args.verify = true
+	args.trade = vu.Trade
+}
+
+// CloseTrade closes an open trade.  Supported optional arguments are Units(), to close only part
+// of the trade, and VerifyUnits().
+func (c *Client) CloseTrade(tradeId int, args ...CloseTradeArg) (*CloseTradeResponse, error) {
+	cta := closeTradeArgs{}
+	for _, arg := range args {
+		arg.applyCloseTradeArg(&cta)
+	}
+	if cta.units < 0 {
+		return nil, fmt.Errorf("invalid number of units %d", cta.units)
+	}
+	if cta.verify && cta.units > 0 {
+		t := cta.trade
+		if t == nil {
+			var err error
+			if t, err = c.Trade(tradeId); err != nil {
+				return nil, err
+			}
+		}
+		if cta.units > t.Units {
+			return nil, ErrUnitsExceeded
+		}
+	}
+
+	u, err := url.Parse(fmt.Sprintf("/v1/accounts/%d/trades/%d", c.SelectedAccount(), tradeId))
+	if err != nil {
+		return nil, err
+	}
+	if cta.units > 0 {
+		q := u.Query()
+		optionalArgs(q).SetInt("units", cta.units)
+		u.RawQuery = q.Encode()
+	}
+
 	ctr := struct {
 		ApiError
 		CloseTradeResponse
 	}{}
-	urlStr := fmt.Sprintf("/v1/accounts/%d/trades/%d", c.accountId, tradeId)
-	if err := requestAndDecode(c, "DELETE", urlStr, nil, &ctr); err != nil {
+	if err = requestAndDecode(c, "DELETE", u.String(), nil, &ctr); err != nil {
 		return nil, err
 	}
 	return &ctr.CloseTradeResponse, nil
 }
+
+// TradesWithPnL returns the open trades of the selected account with their unrealized profit or
+// loss in the account currency.  If the Oanda servers do not report the unrealized profit or
+// loss of a trade it is computed from the current prices.
+func (c *Client) TradesWithPnL() (Trades, error) {
+	trades, err := c.Trades()
+	if err != nil {
+		return nil, err
+	}
+	missing := false
+	for _, t := range trades {
+		missing = missing || !t.hasUnrealizedPl
+	}
+	if !missing {
+		return trades, nil
+	}
+
+	acc, err := c.Account(c.SelectedAccount())
+	if err != nil {
+		return nil, err
+	}
+	instruments, err := c.Instruments(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	needed := make(map[string]bool)
+	for _, t := range trades {
+		needed[t.Instrument] = true
+		if _, quote := splitInstrument(t.Instrument); quote != acc.Currency {
+			instr, _, err := conversionInstrument(quote, acc.Currency, instruments)
+			if err != nil {
+				return nil, err
+			}
+			needed[instr] = true
+		}
+	}
+	instrs := make([]string, 0, len(needed))
+	for instr := range needed {
+		instrs = append(instrs, instr)
+	}
+	prices, err := c.PollPrices(instrs[0], instrs[1:]...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range trades {
+		t := &trades[i]
+		if t.hasUnrealizedPl {
+			continue
+		}
+		_, quote := splitInstrument(t.Instrument)
+		rate, err := conversionRate(quote, acc.Currency, instruments, prices)
+		if err != nil {
+			return nil, err
+		}
+		t.UnrealizedPl = unrealizedPl(t, prices[t.Instrument], rate)
+	}
+	return trades, nil
+}
+
+// unrealizedPl returns the profit or loss of closing trade t at the prices of tick. Rate converts
+// the quote currency of the instrument into the account currency.
+func unrealizedPl(t *Trade, tick PriceTick, rate float64) float64 {
+	price := t.Price.Float64()
+	diff := tick.Bid - price
+	if t.Side == string(Sell) {
+		diff = price - tick.Ask
+	}
+	return diff * float64(t.Units) * rate
+}
+
+// TradeUpdate is sent by Client method WatchTrade() when a trade changes or closes.
+type TradeUpdate struct {
+	// Trade is the state of the trade.  For the final update it is the last state that was
+	// received before the trade was closed, or nil if the trade was never received.
+	Trade *Trade
+
+	// Closed is true for the final update after the trade was closed.
+	Closed bool
+
+	// Closing is the transaction that closed the trade, e.g. a *TradeCloseEvent of type
+	// STOP_LOSS_FILLED.  It is nil if Closed is false or if the transaction was not found among
+	// the most recent transactions of the account.
+	Closing Event
+}
+
+// Reason returns the type of the transaction that closed the trade, e.g. "TAKE_PROFIT_FILLED",
+// or "" if it is not known.
+func (tu *TradeUpdate) Reason() string {
+	if tu.Closing == nil {
+		return ""
+	}
+	return tu.Closing.Type()
+}
+
+// WatchTrade polls trade tradeId of account accountId every interval until the trade is closed or
+// ctx is done.  An update is sent on the TradeUpdate channel when the trade is first received and
+// after every change, e.g. of its stop loss; polls that return unchanged data, also those that
+// are answered with 304 (Not Modified), are skipped.  When the trade no longer exists a final
+// update with Closed set is sent and both channels are closed.  Errors are sent on the error
+// channel and do not end the loop.  For account id 0 ErrNoAccount is sent and nothing is polled.
+func (c *Client) WatchTrade(ctx context.Context, accountId, tradeId int,
+	interval time.Duration) (<-chan TradeUpdate, <-chan error) {
+
+	updC := make(chan TradeUpdate)
+	errC := make(chan error, 1)
+
+	ac := c.WithAccount(accountId)
+	req, err := ac.NewRequest("GET", fmt.Sprintf("/v1/accounts/%d/trades/%d", accountId, tradeId),
+		nil)
+	if err == nil && accountId <= 0 {
+		err = ErrNoAccount
+	}
+	if err != nil {
+		errC <- err
+		close(errC)
+		close(updC)
+		return updC, errC
+	}
+
+	ctx, done := c.workers.track(ctx)
+	pr := &PollRequest{c: ac, req: req}
+	rspC, pollErrC := pr.PollLoop(ctx, interval)
+	go func() {
+		defer done()
+		defer close(updC)
+		defer close(errC)
+
+		sendErr := func(err error) bool {
+			select {
+			case errC <- err:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		var last *Trade
+		for {
+			select {
+			case err, ok := <-pollErrC:
+				if !ok || !sendErr(err) {
+					return
+				}
+				continue
+			case rsp, ok := <-rspC:
+				if !ok {
+					return
+				}
+				t, err := decodeTradeResponse(rsp)
+				if err != nil {
+					if !sendErr(err) {
+						return
+					}
+					continue
+				}
+				update := TradeUpdate{Trade: t}
+				if t == nil {
+					update = TradeUpdate{Trade: last, Closed: true}
+					if update.Closing, err = ac.tradeClosing(tradeId); err != nil && !sendErr(err) {
+						return
+					}
+				} else if last != nil && *t == *last {
+					continue
+				}
+				select {
+				case updC <- update:
+				case <-ctx.Done():
+					return
+				}
+				if update.Closed {
+					return
+				}
+				last = t
+			}
+		}
+	}()
+	return updC, errC
+}
+
+// decodeTradeResponse decodes a response of the trade endpoint.  The Trade is nil if the trade
+// does not exist.
+func decodeTradeResponse(rsp *http.Response) (*Trade, error) {
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	t := struct {
+		ApiError
+		Trade
+	}{}
+	if err = decodeResponse(rsp, body, &t); err != nil {
+		return nil, err
+	}
+	return &t.Trade, nil
+}
+
+// tradeClosing returns the transaction that closed trade tradeId if it is among the most recent
+// transactions of the selected account.
+func (c *Client) tradeClosing(tradeId int) (Event, error) {
+	trans, err := c.Transactions(Count(maxTransactionCount))
+	if err != nil {
+		return nil, err
+	}
+	for i := range trans {
+		closes, err := closesTrade(&trans[i], tradeId)
+		if err != nil {
+			return nil, err
+		}
+		if closes {
+			return trans[i].AsEvent()
+		}
+	}
+	return nil, nil
+}
+
+// closesTrade returns true if transaction t closes trade tradeId, either as the transaction of its
+// stop loss, take profit or trailing stop or because an opposing order closed it.
+func closesTrade(t *Transaction, tradeId int) (bool, error) {
+	if len(t.RawMessage) == 0 {
+		return false, nil
+	}
+	v := struct {
+		TradeId      int                  `json:"tradeId"`
+		TradesClosed []evtTradeDetailData `json:"tradesClosed"`
+	}{}
+	if err := json.Unmarshal(t.RawMessage, &v); err != nil {
+		return false, err
+	}
+	if closeTypes[t.Type] && v.TradeId == tradeId {
+		return true, nil
+	}
+	for _, closed := range v.TradesClosed {
+		if closed.TradeId == tradeId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
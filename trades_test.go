@@ -15,6 +15,10 @@
 package oanda_test
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
 	"time"
 
 	"github.com/santegoeds/oanda"
@@ -27,13 +31,13 @@ func (ts *TestSuite) TestTradeApi(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Log(t)
 	c.Assert(t.TradeId, check.Not(check.Equals), 0)
-	c.Assert(t.Price, check.Not(check.Equals), 0.0)
+	c.Assert(t.Price, check.Not(check.Equals), oanda.Decimal{})
 	c.Assert(t.Instrument, check.Equals, "EUR_USD")
 	c.Assert(t.Side, check.Equals, string(oanda.Buy))
 	c.Assert(t.Units, check.Equals, 2)
-	c.Assert(t.StopLoss, check.Equals, 0.5)
-	c.Assert(t.TakeProfit, check.Equals, 3.0)
-	c.Assert(t.TrailingStop, check.Equals, 0.0)
+	c.Assert(t.StopLoss, check.Equals, oanda.NewDecimal(0.5))
+	c.Assert(t.TakeProfit, check.Equals, oanda.NewDecimal(3.0))
+	c.Assert(t.TrailingStop, check.Equals, oanda.Decimal{})
 	c.Assert(t.Time.Before(time.Now()), check.Equals, true)
 
 	dup, err := ts.c.Trade(t.TradeId)
@@ -46,11 +50,11 @@ func (ts *TestSuite) TestTradeApi(c *check.C) {
 	c.Assert(dup.StopLoss, check.Equals, t.StopLoss)
 	c.Assert(dup.TakeProfit, check.Equals, t.TakeProfit)
 	c.Assert(dup.TrailingStop, check.Equals, t.TrailingStop)
-	c.Assert(dup.Time.Equal(t.Time), check.Equals, true)
+	c.Assert(dup.Time.Equal(t.Time.Time), check.Equals, true)
 
 	t, err = ts.c.ModifyTrade(t.TradeId, oanda.StopLoss(0.75))
 	c.Assert(err, check.IsNil)
-	c.Assert(t.StopLoss, check.Equals, 0.75)
+	c.Assert(t.StopLoss, check.Equals, oanda.NewDecimal(0.75))
 
 	trades, err := ts.c.Trades()
 	c.Assert(err, check.IsNil)
@@ -63,7 +67,7 @@ func (ts *TestSuite) TestTradeApi(c *check.C) {
 	c.Assert(trades[0].StopLoss, check.Equals, t.StopLoss)
 	c.Assert(trades[0].TakeProfit, check.Equals, t.TakeProfit)
 	c.Assert(trades[0].TrailingStop, check.Equals, t.TrailingStop)
-	c.Assert(trades[0].Time.Equal(t.Time), check.Equals, true)
+	c.Assert(trades[0].Time.Equal(t.Time.Time), check.Equals, true)
 
 	rsp, err := ts.c.CloseTrade(t.TradeId)
 	c.Assert(err, check.IsNil)
@@ -73,3 +77,141 @@ func (ts *TestSuite) TestTradeApi(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(trades, check.HasLen, 0)
 }
+
+func (ts *TestLocalSuite) TestCloseTradeVerifyUnits(c *check.C) {
+	paths := []string{}
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.Method+" "+req.URL.RequestURI())
+		if req.Method == "GET" {
+			return newResponse(http.StatusOK, `{"id": 1, "units": 5}`), nil
+		}
+		return newResponse(http.StatusOK, `{"id": 2, "instrument": "EUR_USD"}`), nil
+	})
+
+	_, err := ts.c.CloseTrade(1, oanda.Units(6), oanda.VerifyUnits{})
+	c.Assert(err, check.Equals, oanda.ErrUnitsExceeded)
+	c.Assert(paths, check.DeepEquals, []string{"GET /v1/accounts/0/trades/1"})
+
+	_, err = ts.c.CloseTrade(1, oanda.Units(6), oanda.VerifyUnits{&oanda.Trade{Units: 5}})
+	c.Assert(err, check.Equals, oanda.ErrUnitsExceeded)
+	c.Assert(paths, check.HasLen, 1)
+
+	rsp, err := ts.c.CloseTrade(1, oanda.Units(2), oanda.VerifyUnits{&oanda.Trade{Units: 5}})
+	c.Assert(err, check.IsNil)
+	c.Assert(rsp.TransactionId, check.Equals, 2)
+	c.Assert(paths[1], check.Equals, "DELETE /v1/accounts/0/trades/1?units=2")
+}
+
+func (ts *TestLocalSuite) TestTradesPaging(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		if q.Get("maxId") == "" {
+			c.Check(q.Get("count"), check.Equals, "2")
+			c.Check(q.Get("instrument"), check.Equals, "EUR_USD")
+			return newResponse(http.StatusOK, `{"trades": [{"id": 178, "units": 1},
+				{"id": 175, "units": 2}]}`), nil
+		}
+		c.Check(q.Get("maxId"), check.Equals, "174")
+		return newResponse(http.StatusOK, `{"trades": [{"id": 170, "units": 3}]}`), nil
+	})
+	trades, err := ts.c.Trades(oanda.Count(2), oanda.Instrument("EUR_USD"))
+	c.Assert(err, check.IsNil)
+	c.Assert(trades.MaxTradeId(), check.Equals, 178)
+	c.Assert(trades.MinTradeId(), check.Equals, 175)
+
+	trades, err = ts.c.Trades(oanda.MaxId(trades.MinTradeId() - 1))
+	c.Assert(err, check.IsNil)
+	c.Assert(trades, check.HasLen, 1)
+	c.Assert(trades[0].TradeId, check.Equals, 170)
+	c.Assert(oanda.Trades{}.MaxTradeId(), check.Equals, 0)
+}
+
+func (ts *TestLocalSuite) TestTradesWithPnL(c *check.C) {
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/v1/accounts/1/trades":
+			return newResponse(http.StatusOK, `{"trades": [
+				{"id": 1, "units": 1000, "side": "buy", "instrument": "EUR_USD", "price": 1.3},
+				{"id": 2, "units": 1000, "side": "sell", "instrument": "USD_JPY",
+				 "price": 103.5, "unrealizedPL": 1.8392}]}`), nil
+		case "/v1/accounts/1":
+			return newResponse(http.StatusOK, `{"accountId": 0, "accountCurrency": "USD"}`), nil
+		case "/v1/instruments":
+			return newResponse(http.StatusOK, `{"instruments": [
+				{"instrument": "EUR_USD"}, {"instrument": "USD_JPY"}]}`), nil
+		case "/v1/prices":
+			return newResponse(http.StatusOK, `{"prices": [
+				{"instrument": "EUR_USD", "bid": 1.3706, "ask": 1.37063},
+				{"instrument": "USD_JPY", "bid": 103.3, "ask": 103.31}]}`), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "not found"}`), nil
+	})
+
+	trades, err := ts.c.TradesWithPnL()
+	c.Assert(err, check.IsNil)
+	c.Assert(trades, check.HasLen, 2)
+	c.Assert(math.Abs(trades[0].UnrealizedPl-70.6) < 1e-9, check.Equals, true)
+	c.Assert(trades[1].UnrealizedPl, check.Equals, 1.8392)
+
+	// The client-side computation agrees with the value reported by the server.
+	upl := oanda.UnrealizedPl(&trades[1], oanda.PriceTick{Bid: 103.3, Ask: 103.31}, 1/103.305)
+	c.Assert(math.Abs(upl-trades[1].UnrealizedPl) < 1e-4, check.Equals, true)
+}
+
+func (ts *TestLocalSuite) TestWatchTradeNoAccount(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Errorf("unexpected request for %s", req.URL.Path)
+		return newResponse(http.StatusNotFound, ""), nil
+	})
+	updC, errC := ts.c.WatchTrade(context.Background(), 0, 42, time.Millisecond)
+	c.Assert(<-errC, check.Equals, oanda.ErrNoAccount)
+	_, ok := <-updC
+	c.Assert(ok, check.Equals, false)
+	_, ok = <-errC
+	c.Assert(ok, check.Equals, false)
+}
+
+func (ts *TestLocalSuite) TestWatchTrade(c *check.C) {
+	trade := `{"id": 42, "units": 2, "instrument": "EUR_USD", "side": "buy", "price": 1.25%s}`
+	polls := []*http.Response{
+		newResponse(http.StatusOK, fmt.Sprintf(trade, "")),
+		newResponse(http.StatusOK, fmt.Sprintf(trade, "")),
+		newResponse(http.StatusNotModified, ""),
+		newResponse(http.StatusOK, fmt.Sprintf(trade, `, "stopLoss": 1.2`)),
+		newResponse(http.StatusNotFound, `{"code": 43, "message": "Trade not found"}`),
+	}
+	client := oanda.NewTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/v1/accounts/3/trades/42":
+			rsp := polls[0]
+			polls = polls[1:]
+			return rsp, nil
+		case "/v1/accounts/3/transactions":
+			return newResponse(http.StatusOK, `{"transactions": [
+				{"id": 50, "accountId": 3, "type": "STOP_LOSS_FILLED", "tradeId": 42, "pl": -1},
+				{"id": 49, "accountId": 3, "type": "TRADE_UPDATE", "tradeId": 42}]}`), nil
+		}
+		return newResponse(http.StatusNotFound, `{"code": 1, "message": "not found"}`), nil
+	}))
+
+	updC, errC := client.WatchTrade(context.Background(), 3, 42, time.Millisecond)
+	var updates []oanda.TradeUpdate
+	for u := range updC {
+		updates = append(updates, u)
+	}
+	_, ok := <-errC
+	c.Assert(ok, check.Equals, false)
+
+	// The unchanged and not modified polls were skipped.
+	c.Assert(updates, check.HasLen, 3)
+	c.Assert(updates[0].Trade.StopLoss, check.Equals, oanda.Decimal{})
+	c.Assert(updates[1].Trade.StopLoss, check.Equals, oanda.NewDecimal(1.2))
+	c.Assert(updates[1].Closed, check.Equals, false)
+	c.Assert(updates[2].Closed, check.Equals, true)
+	c.Assert(updates[2].Trade, check.Equals, updates[1].Trade)
+	c.Assert(updates[2].Reason(), check.Equals, "STOP_LOSS_FILLED")
+	closed, ok := updates[2].Closing.(*oanda.TradeCloseEvent)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(closed.Pl(), check.Equals, -1.0)
+}
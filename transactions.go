@@ -0,0 +1,445 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Transaction
+
+// Transaction holds the fields that are common to all entries in an account's transaction
+// history. The complete message is retained in RawMessage so that fields that are specific to
+// a transaction type remain accessible.
+type Transaction struct {
+	TranId         int             `json:"id"`
+	AccountId      int             `json:"accountId"`
+	Time           Time            `json:"time"`
+	Type           string          `json:"type"`
+	Instrument     string          `json:"instrument"`
+	Units          int             `json:"units"`
+	Price          float64         `json:"price"`
+	Pl             float64         `json:"pl"`
+	Interest       float64         `json:"interest"`
+	AccountBalance float64         `json:"accountBalance"`
+	Amount         float64         `json:"amount"`
+	RawMessage     json.RawMessage `json:"-"`
+}
+
+// String implements the fmt.Stringer interface.
+func (t *Transaction) String() string {
+	return fmt.Sprintf("Transaction{TranId: %d, AccountId: %d, Type: %s}", t.TranId, t.AccountId,
+		t.Type)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	type transaction Transaction
+	v := (*transaction)(t)
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	t.RawMessage = append(t.RawMessage[:0], data...)
+	return nil
+}
+
+// AsEvent converts the transaction into one of the typed events that are also returned by
+// PollEvents, see ParseEvent.
+func (t *Transaction) AsEvent() (Event, error) {
+	return ParseEvent(t.RawMessage)
+}
+
+// maxTransactionCount is the maximum number of transactions that Oanda returns per request.
+const maxTransactionCount = 500
+
+// Transactions returns the transactions of the selected account that match the optional
+// arguments, most recent first.  Supported optional arguments are MaxId, MinId, Count, Instrument
+// and Ids.  Use TransactionsIterator to page through histories that are longer than Count.
+//
+// See http://developer.oanda.com/docs/v1/transactions/#get-transaction-history for further
+// information.
+func (c *Client) Transactions(args ...EventsArg) ([]Transaction, error) {
+	return c.transactionsContext(context.Background(), args...)
+}
+
+func (c *Client) transactionsContext(ctx context.Context, args ...EventsArg) ([]Transaction,
+	error) {
+
+	u, err := url.Parse(fmt.Sprintf("/v1/accounts/%d/transactions", c.SelectedAccount()))
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for _, arg := range args {
+		arg.applyEventsArg(q)
+	}
+	u.RawQuery = q.Encode()
+
+	v := struct {
+		ApiError
+		Transactions []Transaction `json:"transactions"`
+	}{}
+	if err = getAndDecodeContext(ctx, c, u.String(), &v); err != nil {
+		return nil, err
+	}
+	return v.Transactions, nil
+}
+
+// Transaction returns the transaction with id tranId of the selected account.  An *ApiError is
+// returned if there is no such transaction.
+func (c *Client) Transaction(tranId int) (*Transaction, error) {
+	v := struct {
+		ApiError
+		Transaction
+	}{}
+	urlStr := fmt.Sprintf("/v1/accounts/%d/transactions/%d", c.SelectedAccount(), tranId)
+	if err := getAndDecode(c, urlStr, &v); err != nil {
+		return nil, err
+	}
+	return &v.Transaction, nil
+}
+
+// TxIterator pages backwards through the transaction history of the selected account.  See
+// Client method TransactionsIterator().
+type TxIterator struct {
+	c     *Client
+	args  []EventsArg
+	nArgs int
+	page  []Transaction
+	value Transaction
+	done  bool
+	err   error
+}
+
+// TransactionsIterator returns an iterator over the transactions of the selected account that
+// match the optional arguments of Client method Transactions(), most recent first.  Pages of
+// transactions are requested as the iterator advances; Count sets the page size.
+//
+//	it := c.TransactionsIterator(oanda.Count(500))
+//	for it.Next() {
+//		t := it.Value()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+func (c *Client) TransactionsIterator(args ...EventsArg) *TxIterator {
+	return &TxIterator{
+		c:     c,
+		args:  append([]EventsArg(nil), args...),
+		nArgs: len(args),
+	}
+}
+
+// Next advances the iterator to the next transaction and returns true if there is one.  Next
+// returns false when the history is exhausted or a request failed; see Err().
+func (it *TxIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		if it.page, it.err = it.c.Transactions(it.args...); it.err != nil {
+			return false
+		}
+		if len(it.page) == 0 {
+			it.done = true
+			return false
+		}
+		last := it.page[len(it.page)-1].TranId
+		it.done = last <= 1
+		// The MaxId of the next page overrides any MaxId of the caller.
+		it.args = append(it.args[:it.nArgs:it.nArgs], MaxId(last-1))
+	}
+	it.value, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Value returns the transaction at the current position of the iterator.
+func (it *TxIterator) Value() Transaction {
+	return it.value
+}
+
+// Err returns the error of the request that ended the iteration, if any.
+func (it *TxIterator) Err() error {
+	return it.err
+}
+
+// transactionsBetween pages through the transaction history of the selected account and returns
+// the transactions between from and to, inclusive, in chronological order.  A zero to includes
+// all transactions after from.
+func (c *Client) transactionsBetween(from, to time.Time) ([]Transaction, error) {
+	result := []Transaction{}
+	args := []EventsArg{Count(maxTransactionCount)}
+	for {
+		trans, err := c.Transactions(args...)
+		if err != nil {
+			return nil, err
+		}
+		done := len(trans) < maxTransactionCount
+		for _, t := range trans {
+			if t.Time.Before(from) {
+				done = true
+				break
+			}
+			if to.IsZero() || !t.Time.After(to) {
+				result = append(result, t)
+			}
+		}
+		if done || trans[len(trans)-1].TranId <= 1 {
+			break
+		}
+		args = []EventsArg{Count(maxTransactionCount), MaxId(trans[len(trans)-1].TranId - 1)}
+	}
+	// Oanda returns the most recent transactions first.
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
+}
+
+// MarginCallHistory returns the margin calls of the selected account between from and to,
+// inclusive, in chronological order.  A zero to includes all margin calls up to now.  The result
+// includes an event for each trade that was liquidated in a margin closeout.
+func (c *Client) MarginCallHistory(from, to time.Time) ([]MarginCallEvent, error) {
+	trans, err := c.transactionsBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return marginCallEvents(trans)
+}
+
+func marginCallEvents(trans []Transaction) ([]MarginCallEvent, error) {
+	events := []MarginCallEvent{}
+	for _, t := range trans {
+		switch t.Type {
+		case "MARGIN_CALL_ENTER", "MARGIN_CALL_EXIT", "MARGIN_CLOSEOUT":
+		default:
+			continue
+		}
+		header, body, err := unmarshalEvent(t.RawMessage)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, MarginCallEvent{evtHeader{header}, body})
+	}
+	return events, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// Full transaction history
+
+// AllTransactionsWithProgress downloads the full transaction history of the selected account.
+// If progress is not nil it is invoked with the total number of bytes downloaded so far each
+// time data is read from the archive.
+//
+// The download is aborted as soon as ctx is cancelled, in which case ctx.Err() is returned, and
+// fails if the archive does not become available within two minutes.
+//
+// See http://developer.oanda.com/docs/v1/transactions/#get-full-account-history for further
+// information.
+func (c *Client) AllTransactionsWithProgress(ctx context.Context,
+	progress func(downloadedBytes int64)) ([]Transaction, error) {
+
+	tranUrl, err := c.archiveLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile("", "oanda-transactions-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	n, err := c.downloadArchive(ctx, tranUrl, f, progress)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return readTransactionArchive(f, n)
+}
+
+// AllTransactions returns a reader for the archive with the full transaction history of the
+// selected account, e.g. to export the history without holding it in memory.  The archive is a
+// zip file with a single JSON array of transactions, as provided by the Oanda servers.  Callers
+// must Close() the reader.
+//
+// Reading the archive is aborted as soon as ctx is cancelled.  An error is returned if the
+// archive does not become available within two minutes.
+//
+// See http://developer.oanda.com/docs/v1/transactions/#get-full-account-history for further
+// information.
+func (c *Client) AllTransactions(ctx context.Context) (io.ReadCloser, error) {
+	tranUrl, err := c.archiveLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := c.openArchive(ctx, tranUrl)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return rc, nil
+}
+
+// archiveLocation requests the archive with the full transaction history of the selected account
+// and returns its location.  ErrNoAccount is returned if no account is selected.
+func (c *Client) archiveLocation(ctx context.Context) (*url.URL, error) {
+	if c.SelectedAccount() == 0 {
+		return nil, ErrNoAccount
+	}
+	rctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	urlStr := fmt.Sprintf("/v1/accounts/%d/alltransactions", c.SelectedAccount())
+	req, err := c.NewRequestWithContext(rctx, "GET", urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := c.Do(req)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(rsp.Body, maxErrorBody))
+	rsp.Body.Close()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return nil, decodeResponse(rsp, body, &ApiError{})
+	}
+	u, err := rsp.Location()
+	if err != nil {
+		apiErr := ApiError{}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != 0 {
+			return nil, &apiErr
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// downloadArchive copies the archive at location u to w.
+func (c *Client) downloadArchive(ctx context.Context, u *url.URL, w io.Writer,
+	progress func(int64)) (int64, error) {
+
+	rc, err := c.openArchive(ctx, u)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, &progressReader{r: rc, fn: progress})
+}
+
+// archivePollInterval and archiveAttempts determine how long openArchive waits for the archive.
+var (
+	archivePollInterval = time.Second
+	archiveAttempts     = 120
+)
+
+// openArchive returns the body of the archive at location u. The archive is created
+// asynchronously by the Oanda servers and the request is retried every archivePollInterval until
+// the archive becomes available, ctx is cancelled or archiveAttempts requests have failed.
+func (c *Client) openArchive(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		rsp, err := c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch rsp.StatusCode {
+		case http.StatusOK:
+			return rsp.Body, nil
+		case http.StatusNotFound:
+			rsp.Body.Close()
+			if attempt == archiveAttempts {
+				return nil, fmt.Errorf("transaction history archive not available after %d attempts",
+					attempt)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(archivePollInterval):
+			}
+		default:
+			rsp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %s for transaction history", rsp.Status)
+		}
+	}
+}
+
+func readTransactionArchive(r io.ReaderAt, size int64) ([]Transaction, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) == 0 {
+		return nil, errors.New("transaction history archive is empty")
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	trans := []Transaction{}
+	if err = json.NewDecoder(rc).Decode(&trans); err != nil {
+		return nil, err
+	}
+	return trans, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// private
+
+// progressReader reports the cumulative number of bytes read from r to fn.
+type progressReader struct {
+	r  io.Reader
+	n  int64
+	fn func(int64)
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.n += int64(n)
+		if pr.fn != nil {
+			pr.fn(pr.n)
+		}
+	}
+	return n, err
+}
+
+// contextError returns the error of ctx if it was cancelled, or err otherwise.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -0,0 +1,277 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+func (ts *TestLocalSuite) TestAllTransactionsCancelled(c *check.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ts.c.SelectAccount(1)
+
+	called := false
+	trans, err := ts.c.AllTransactionsWithProgress(ctx, func(int64) { called = true })
+	c.Assert(err, check.Equals, context.Canceled)
+	c.Assert(trans, check.IsNil)
+	c.Assert(called, check.Equals, false)
+}
+
+const marginCallFixture = `[
+	{"id": 10, "accountId": 1, "time": "2014-04-07T18:00:00Z", "type": "MARGIN_CALL_ENTER"},
+	{"id": 11, "accountId": 1, "time": "2014-04-07T18:01:00Z", "type": "MARGIN_CLOSEOUT",
+	 "instrument": "EUR_USD", "units": 100, "side": "sell", "price": 1.25, "pl": -45.5,
+	 "interest": 0, "accountBalance": 54.5, "tradeId": 7},
+	{"id": 12, "accountId": 1, "time": "2014-04-07T18:01:00Z", "type": "DAILY_INTEREST",
+	 "interest": 0.1},
+	{"id": 13, "accountId": 1, "time": "2014-04-07T18:02:00Z", "type": "MARGIN_CALL_EXIT"}
+]`
+
+func (ts *TestLocalSuite) TestMarginCallEvents(c *check.C) {
+	trans := []oanda.Transaction{}
+	c.Assert(json.Unmarshal([]byte(marginCallFixture), &trans), check.IsNil)
+
+	evt, err := trans[0].AsEvent()
+	c.Assert(err, check.IsNil)
+	_, ok := evt.(*oanda.MarginCallEvent)
+	c.Assert(ok, check.Equals, true)
+
+	events, err := oanda.MarginCallEvents(trans)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 3)
+	c.Assert(events[0].Enter(), check.Equals, true)
+	c.Assert(events[1].Closeout(), check.Equals, true)
+	c.Assert(events[1].TradeId(), check.Equals, 7)
+	c.Assert(events[1].Instrument(), check.Equals, "EUR_USD")
+	c.Assert(events[1].Units(), check.Equals, 100)
+	c.Assert(events[1].Pl(), check.Equals, -45.5)
+	c.Assert(events[2].Exit(), check.Equals, true)
+	c.Assert(events[2].TranId(), check.Equals, 13)
+}
+
+func (ts *TestLocalSuite) TestTransactionsIterator(c *check.C) {
+	requests := []string{}
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		requests = append(requests, q.Encode())
+		maxId := 5
+		if v := q.Get("maxId"); v != "" {
+			maxId, _ = strconv.Atoi(v)
+		}
+		count, _ := strconv.Atoi(q.Get("count"))
+		trans := []string{}
+		for id := maxId; id > 0 && len(trans) < count; id-- {
+			trans = append(trans, fmt.Sprintf(`{"id": %d, "type": "FEE", "amount": -1}`, id))
+		}
+		return newResponse(http.StatusOK,
+			`{"transactions": [`+strings.Join(trans, ",")+`]}`), nil
+	})
+
+	it := ts.c.TransactionsIterator(oanda.Count(2), oanda.MaxId(10))
+	ids := []int{}
+	for it.Next() {
+		c.Assert(it.Value().Type, check.Equals, "FEE")
+		ids = append(ids, it.Value().TranId)
+	}
+	c.Assert(it.Err(), check.IsNil)
+	c.Assert(ids, check.DeepEquals, []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1})
+	c.Assert(requests, check.HasLen, 5)
+	c.Assert(requests[1], check.Equals, "count=2&maxId=8")
+
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusBadRequest, `{"code": 1, "message": "Invalid value"}`), nil
+	})
+	it = ts.c.TransactionsIterator()
+	c.Assert(it.Next(), check.Equals, false)
+	c.Assert(it.Err(), check.FitsTypeOf, &oanda.ApiError{})
+}
+
+func (ts *TestLocalSuite) TestPollEvents(c *check.C) {
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Query().Get("minId"), check.Equals, "175000")
+		return newResponse(http.StatusOK, `{"transactions": [{"id": 175001, "accountId": 1,
+			"time": "2014-04-07T18:31:05Z", "type": "ORDER_FILLED", "orderId": 175000}]}`), nil
+	})
+	events, err := ts.c.PollEvents(oanda.MinId(175000))
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].(*oanda.OrderFilledEvent).OrderId(), check.Equals, 175000)
+}
+
+func (ts *TestLocalSuite) TestTransaction(c *check.C) {
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/v1/accounts/1/transactions/1789536248" {
+			return newResponse(http.StatusNotFound, `{"code": 1, "message":
+				"Invalid value specified for 'transactionId'"}`), nil
+		}
+		return newResponse(http.StatusOK, `{"id": 1789536248, "accountId": 1,
+			"time": "2014-04-07T18:31:05Z", "type": "TRADE_CLOSE", "instrument": "EUR_USD",
+			"units": 2, "side": "sell", "price": 1.3706, "pl": 0.5, "interest": 0.01,
+			"accountBalance": 100000.51, "tradeId": 1789536200}`), nil
+	})
+	t, err := ts.c.Transaction(1789536248)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Type, check.Equals, "TRADE_CLOSE")
+	c.Assert(t.AccountId, check.Equals, 1)
+	c.Assert(t.Units, check.Equals, 2)
+	c.Assert(t.Pl, check.Equals, 0.5)
+	c.Assert(t.AccountBalance, check.Equals, 100000.51)
+	v := struct {
+		TradeId int `json:"tradeId"`
+	}{}
+	c.Assert(json.Unmarshal(t.RawMessage, &v), check.IsNil)
+	c.Assert(v.TradeId, check.Equals, 1789536200)
+
+	_, err = ts.c.Transaction(1)
+	c.Assert(err, check.FitsTypeOf, &oanda.ApiError{})
+}
+
+func (ts *TestLocalSuite) TestAllTransactions(c *check.C) {
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("transactions.json")
+	c.Assert(err, check.IsNil)
+	w.Write([]byte(`[{"id": 2, "type": "FEE"}, {"id": 1, "type": "CREATE"}]`))
+	c.Assert(zw.Close(), check.IsNil)
+
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/v1/accounts/1/alltransactions" {
+			rsp := newResponse(http.StatusAccepted, "")
+			rsp.Header.Set("Location", "https://fxtrade.oanda.com/transactionhistory/1.json.zip")
+			return rsp, nil
+		}
+		c.Check(req.URL.Path, check.Equals, "/transactionhistory/1.json.zip")
+		return newResponse(http.StatusOK, buf.String()), nil
+	})
+
+	rc, err := ts.c.AllTransactions(context.Background())
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(rc)
+	c.Assert(err, check.IsNil)
+	c.Assert(rc.Close(), check.IsNil)
+	c.Assert(data, check.DeepEquals, buf.Bytes())
+}
+
+func (ts *TestLocalSuite) TestAllTransactionsWithProgress(c *check.C) {
+	archive := func(files ...string) string {
+		buf := bytes.Buffer{}
+		zw := zip.NewWriter(&buf)
+		for _, data := range files {
+			w, err := zw.Create("transactions.json")
+			c.Assert(err, check.IsNil)
+			w.Write([]byte(data))
+		}
+		c.Assert(zw.Close(), check.IsNil)
+		return buf.String()
+	}
+
+	_, err := ts.c.AllTransactionsWithProgress(context.Background(), nil)
+	c.Assert(err, check.Equals, oanda.ErrNoAccount)
+
+	ts.c.SelectAccount(1)
+	location, status := "https://fxtrade.oanda.com/transactionhistory/1.json.zip", http.StatusOK
+	body := archive(`[{"id": 2, "type": "FEE"}, {"id": 1, "type": "CREATE"}]`)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/v1/accounts/1/alltransactions" {
+			rsp := newResponse(http.StatusAccepted, "")
+			if location != "" {
+				rsp.Header.Set("Location", location)
+			}
+			return rsp, nil
+		}
+		return newResponse(status, body), nil
+	})
+
+	var progress []int64
+	trans, err := ts.c.AllTransactionsWithProgress(context.Background(), func(n int64) {
+		progress = append(progress, n)
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(trans, check.HasLen, 2)
+	c.Assert(trans[0].TranId, check.Equals, 2)
+	c.Assert(progress, check.Not(check.HasLen), 0)
+	c.Assert(progress[len(progress)-1], check.Equals, int64(len(body)))
+
+	// A nil progress func is allowed.
+	trans, err = ts.c.AllTransactionsWithProgress(context.Background(), nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(trans, check.HasLen, 2)
+
+	body = archive()
+	_, err = ts.c.AllTransactionsWithProgress(context.Background(), nil)
+	c.Assert(err, check.ErrorMatches, "transaction history archive is empty")
+
+	body = "not a zip file"
+	_, err = ts.c.AllTransactionsWithProgress(context.Background(), nil)
+	c.Assert(err, check.NotNil)
+
+	status = http.StatusInternalServerError
+	_, err = ts.c.AllTransactionsWithProgress(context.Background(), nil)
+	c.Assert(err, check.ErrorMatches, "unexpected status .* for transaction history")
+
+	// The archive is polled a limited number of times.
+	defer func(d time.Duration, n int) {
+		*oanda.ArchivePollInterval, *oanda.ArchiveAttempts = d, n
+	}(*oanda.ArchivePollInterval, *oanda.ArchiveAttempts)
+	*oanda.ArchivePollInterval, *oanda.ArchiveAttempts = time.Millisecond, 3
+	status = http.StatusNotFound
+	_, err = ts.c.AllTransactionsWithProgress(context.Background(), nil)
+	c.Assert(err, check.ErrorMatches,
+		"transaction history archive not available after 3 attempts")
+
+	location = ""
+	_, err = ts.c.AllTransactionsWithProgress(context.Background(), nil)
+	c.Assert(err, check.Equals, http.ErrNoLocation)
+}
+
+func (ts *TestLocalSuite) TestAllTransactionsUnauthorized(c *check.C) {
+	ts.c.SelectAccount(1)
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/v1/accounts/1/alltransactions")
+		return newResponse(http.StatusUnauthorized, `{"code": 4, "message":
+			"The access token provided does not allow this request to be made"}`), nil
+	})
+
+	_, err := ts.c.AllTransactionsWithProgress(context.Background(), nil)
+	c.Assert(err, check.FitsTypeOf, &oanda.ApiError{})
+	c.Assert(errors.Is(err, oanda.ErrUnauthorized), check.Equals, true)
+	_, err = ts.c.AllTransactions(context.Background())
+	c.Assert(errors.Is(err, oanda.ErrUnauthorized), check.Equals, true)
+
+	ts.c.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return newResponse(http.StatusForbidden, "<html>Forbidden</html>"), nil
+	})
+	_, err = ts.c.AllTransactions(context.Background())
+	c.Assert(err, check.FitsTypeOf, &oanda.HTTPError{})
+	c.Assert(err.(*oanda.HTTPError).StatusCode, check.Equals, http.StatusForbidden)
+}
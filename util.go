@@ -15,13 +15,43 @@
 package oanda
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// maxParallelRequests is the number of requests that Client methods send concurrently, e.g.
+// CandlesRange, CreateOrders and CloseAllPositions.
+const maxParallelRequests = 4
+
+// parallel calls fn for each index from 0 up to n in at most limit goroutines and returns the
+// error of each call.
+func parallel(n, limit int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	work := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < limit && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return errs
+}
+
 type optionalArgs url.Values
 
 func (oa optionalArgs) SetInt(k string, n int) {
@@ -43,7 +73,7 @@ func (oa optionalArgs) SetIntArray(k string, ia []int) {
 		for i, v := range ia {
 			strIds[i] = strconv.Itoa(v)
 		}
-		url.Values(oa).Set("ids", strings.Join(strIds, ","))
+		url.Values(oa).Set(k, strings.Join(strIds, ","))
 	}
 }
 
@@ -58,3 +88,134 @@ func (oa optionalArgs) SetStringer(k string, v fmt.Stringer) {
 func (oa optionalArgs) SetBool(k string, b bool) {
 	url.Values(oa).Set(k, strconv.FormatBool(b))
 }
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	oandaTimeType = reflect.TypeOf(Time{})
+	decimalType   = reflect.TypeOf(Decimal{})
+)
+
+// formEncode returns the form values for the fields of struct v that have an oanda tag. The tag
+// holds the name of the form value optionally followed by ",omitempty".  Fields with a zero value
+// are omitted if the tag includes omitempty and nil pointers are always omitted.  A non-nil
+// pointer is encoded even if it points to a zero value.  Times are encoded in RFC3339 format and
+// floats and Decimals without trailing zeros.
+func formEncode(v interface{}) (url.Values, error) {
+	return formEncodeDate(v, defaultDateFormat)
+}
+
+// formEncodeDate is like formEncode but encodes times in date format df.
+func formEncodeDate(v interface{}, df DateFormat) (url.Values, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot form-encode %s", rv.Type())
+	}
+	data := url.Values{}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		tag := rt.Field(i).Tag.Get("oanda")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name, omitEmpty := tag, false
+		if n := strings.Index(tag, ","); n >= 0 {
+			name, omitEmpty = tag[:n], tag[n+1:] == "omitempty"
+		}
+
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		} else if omitEmpty && isZeroValue(fv) {
+			continue
+		}
+		s, err := formatValue(fv, df)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", rt.Field(i).Name, err)
+		}
+		data.Set(name, s)
+	}
+	return data, nil
+}
+
+// formatTime formats t in date format df, i.e. either in RFC3339 format or as the number of
+// microseconds since the UNIX epoch.
+func formatTime(t time.Time, df DateFormat) string {
+	if strings.EqualFold(string(df), string(DateFormatUNIX)) {
+		return strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 10)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func isZeroValue(v reflect.Value) bool {
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time).IsZero()
+	case oandaTimeType:
+		return v.Interface().(Time).IsZero()
+	case decimalType:
+		return v.Interface().(Decimal).IsZero()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
+
+func formatValue(v reflect.Value, df DateFormat) (string, error) {
+	switch v.Type() {
+	case timeType:
+		return formatTime(v.Interface().(time.Time), df), nil
+	case oandaTimeType:
+		return formatTime(v.Interface().(Time).Time, df), nil
+	case decimalType:
+		return v.Interface().(Decimal).String(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	case reflect.Slice:
+		ss := make([]string, v.Len())
+		for i := range ss {
+			s, err := formatValue(v.Index(i), df)
+			if err != nil {
+				return "", err
+			}
+			ss[i] = s
+		}
+		return strings.Join(ss, ","), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// numberText stores the textual representation of the numbers in JSON object data in the strings
+// that keys map to.  Numbers that are quoted in data are stored without quotes.
+func numberText(data []byte, keys map[string]*string) error {
+	m := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for k, sp := range keys {
+		raw, ok := m[k]
+		if !ok {
+			continue
+		}
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return err
+		}
+		*sp = n.String()
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+func (ts *TestLocalSuite) TestFormEncode(c *check.C) {
+	zero, price := 0.0, 1.25
+	v := struct {
+		Side       string    `oanda:"side"`
+		Units      int       `oanda:"units"`
+		Price      float64   `oanda:"price,omitempty"`
+		Expiry     time.Time `oanda:"expiry,omitempty"`
+		LowerBound *float64  `oanda:"lowerBound"`
+		StopLoss   *float64  `oanda:"stopLoss"`
+		TakeProfit *float64  `oanda:"takeProfit"`
+		Ids        []int     `oanda:"ids,omitempty"`
+		Untagged   string
+	}{
+		Side:       "buy",
+		StopLoss:   &zero,
+		TakeProfit: &price,
+		Untagged:   "ignored",
+	}
+
+	data, err := oanda.FormEncode(&v)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.DeepEquals, url.Values{
+		"side":       {"buy"},
+		"units":      {"0"},
+		"stopLoss":   {"0"},
+		"takeProfit": {"1.25"},
+	})
+
+	v.Price = 1.10000
+	v.Expiry = time.Date(2014, 4, 7, 20, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	v.Ids = []int{1, 2}
+	data, err = oanda.FormEncode(v)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Get("price"), check.Equals, "1.1")
+	c.Assert(data.Get("expiry"), check.Equals, "2014-04-07T18:00:00Z")
+	c.Assert(data.Get("ids"), check.Equals, "1,2")
+
+	_, err = oanda.FormEncode(1)
+	c.Assert(err, check.NotNil)
+}
+
+func (ts *TestLocalSuite) TestFormEncodeDecimalAndTime(c *check.C) {
+	v := struct {
+		Price      oanda.Decimal  `oanda:"price,omitempty"`
+		Bound      oanda.Decimal  `oanda:"bound,omitempty"`
+		StopLoss   *oanda.Decimal `oanda:"stopLoss"`
+		Expiry     oanda.Time     `oanda:"expiry,omitempty"`
+		Since      oanda.Time     `oanda:"since,omitempty"`
+		TakeProfit *oanda.Decimal `oanda:"takeProfit"`
+	}{
+		Price:    oanda.NewDecimal(1.37060),
+		StopLoss: &oanda.Decimal{},
+		Expiry:   oanda.Time{Time: time.Date(2014, 4, 7, 20, 0, 0, 0, time.FixedZone("CEST", 2*60*60))},
+	}
+
+	data, err := oanda.FormEncode(&v)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.DeepEquals, url.Values{
+		"price":    {"1.3706"},
+		"stopLoss": {"0"},
+		"expiry":   {"2014-04-07T18:00:00Z"},
+	})
+}
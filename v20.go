@@ -0,0 +1,151 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// jsonContentType is the content type of the request bodies of the v20 REST API.
+var jsonContentType = ContentType("application/json")
+
+// v20DateFormat requests RFC3339 timestamps from the v20 REST API, which uses a different header
+// than the v1 API.
+type v20DateFormat string
+
+func (d v20DateFormat) modify(req *http.Request) {
+	req.Header.Set("Accept-Datetime-Format", string(d))
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+// V20Client
+
+// V20Client is a client for the v20 REST API of Oanda.  It shares the request handling, and
+// hence the ClientOptions, of Client but serves the v3 endpoints, which identify accounts by
+// strings such as "101-004-1234567-001".  Only accounts and pricing are supported so far.
+type V20Client struct {
+	c *Client
+}
+
+// NewV20Client returns a client instance that connects to the v20 REST API of environment env,
+// i.e. fxpractice or fxtrade.  String token is the personal access token of a v20 account.
+//
+// See http://developer.oanda.com/rest-live-v20/introduction/ for further information.
+func NewV20Client(token string, env Environment, opts ...ClientOption) (*V20Client, error) {
+	if token == "" {
+		return nil, errors.New("No v20 access token")
+	}
+	if env != "fxpractice" && env != "fxtrade" {
+		return nil, fmt.Errorf("environment %s does not serve the v20 API", env)
+	}
+	c, err := newClient(opts, env, TokenAuthenticator(token), v20DateFormat("RFC3339"))
+	if err != nil {
+		return nil, err
+	}
+	for i, reqMod := range c.reqMods {
+		if _, ok := reqMod.(ContentType); ok {
+			c.reqMods[i] = jsonContentType
+		}
+	}
+	return &V20Client{c}, nil
+}
+
+// V20AccountProperties identifies an account that is accessible with the token of a V20Client.
+type V20AccountProperties struct {
+	Id   string   `json:"id"`
+	Tags []string `json:"tags"`
+}
+
+// Accounts returns the accounts that are accessible with the token of the client.
+func (vc *V20Client) Accounts() ([]V20AccountProperties, error) {
+	v := struct {
+		ApiError
+		Accounts []V20AccountProperties `json:"accounts"`
+	}{}
+	if err := getAndDecode(vc.c, "/v3/accounts", &v); err != nil {
+		return nil, err
+	}
+	return v.Accounts, nil
+}
+
+// V20AccountSummary holds the balance and margin of a v20 account.
+type V20AccountSummary struct {
+	Id                string  `json:"id"`
+	Alias             string  `json:"alias"`
+	Currency          string  `json:"currency"`
+	Balance           Decimal `json:"balance"`
+	NAV               Decimal `json:"NAV"`
+	UnrealizedPL      Decimal `json:"unrealizedPL"`
+	PL                Decimal `json:"pl"`
+	MarginRate        Decimal `json:"marginRate"`
+	MarginUsed        Decimal `json:"marginUsed"`
+	MarginAvailable   Decimal `json:"marginAvailable"`
+	OpenTradeCount    int     `json:"openTradeCount"`
+	OpenPositionCount int     `json:"openPositionCount"`
+	PendingOrderCount int     `json:"pendingOrderCount"`
+	HedgingEnabled    bool    `json:"hedgingEnabled"`
+}
+
+// AccountSummary returns the summary of the account with id accountId.
+func (vc *V20Client) AccountSummary(accountId string) (*V20AccountSummary, error) {
+	v := struct {
+		ApiError
+		Account V20AccountSummary `json:"account"`
+	}{}
+	urlStr := fmt.Sprintf("/v3/accounts/%s/summary", url.PathEscape(accountId))
+	if err := getAndDecode(vc.c, urlStr, &v); err != nil {
+		return nil, err
+	}
+	return &v.Account, nil
+}
+
+// V20Price holds the bid and ask prices of an instrument at a point in time.  Bids and Asks
+// hold the available liquidity at multiple price levels, best price first.
+type V20Price struct {
+	Instrument  string        `json:"instrument"`
+	Time        Time          `json:"time"`
+	Tradeable   bool          `json:"tradeable"`
+	Bids        []PriceBucket `json:"bids"`
+	Asks        []PriceBucket `json:"asks"`
+	CloseoutBid Decimal       `json:"closeoutBid"`
+	CloseoutAsk Decimal       `json:"closeoutAsk"`
+}
+
+// Pricing returns the current prices of instruments for the account with id accountId.
+func (vc *V20Client) Pricing(accountId string, instrument string,
+	instruments ...string) ([]V20Price, error) {
+
+	instruments = append([]string{instrument}, instruments...)
+	for i, instr := range instruments {
+		instruments[i] = strings.ToUpper(instr)
+		if err := validateInstrument(instruments[i]); err != nil {
+			return nil, err
+		}
+	}
+	q := url.Values{"instruments": {strings.Join(instruments, ",")}}
+	urlStr := fmt.Sprintf("/v3/accounts/%s/pricing?%s", url.PathEscape(accountId), q.Encode())
+	v := struct {
+		ApiError
+		Prices []V20Price `json:"prices"`
+	}{}
+	if err := getAndDecode(vc.c, urlStr, &v); err != nil {
+		return nil, err
+	}
+	return v.Prices, nil
+}
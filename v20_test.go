@@ -0,0 +1,74 @@
+// Copyright 2014 Tjerk Santegoeds
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oanda_test
+
+import (
+	"net/http"
+
+	"github.com/santegoeds/oanda"
+
+	"gopkg.in/check.v1"
+)
+
+func (ts *TestLocalSuite) TestV20Client(c *check.C) {
+	responses := map[string]string{
+		"/v3/accounts": `{"accounts": [{"id": "101-004-1234567-001", "tags": []}]}`,
+		"/v3/accounts/101-004-1234567-001/summary": `{"account": {
+			"id": "101-004-1234567-001", "currency": "USD", "balance": "100000.0000",
+			"NAV": "100012.5000", "marginUsed": "250.0000", "openTradeCount": 1}}`,
+		"/v3/accounts/101-004-1234567-001/pricing": `{"prices": [{"instrument": "EUR_USD",
+			"time": "2016-06-22T18:41:36.201836422Z", "tradeable": true,
+			"bids": [{"price": "1.13015", "liquidity": 10000000}],
+			"asks": [{"price": "1.13028", "liquidity": 10000000}],
+			"closeoutBid": "1.13015", "closeoutAsk": "1.13028"}]}`,
+	}
+	client, err := oanda.NewV20Client("token", "fxpractice",
+		oanda.WithRoundTripper(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			c.Assert(req.URL.Host, check.Equals, "api-fxpractice.oanda.com")
+			c.Assert(req.Header.Get("Authorization"), check.Equals, "Bearer token")
+			c.Assert(req.Header.Get("Accept-Datetime-Format"), check.Equals, "RFC3339")
+			body, ok := responses[req.URL.Path]
+			if !ok {
+				return newResponse(404, `{"errorMessage": "Not found"}`), nil
+			}
+			return newResponse(200, body), nil
+		})))
+	c.Assert(err, check.IsNil)
+
+	accounts, err := client.Accounts()
+	c.Assert(err, check.IsNil)
+	c.Assert(accounts, check.HasLen, 1)
+	id := accounts[0].Id
+	c.Assert(id, check.Equals, "101-004-1234567-001")
+
+	summary, err := client.AccountSummary(id)
+	c.Assert(err, check.IsNil)
+	c.Assert(summary.NAV.String(), check.Equals, "100012.5")
+	c.Assert(summary.OpenTradeCount, check.Equals, 1)
+
+	prices, err := client.Pricing(id, "eur_usd")
+	c.Assert(err, check.IsNil)
+	c.Assert(prices, check.HasLen, 1)
+	c.Assert(prices[0].Tradeable, check.Equals, true)
+	c.Assert(prices[0].Bids[0].Price, check.Equals, 1.13015)
+	c.Assert(prices[0].CloseoutAsk, check.Equals, oanda.NewDecimal(1.13028))
+	c.Assert(prices[0].Time.Nanosecond(), check.Equals, 201836422)
+
+	_, err = client.AccountSummary("unknown")
+	c.Assert(err, check.FitsTypeOf, &oanda.HTTPError{})
+
+	_, err = oanda.NewV20Client("token", "sandbox")
+	c.Assert(err, check.ErrorMatches, "environment sandbox does not serve the v20 API")
+}